@@ -1,5 +1,7 @@
 package drive
 
+import "time"
+
 type User struct {
 	Id          string   `bson:"id" json:"id"`
 	Name        string   `bson:"name" json:"name"`
@@ -9,11 +11,111 @@ type User struct {
 }
 
 type Resource struct {
-	Id        string   `bson:"id" json:"id"`
-	Name      string   `bson:"name" json:"name"`
-	OwnerId   string   `bson:"ownerId" json:"ownerId"`
-	SharedId  []string `bson:"sharedId" json:"sharedId"`
-	Location  string   `bson:"location" json:"location"`
-	Type      string   `bson:"type" json:"type"`
-	Content   []string `bson:"content" json:"content"`
-}
\ No newline at end of file
+	Id        string     `bson:"id" json:"id"`
+	Name      string     `bson:"name" json:"name"`
+	OwnerId   string     `bson:"ownerId" json:"ownerId"`
+	SharedId  []string   `bson:"sharedId" json:"sharedId"`
+	Location  string     `bson:"location" json:"location"`
+	Type      string     `bson:"type" json:"type"`
+	Content   []string   `bson:"content" json:"content"`
+	Size      int64      `bson:"size" json:"size"`
+	ModTime   time.Time  `bson:"modTime" json:"modTime"`
+	Hash      string     `bson:"hash" json:"hash"`
+	Parent    string     `bson:"parent" json:"parent"`
+	Ancestors []string   `bson:"ancestors" json:"ancestors"`
+	NoInherit bool       `bson:"noInherit" json:"noInherit"`
+	Tags      []string   `bson:"tags,omitempty" json:"tags,omitempty"`
+	Version   int        `bson:"version" json:"version"`
+	Versions  []Version  `bson:"versions" json:"versions"`
+	Retention *Retention `bson:"retention,omitempty" json:"retention,omitempty"`
+	Trashed   bool       `bson:"trashed" json:"trashed"`
+	TrashRoot string     `bson:"trashRoot" json:"trashRoot"`
+	TrashedBy string     `bson:"trashedBy" json:"trashedBy"`
+	TrashedAt time.Time  `bson:"trashedAt" json:"trashedAt"`
+	KeyId     string     `bson:"keyId,omitempty" json:"keyId,omitempty"`
+}
+
+type Version struct {
+	Number   int       `bson:"number" json:"number"`
+	Location string    `bson:"location" json:"location"`
+	Size     int64     `bson:"size" json:"size"`
+	Hash     string    `bson:"hash" json:"hash"`
+	AuthorId string    `bson:"authorId" json:"authorId"`
+	ModTime  time.Time `bson:"modTime" json:"modTime"`
+	KeyId    string    `bson:"keyId,omitempty" json:"keyId,omitempty"`
+}
+
+// Blob counts the files pointing to stored content. Encrypted content
+// keeps its data key here, wrapped by the master key named by KeyId.
+type Blob struct {
+	Hash    string `bson:"hash" json:"hash"`
+	Refs    int    `bson:"refs" json:"refs"`
+	KeyId   string `bson:"keyId" json:"keyId"`
+	DataKey []byte `bson:"dataKey" json:"dataKey"`
+}
+
+// Retention limits how many old versions of a file are kept. Zero values
+// mean no limit.
+type Retention struct {
+	KeepVersions int `bson:"keepVersions" json:"keepVersions"`
+	KeepDays     int `bson:"keepDays" json:"keepDays"`
+}
+
+type Upload struct {
+	Id        string    `bson:"id" json:"id"`
+	Name      string    `bson:"name" json:"name"`
+	OwnerId   string    `bson:"ownerId" json:"ownerId"`
+	ParentId  string    `bson:"parentId" json:"parentId"`
+	Location  string    `bson:"location" json:"location"`
+	Length    int64     `bson:"length" json:"length"`
+	Offset    int64     `bson:"offset" json:"offset"`
+	CreatedAt time.Time `bson:"createdAt" json:"createdAt"`
+}
+
+// ShareLink gives anyone holding its token access to a resource without
+// logging in. Only hashes of the token and of the password are stored.
+type ShareLink struct {
+	Id           string    `bson:"id" json:"id"`
+	TokenHash    string    `bson:"tokenHash" json:"tokenHash,omitempty"`
+	ResourceId   string    `bson:"resourceId" json:"resourceId"`
+	OwnerId      string    `bson:"ownerId" json:"ownerId"`
+	Mode         string    `bson:"mode" json:"mode"`
+	Password     string    `bson:"password" json:"password,omitempty"`
+	ExpiresAt    time.Time `bson:"expiresAt" json:"expiresAt"`
+	MaxDownloads int       `bson:"maxDownloads" json:"maxDownloads"`
+	Downloads    int       `bson:"downloads" json:"downloads"`
+	CreatedAt    time.Time `bson:"createdAt" json:"createdAt"`
+}
+
+// Grant gives a user, or every member of a group, a role on a resource.
+// Only one of UserId and GroupId is set. Roles map to the operations they
+// allow in the auth package.
+type Grant struct {
+	ResourceId string    `bson:"resourceId" json:"resourceId"`
+	UserId     string    `bson:"userId" json:"userId,omitempty"`
+	GroupId    string    `bson:"groupId" json:"groupId,omitempty"`
+	Role       string    `bson:"role" json:"role"`
+	GrantedBy  string    `bson:"grantedBy" json:"grantedBy"`
+	GrantedAt  time.Time `bson:"grantedAt" json:"grantedAt"`
+}
+
+// Group lets grants, shares and permissions target several users at once.
+// Its members, the owner included, hold its Permissions as their own.
+type Group struct {
+	Id          string    `bson:"id" json:"id"`
+	Name        string    `bson:"name" json:"name"`
+	OwnerId     string    `bson:"ownerId" json:"ownerId"`
+	Members     []string  `bson:"members" json:"members"`
+	Permissions []string  `bson:"permissions" json:"permissions"`
+	CreatedAt   time.Time `bson:"createdAt" json:"createdAt"`
+}
+
+// Revocation invalidates the token or token family Jti or, when Jti is
+// empty, every token of UserId issued before IssuedBefore. It is kept until ExpiresAt, when
+// the tokens it covers have expired anyway.
+type Revocation struct {
+	Jti          string    `bson:"jti" json:"jti"`
+	UserId       string    `bson:"userId" json:"userId"`
+	IssuedBefore time.Time `bson:"issuedBefore" json:"issuedBefore"`
+	ExpiresAt    time.Time `bson:"expiresAt" json:"expiresAt"`
+}
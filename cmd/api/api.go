@@ -1,36 +1,87 @@
 package api
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"net/http"
+	"os"
+	"time"
 
 	"github.com/c4me-caro/drive/cmd/auth"
+	"github.com/c4me-caro/drive/cmd/fsck"
+	"github.com/c4me-caro/drive/cmd/policy"
 	"github.com/c4me-caro/drive/database"
 	"github.com/c4me-caro/drive/service/driver"
 	"github.com/c4me-caro/drive/service/user"
+	"github.com/c4me-caro/drive/storage"
 	"github.com/gorilla/mux"
 )
 
 type APIServer struct {
-	addr string
-	db   *database.DriveWorker
+	addr  string
+	db    database.Store
+	store storage.Backend
+	keys  *storage.Keyring
 }
 
-func NewApiServer(addr string, db *database.DriveWorker) *APIServer {
+func NewApiServer(addr string, db database.Store, store storage.Backend, keys *storage.Keyring) *APIServer {
 	return &APIServer{
-		addr: addr,
-		db:   db,
+		addr:  addr,
+		db:    db,
+		store: store,
+		keys:  keys,
 	}
 }
 
 func (s *APIServer) Run() error {
+	auth.UseGrants(s.db)
+
+	if file := os.Getenv("POLICY_FILE"); file != "" {
+		engine, err := policy.Load(file)
+		if err != nil {
+			return err
+		}
+
+		auth.UsePolicy(engine)
+	}
+
+	if ttl, err := time.ParseDuration(os.Getenv("PERMISSION_CACHE_TTL")); err == nil && ttl >= 0 {
+		auth.SetCacheTTL(ttl)
+	}
+
+	if err := auth.UseRevocations(s.db); err != nil {
+		return err
+	}
+
+	revocationSync := time.Minute
+	if interval, err := time.ParseDuration(os.Getenv("REVOCATION_SYNC_INTERVAL")); err == nil && interval > 0 {
+		revocationSync = interval
+	}
+
+	auth.StartRevocationSync(revocationSync)
+
+	if watcher, ok := s.db.(database.PermissionWatcher); ok {
+		go watchPermissions(watcher)
+	}
+
 	router := mux.NewRouter().StrictSlash(true)
 	subrouter := router.PathPrefix("/drive").Subrouter()
 
 	userHandler := user.NewHandler(s.db)
 	userHandler.RegisterRoutes(router)
 
-	driverHandler := driver.NewHandler(s.db)
+	driverHandler := driver.NewHandler(s.db, s.store, s.keys)
 	driverHandler.RegisterRoutes(subrouter)
+	driverHandler.RegisterShareRoutes(router)
+	driverHandler.StartTrashExpiry(time.Hour)
+	driverHandler.StartUploadExpiry(time.Hour)
+	driverHandler.StartVersionExpiry(time.Hour)
+
+	if interval, err := time.ParseDuration(os.Getenv("FSCK_INTERVAL")); err == nil && interval > 0 {
+		checker := fsck.NewChecker(s.db, s.store, os.Getenv("FILES_ROOT"))
+		checker.Start(interval, os.Getenv("FSCK_REPAIR") == "true")
+	}
 
 	router.Use(auth.HandleAuthorization)
 	// router.Use(auth.HandleApiKey)
@@ -42,3 +93,28 @@ func (s *APIServer) Run() error {
 
 	return service.ListenAndServe()
 }
+
+// watchPermissions keeps the permission cache in step with writes made by
+// other instances. Changes missed while the watch was down are covered by
+// dropping the whole cache before watching again.
+func watchPermissions(watcher database.PermissionWatcher) {
+	for {
+		err := watcher.WatchPermissions(context.Background(), func(change database.PermissionChange) {
+			if change.All {
+				auth.InvalidateAll()
+				return
+			}
+
+			auth.InvalidateUser(change.Users...)
+			auth.InvalidateGrants(change.Resources...)
+		})
+
+		if errors.Is(err, database.ErrNoChangeStreams) {
+			return
+		}
+
+		fmt.Println("permission watch:", err)
+		auth.InvalidateAll()
+		time.Sleep(10 * time.Second)
+	}
+}
@@ -0,0 +1,128 @@
+package auth
+
+import (
+	"sync"
+	"time"
+
+	"github.com/c4me-caro/drive"
+)
+
+// maxCacheEntries bounds each cache; when full, expired entries are swept
+// and, if that is not enough, the cache starts over.
+const maxCacheEntries = 10000
+
+type cacheEntry[V any] struct {
+	value   V
+	expires time.Time
+}
+
+// ttlCache keeps what FindPermission reads from the store for a while.
+// Entries go away when they expire or are invalidated.
+type ttlCache[V any] struct {
+	mu      sync.RWMutex
+	entries map[string]cacheEntry[V]
+}
+
+func newTTLCache[V any]() *ttlCache[V] {
+	return &ttlCache[V]{entries: make(map[string]cacheEntry[V])}
+}
+
+var (
+	cacheMu  sync.RWMutex
+	cacheTTL = 30 * time.Second
+)
+
+var (
+	groupCache = newTTLCache[[]drive.Group]()
+	grantCache = newTTLCache[[]drive.Grant]()
+)
+
+// SetCacheTTL sets how long groups and grants are cached. Zero turns the
+// cache off.
+func SetCacheTTL(ttl time.Duration) {
+	cacheMu.Lock()
+	cacheTTL = ttl
+	cacheMu.Unlock()
+
+	InvalidateAll()
+}
+
+func currentTTL() time.Duration {
+	cacheMu.RLock()
+	defer cacheMu.RUnlock()
+	return cacheTTL
+}
+
+func (c *ttlCache[V]) get(key string) (V, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	entry, ok := c.entries[key]
+	if !ok || time.Now().After(entry.expires) {
+		var zero V
+		return zero, false
+	}
+
+	return entry.value, true
+}
+
+func (c *ttlCache[V]) set(key string, value V) {
+	ttl := currentTTL()
+	if ttl <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if len(c.entries) >= maxCacheEntries {
+		for key, entry := range c.entries {
+			if now.After(entry.expires) {
+				delete(c.entries, key)
+			}
+		}
+
+		if len(c.entries) >= maxCacheEntries {
+			c.entries = make(map[string]cacheEntry[V])
+		}
+	}
+
+	c.entries[key] = cacheEntry[V]{value: value, expires: now.Add(ttl)}
+}
+
+func (c *ttlCache[V]) drop(keys ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, key := range keys {
+		delete(c.entries, key)
+	}
+}
+
+func (c *ttlCache[V]) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries = make(map[string]cacheEntry[V])
+}
+
+// InvalidateUser forgets the cached groups of users, to be called when
+// they join or leave a group or the permissions of one of their groups
+// change.
+func InvalidateUser(userIds ...string) {
+	groupCache.drop(userIds...)
+}
+
+// InvalidateGrants forgets the cached grants on resources, to be called
+// whenever a grant on them is saved or deleted.
+func InvalidateGrants(resourceIds ...string) {
+	grantCache.drop(resourceIds...)
+}
+
+// InvalidateAll forgets everything cached, for changes that do not tell
+// what they touched.
+func InvalidateAll() {
+	groupCache.clear()
+	grantCache.clear()
+}
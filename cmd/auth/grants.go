@@ -0,0 +1,158 @@
+package auth
+
+import (
+	"slices"
+
+	"github.com/c4me-caro/drive"
+	"github.com/c4me-caro/drive/cmd/policy"
+)
+
+const (
+	RoleViewer    = "viewer"
+	RoleCommenter = "commenter"
+	RoleEditor    = "editor"
+)
+
+var roleAccess = map[string][]string{
+	RoleViewer:    {"read"},
+	RoleCommenter: {"read", "comment"},
+	RoleEditor:    {"read", "comment", "create", "update", "delete"},
+}
+
+// GrantStore is where FindPermission looks up the roles given on a
+// resource and on the folders above it, the groups of users and the
+// folders making up the path of a resource.
+type GrantStore interface {
+	ListGrants(resourceIds ...string) ([]drive.Grant, error)
+	ListGroups(userId string) ([]drive.Group, error)
+	GetResource(search string) (drive.Resource, error)
+}
+
+var grantStore GrantStore
+
+// UseGrants makes FindPermission honour the grants and groups kept in
+// store.
+func UseGrants(store GrantStore) {
+	grantStore = store
+	InvalidateAll()
+}
+
+func ValidRole(role string) bool {
+	_, ok := roleAccess[role]
+	return ok
+}
+
+func RoleAllows(role string, access string) bool {
+	return slices.Contains(roleAccess[role], access)
+}
+
+// Ancestors returns the folders a resource placed in parent inherits
+// grants from, nearest last. A resource breaking inheritance inherits
+// nothing, so the ancestors of everything below it start with it.
+func Ancestors(resource drive.Resource, parent drive.Resource) []string {
+	if resource.NoInherit || parent.Id == "" || parent.Id == "0" {
+		return []string{}
+	}
+
+	return append(slices.Clone(parent.Ancestors), parent.Id)
+}
+
+// userGroups returns the groups user belongs to, cached for a short while
+// and forgotten when memberships change.
+func userGroups(user drive.User) []drive.Group {
+	if grantStore == nil {
+		return nil
+	}
+
+	if groups, ok := groupCache.get(user.Id); ok {
+		return groups
+	}
+
+	groups, err := grantStore.ListGroups(user.Id)
+	if err != nil {
+		return nil
+	}
+
+	groupCache.set(user.Id, groups)
+	return groups
+}
+
+// listGrants returns the grants on resourceIds, reading only those not
+// cached from the store.
+func listGrants(resourceIds []string) ([]drive.Grant, error) {
+	grants := []drive.Grant{}
+	missing := []string{}
+	for _, id := range resourceIds {
+		cached, ok := grantCache.get(id)
+		if !ok {
+			missing = append(missing, id)
+			continue
+		}
+
+		grants = append(grants, cached...)
+	}
+
+	if len(missing) == 0 {
+		return grants, nil
+	}
+
+	found, err := grantStore.ListGrants(missing...)
+	if err != nil {
+		return nil, err
+	}
+
+	byResource := make(map[string][]drive.Grant, len(missing))
+	for _, grant := range found {
+		byResource[grant.ResourceId] = append(byResource[grant.ResourceId], grant)
+	}
+
+	for _, id := range missing {
+		grantCache.set(id, byResource[id])
+	}
+
+	return append(grants, found...), nil
+}
+
+func inGroups(groups []drive.Group, id string) bool {
+	return slices.ContainsFunc(groups, func(group drive.Group) bool {
+		return group.Id == id
+	})
+}
+
+// grantRules turns the grants to user or to one of its groups on resource
+// or on one of its stored ancestors into rules, so inherited access costs
+// a single lookup.
+func grantRules(user drive.User, groups []drive.Group, resource drive.Resource) []policy.Rule {
+	if grantStore == nil {
+		return nil
+	}
+
+	grants, err := listGrants(append([]string{resource.Id}, resource.Ancestors...))
+	if err != nil {
+		return nil
+	}
+
+	rules := []policy.Rule{}
+	for _, grant := range grants {
+		rule := policy.Rule{
+			Name:      "grant " + grant.Role + " on " + grant.ResourceId,
+			Effect:    policy.Allow,
+			Actions:   roleAccess[grant.Role],
+			Resources: policy.Resources{Under: []string{grant.ResourceId}},
+		}
+
+		switch {
+		case grant.UserId != "" && grant.UserId == user.Id:
+			rule.Subjects.Users = []string{user.Id}
+		case grant.GroupId != "" && inGroups(groups, grant.GroupId):
+			rule.Name += " to group " + grant.GroupId
+			rule.Subjects.Groups = []string{grant.GroupId}
+		default:
+			continue
+		}
+
+		rules = append(rules, rule)
+	}
+
+	return rules
+}
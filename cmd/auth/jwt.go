@@ -1,19 +1,35 @@
 package auth
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 	"github.com/joho/godotenv"
 )
 
 var secret = []byte("RVBIYURVQOB/V%#QB")
-var revokedTokens []string
+
+var (
+	issuer          = "drive"
+	audience        = "drive"
+	accessLifetime  = 15 * time.Minute
+	refreshLifetime = 72 * time.Hour
+)
+
+// What a token may be used for, in its use claim. Refresh tokens are only
+// accepted by RefreshJWT, access tokens everywhere else.
+const (
+	useAccess  = "access"
+	useRefresh = "refresh"
+)
 
 func init() {
 	godotenv.Load()
@@ -21,13 +37,37 @@ func init() {
 	if msecret != "" {
 		secret = []byte(msecret)
 	}
+
+	if miss := os.Getenv("JWT_ISSUER"); miss != "" {
+		issuer = miss
+	}
+
+	if maud := os.Getenv("JWT_AUDIENCE"); maud != "" {
+		audience = maud
+	}
+
+	if ttl, err := time.ParseDuration(os.Getenv("ACCESS_TOKEN_TTL")); err == nil && ttl > 0 {
+		accessLifetime = ttl
+	}
+
+	if ttl, err := time.ParseDuration(os.Getenv("REFRESH_TOKEN_TTL")); err == nil && ttl > 0 {
+		refreshLifetime = ttl
+	}
 }
 
-func CreateJWT(userId string) (string, error) {
+// signToken issues a token of family, the session every token refreshed
+// from the same login belongs to.
+func signToken(userId string, family string, use string, lifetime time.Duration) (string, error) {
+	now := time.Now()
 	claims := &jwt.MapClaims{
-		"authorized": true,
-		"user_id":    userId,
-		"exp":        time.Now().Add(time.Hour * 72).Unix(),
+		"iss": issuer,
+		"aud": audience,
+		"sub": userId,
+		"jti": uuid.New().String(),
+		"iat": float64(now.UnixMicro()) / 1e6,
+		"exp": now.Add(lifetime).Unix(),
+		"fam": family,
+		"use": use,
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
@@ -40,29 +80,38 @@ func CreateJWT(userId string) (string, error) {
 	return tokenString, nil
 }
 
+// CreateJWT starts a session for userId.
+func CreateJWT(userId string) (Tokens, error) {
+	return issueTokens(userId, uuid.New().String())
+}
+
 func HandleAuthorization(next http.Handler) http.Handler {
 	return http.HandlerFunc(
 		func(w http.ResponseWriter, r *http.Request) {
-			URLS := []string{"/login", "/logout"}
-			skip_urls := strings.Join(URLS, " ")
+			// Only these exact routes manage their own tokens.
+			URLS := []string{"/login", "/logout", "/token/refresh"}
+
+			if slices.Contains(URLS, r.URL.Path) {
+				next.ServeHTTP(w, r)
+				return
+			}
 
-			if strings.Contains(skip_urls, r.URL.String()) {
+			// Share links carry their own token in the path.
+			if strings.HasPrefix(r.URL.Path, "/s/") {
 				next.ServeHTTP(w, r)
 				return
 			}
 
-			bearerToken := r.Header["Authorization"][0]
+			bearerToken := r.Header.Get("Authorization")
 			token := strings.TrimPrefix(bearerToken, "Bearer ")
 
-			for _, revokedToken := range revokedTokens {
-				if token == revokedToken {
-					w.WriteHeader(http.StatusUnauthorized)
-					io.WriteString(w, "Error: Token has been revoked")
-					return
-				}
+			_, err := ValidateJWT(token)
+			if errors.Is(err, ErrTokenRevoked) {
+				w.WriteHeader(http.StatusUnauthorized)
+				io.WriteString(w, "Error: Token has been revoked")
+				return
 			}
 
-			_, err := ValidateJWT(token)
 			if err != nil {
 				w.WriteHeader(http.StatusUnauthorized)
 				io.WriteString(w, "Error: Token is not valid in middleware: " + err.Error())
@@ -73,35 +122,67 @@ func HandleAuthorization(next http.Handler) http.Handler {
 		})
 }
 
-func ValidateJWT(token string) (*jwt.Token, error) {
-	if token != "" {
-		tk, err := jwt.Parse(token, func(t *jwt.Token) (interface{}, error) {
-			if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("sign method not allowed: %v", t.Header["alg"])
-			}
-			return secret, nil
-		})
+// parseToken checks the signature and the standard claims of a token
+// meant for use.
+func parseToken(token string, use string) (*jwt.Token, error) {
+	if token == "" {
+		return nil, fmt.Errorf("invalid token: %s", token)
+	}
 
-		if err != nil {
-			return nil, err
+	tk, err := jwt.Parse(token, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("sign method not allowed: %v", t.Header["alg"])
 		}
+		return secret, nil
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithIssuer(issuer),
+		jwt.WithAudience(audience),
+		jwt.WithIssuedAt(),
+		jwt.WithExpirationRequired(),
+	)
 
-		if tk.Valid {
-			return tk, nil
-		}
+	if err != nil {
+		return nil, err
 	}
 
-	return nil, fmt.Errorf("invalid token: %s", token)
+	claims := tk.Claims.(jwt.MapClaims)
+	if !tk.Valid || claims["use"] != use || stringClaim(claims, "sub") == "" || stringClaim(claims, "jti") == "" || stringClaim(claims, "fam") == "" {
+		return nil, fmt.Errorf("invalid %s token", use)
+	}
+
+	return tk, nil
 }
 
+func stringClaim(claims jwt.MapClaims, name string) string {
+	value, _ := claims[name].(string)
+	return value
+}
+
+// ValidateJWT accepts access tokens that are neither expired nor revoked.
+func ValidateJWT(token string) (*jwt.Token, error) {
+	tk, err := parseToken(token, useAccess)
+	if err != nil {
+		return nil, err
+	}
+
+	if isRevoked(tk.Claims.(jwt.MapClaims)) {
+		return nil, ErrTokenRevoked
+	}
+
+	return tk, nil
+}
+
+// InvalidateToken ends the session of an access token, revoking it along
+// with every token refreshed from the same login.
 func InvalidateToken(token string) error {
-	_, err := ValidateJWT(token)
+	token = strings.TrimPrefix(token, "Bearer ")
+	tk, err := ValidateJWT(token)
 	if err != nil {
 		return err
 	}
 
-	revokedTokens = append(revokedTokens, token)
-	return nil
+	return revokeFamily(tk.Claims.(jwt.MapClaims))
 }
 
 func GetUserIdFromToken(token string) (string, error) {
@@ -111,5 +192,5 @@ func GetUserIdFromToken(token string) (string, error) {
 	}
 
 	claims := tk.Claims.(jwt.MapClaims)
-	return claims["user_id"].(string), nil
+	return stringClaim(claims, "sub"), nil
 }
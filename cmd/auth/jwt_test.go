@@ -0,0 +1,53 @@
+package auth
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHandleAuthorization(t *testing.T) {
+	handler := HandleAuthorization(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+
+	tokens, err := CreateJWT("u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		path  string
+		token string
+		code  int
+	}{
+		{"/login", "", http.StatusOK},
+		{"/logout", "", http.StatusOK},
+		{"/token/refresh", "", http.StatusOK},
+		{"/s/token", "", http.StatusOK},
+		{"/drive/d/x", tokens.AccessToken, http.StatusOK},
+		{"/drive/d/x", "Bearer " + tokens.AccessToken, http.StatusOK},
+		{"/drive/d/x", "", http.StatusUnauthorized},
+		{"/drive/d/x", tokens.RefreshToken, http.StatusUnauthorized},
+		// Skipped routes are matched whole, not as parts of other paths.
+		{"/drive/p/x/token/refresh", "", http.StatusUnauthorized},
+		{"/drive/p/login", "", http.StatusUnauthorized},
+		{"/log", "", http.StatusUnauthorized},
+		{"/", "", http.StatusUnauthorized},
+		{"/login?next=/drive", "", http.StatusOK},
+	}
+
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", c.path, nil)
+		if c.token != "" {
+			r.Header.Set("Authorization", c.token)
+		}
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != c.code {
+			t.Errorf("%s with %q: status = %d, want %d", c.path, c.token, w.Code, c.code)
+		}
+	}
+}
@@ -1,86 +1,164 @@
 package auth
 
 import (
-	"sync"
+	"slices"
+	"strings"
 
 	"github.com/c4me-caro/drive"
+	"github.com/c4me-caro/drive/cmd/policy"
 )
 
-var permissionCache sync.Map
+var engine, _ = policy.New(nil)
 
+// UsePolicy makes FindPermission apply the rules of e besides the
+// permissions, grants and shares of users.
+func UsePolicy(e *policy.Engine) {
+	engine = e
+}
+
+// systemRules hold the access the drive gives whatever the policy.
+var systemRules = []policy.Rule{{
+	Name:      "admins manage the drive",
+	Effect:    policy.Allow,
+	Subjects:  policy.Subjects{Roles: []string{"admin"}},
+	Actions:   []string{"create", "update"},
+	Resources: policy.Resources{System: true},
+}}
+
+// FindPermission returns the name of the rule allowing user access on
+// resource, or nothing when the access is denied.
 func FindPermission(user drive.User, access string, resource drive.Resource) string {
-	if resource.Id == "0" {
-		return handleSystemResource(user, access)
+	decision := evaluate(user, access, resource, false)
+	if !decision.Allowed {
+		return ""
 	}
 
-	sharedPermission := access + ":" + user.Id + "-" + resource.Name
-	if validatePermission(user, sharedPermission) && searchSharedId(user, resource) {
-		return sharedPermission
+	return decision.Rule
+}
+
+// Explain tells which rule allows or denies user access on resource, with
+// the reason every rule matched or not.
+func Explain(user drive.User, access string, resource drive.Resource) policy.Decision {
+	return evaluate(user, access, resource, true)
+}
+
+func evaluate(user drive.User, access string, resource drive.Resource, explain bool) policy.Decision {
+	groups := userGroups(user)
+	subject := policy.Subject{Id: user.Id, Role: user.Role, Groups: []string{}}
+	for _, group := range groups {
+		subject.Groups = append(subject.Groups, group.Id)
 	}
 
-	candidates := make([]string, 0, 7)
-	candidates = append(candidates, access+":"+resource.Name)
-	candidates = append(candidates, access+":all")
-	candidates = append(candidates, "all:"+resource.Name)
+	request := policy.Request{
+		Subject:  subject,
+		Action:   access,
+		Resource: resource,
+		System:   resource.Id == "0",
+		Path: func() string {
+			return resourcePath(resource)
+		},
+	}
 
-	if user.Id == resource.OwnerId {
-		candidates = append(candidates, access+"own-"+resource.Name)
-		candidates = append(candidates, access+"own-all")
-		candidates = append(candidates, "all:own-all")
+	principals := append([]string{user.Id}, subject.Groups...)
+	rules := slices.Clone(systemRules)
+	rules = append(rules, permissionRules(user.Permissions, principals, "")...)
+	for _, group := range groups {
+		rules = append(rules, permissionRules(group.Permissions, principals, " of group "+group.Name)...)
 	}
 
-	candidates = append(candidates, "all:all")
+	if !request.System {
+		rules = append(rules, grantRules(user, groups, resource)...)
+	}
 
-	for _, candidate := range candidates {
-		if validatePermission(user, candidate) {
-			return candidate
-		}
+	if explain {
+		return engine.Explain(request, rules...)
 	}
 
-	return ""
+	return engine.Evaluate(request, rules...)
 }
 
-func handleSystemResource(user drive.User, access string) string {
-	if access == "read" {
-		if validatePermission(user, "read:sys-all") {
-			return "read:sys-all"
+// permissionRules turns permissions like read:all, all:own-all or
+// update:reports into rules. A permission naming a principal before the
+// resource name, like read:<id>-reports, applies when the resource is
+// shared with that principal.
+func permissionRules(permissions []string, principals []string, source string) []policy.Rule {
+	rules := []policy.Rule{}
+	for _, permission := range permissions {
+		action, target, ok := strings.Cut(permission, ":")
+		if !ok {
+			// Owned resources once had to be written without the colon,
+			// like readown-all.
+			action, target, ok = strings.Cut(permission, "own-")
+			target = "own-" + target
 		}
 
-		if validatePermission(user, "all:sys-all") {
-			return "all:sys-all"
+		if !ok || action == "" {
+			continue
 		}
-	}
 
-	if user.Role == "admin" && (access == "create" || access == "update") {
-		return access + ":sys-all"
-	}
+		actions := []string{action}
+		if action == "all" {
+			actions = []string{policy.AnyAction}
+		}
 
-	return ""
-}
+		rule := policy.Rule{
+			Name:    "permission " + permission + source,
+			Effect:  policy.Allow,
+			Actions: actions,
+		}
 
-func validatePermission(user drive.User, access string) bool {
-	if cached, ok := permissionCache.Load(user.Id); ok {
-		permSet := cached.(map[string]struct{})
-		_, exists := permSet[access]
-		return exists
-	}
+		switch {
+		case target == "sys-all":
+			// The drive itself is only ever read through permissions.
+			if action != "read" && action != "all" {
+				continue
+			}
+
+			rule.Actions = []string{"read"}
+			rule.Resources.System = true
+		case target == "all":
+		case target == "own-all":
+			rule.Subjects.Owner = true
+		case strings.HasPrefix(target, "own-"):
+			rule.Subjects.Owner = true
+			rule.Resources.Names = []string{strings.TrimPrefix(target, "own-")}
+		default:
+			rule.Resources.Names = []string{target}
+			for _, principal := range principals {
+				if name, ok := strings.CutPrefix(target, principal+"-"); ok {
+					shared := rule
+					shared.Resources = policy.Resources{Names: []string{name}, SharedWith: []string{principal}}
+					rules = append(rules, shared)
+				}
+			}
+		}
 
-	permSet := make(map[string]struct{})
-	for _, p := range user.Permissions {
-		permSet[p] = struct{}{}
+		rules = append(rules, rule)
 	}
-	permissionCache.Store(user.Id, permSet)
 
-	_, exists := permSet[access]
-	return exists
+	return rules
 }
 
-func searchSharedId(user drive.User, resource drive.Resource) bool {
-	for _, id := range resource.SharedId {
-		if id == user.Id {
-			return true
+// resourcePath returns the names from the top level down to resource,
+// like /docs/reports.
+func resourcePath(resource drive.Resource) string {
+	names := []string{resource.Name}
+	seen := map[string]struct{}{resource.Id: {}}
+	for current := resource; grantStore != nil && current.Parent != "" && current.Parent != "0"; {
+		parent, err := grantStore.GetResource(current.Parent)
+		if err != nil {
+			break
+		}
+
+		if _, loop := seen[parent.Id]; loop {
+			break
 		}
+
+		seen[parent.Id] = struct{}{}
+		names = append(names, parent.Name)
+		current = parent
 	}
 
-	return false
+	slices.Reverse(names)
+	return "/" + strings.Join(names, "/")
 }
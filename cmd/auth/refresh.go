@@ -0,0 +1,78 @@
+package auth
+
+import (
+	"errors"
+
+	"github.com/c4me-caro/drive"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// ErrTokenReused is returned when a refresh token comes back after it was
+// exchanged, which means it leaked: the whole session is revoked.
+var ErrTokenReused = errors.New("refresh token reused, session revoked")
+
+// Tokens is what a login or a refresh hands out. ExpiresIn is the lifetime
+// of the access token in seconds.
+type Tokens struct {
+	AccessToken  string `json:"accessToken"`
+	RefreshToken string `json:"refreshToken"`
+	ExpiresIn    int64  `json:"expiresIn"`
+}
+
+func issueTokens(userId string, family string) (Tokens, error) {
+	access, err := signToken(userId, family, useAccess, accessLifetime)
+	if err != nil {
+		return Tokens{}, err
+	}
+
+	refresh, err := signToken(userId, family, useRefresh, refreshLifetime)
+	if err != nil {
+		return Tokens{}, err
+	}
+
+	return Tokens{
+		AccessToken:  access,
+		RefreshToken: refresh,
+		ExpiresIn:    int64(accessLifetime.Seconds()),
+	}, nil
+}
+
+// RefreshJWT exchanges a refresh token for new tokens of the same session.
+// Each refresh token is good for one exchange; allowed may still refuse
+// the user, e.g. when the account is gone.
+func RefreshJWT(token string, allowed func(userId string) error) (Tokens, error) {
+	tk, err := parseToken(token, useRefresh)
+	if err != nil {
+		return Tokens{}, err
+	}
+
+	claims := tk.Claims.(jwt.MapClaims)
+	if familyRevoked(claims) {
+		return Tokens{}, ErrTokenRevoked
+	}
+
+	userId := stringClaim(claims, "sub")
+	if err := allowed(userId); err != nil {
+		return Tokens{}, err
+	}
+
+	added, err := revoked.add(drive.Revocation{
+		Jti:       stringClaim(claims, "jti"),
+		UserId:    userId,
+		ExpiresAt: claimTime(claims, claims.GetExpirationTime),
+	})
+
+	if err != nil {
+		return Tokens{}, err
+	}
+
+	if !added {
+		if err := revokeFamily(claims); err != nil {
+			return Tokens{}, err
+		}
+
+		return Tokens{}, ErrTokenReused
+	}
+
+	return issueTokens(userId, stringClaim(claims, "fam"))
+}
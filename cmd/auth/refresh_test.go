@@ -0,0 +1,200 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/c4me-caro/drive/database"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// useRevocationStore starts the test with an empty revocation store.
+func useRevocationStore(t *testing.T) *database.MemoryStore {
+	t.Helper()
+
+	store := database.NewMemoryStore()
+	if err := UseRevocations(store); err != nil {
+		t.Fatal(err)
+	}
+
+	return store
+}
+
+func allowAll(string) error {
+	return nil
+}
+
+func claimsOf(t *testing.T, token string, use string) jwt.MapClaims {
+	t.Helper()
+
+	tk, err := parseToken(token, use)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tk.Claims.(jwt.MapClaims)
+}
+
+func TestRefreshJWT(t *testing.T) {
+	useRevocationStore(t)
+
+	tokens, err := CreateJWT("u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	refreshed, err := RefreshJWT(tokens.RefreshToken, allowAll)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if refreshed.RefreshToken == tokens.RefreshToken || refreshed.AccessToken == tokens.AccessToken {
+		t.Fatal("tokens were not rotated")
+	}
+
+	old, current := claimsOf(t, tokens.RefreshToken, useRefresh), claimsOf(t, refreshed.RefreshToken, useRefresh)
+	if stringClaim(old, "fam") != stringClaim(current, "fam") || stringClaim(current, "sub") != "u1" {
+		t.Fatalf("refreshed claims %v do not continue the session %v", current, old)
+	}
+
+	// Earlier access tokens of the session stay valid until they expire.
+	for _, token := range []string{tokens.AccessToken, refreshed.AccessToken} {
+		if _, err := ValidateJWT(token); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := RefreshJWT(refreshed.RefreshToken, allowAll); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRefreshJWTReuse(t *testing.T) {
+	useRevocationStore(t)
+
+	tokens, err := CreateJWT("u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	refreshed, err := RefreshJWT(tokens.RefreshToken, allowAll)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := RefreshJWT(tokens.RefreshToken, allowAll); !errors.Is(err, ErrTokenReused) {
+		t.Fatalf("err = %v, want ErrTokenReused", err)
+	}
+
+	// A reuse takes the whole session down, the legitimate copy included.
+	if _, err := ValidateJWT(refreshed.AccessToken); !errors.Is(err, ErrTokenRevoked) {
+		t.Fatalf("err = %v, want ErrTokenRevoked", err)
+	}
+
+	if _, err := RefreshJWT(refreshed.RefreshToken, allowAll); !errors.Is(err, ErrTokenRevoked) {
+		t.Fatalf("err = %v, want ErrTokenRevoked", err)
+	}
+
+	// Other sessions of the user are not affected.
+	other, err := CreateJWT("u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := RefreshJWT(other.RefreshToken, allowAll); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRefreshJWTReuseAfterRestart(t *testing.T) {
+	store := useRevocationStore(t)
+
+	tokens, err := CreateJWT("u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := RefreshJWT(tokens.RefreshToken, allowAll); err != nil {
+		t.Fatal(err)
+	}
+
+	// Another instance, or this one restarted, learns of the exchange from
+	// the store.
+	if err := UseRevocations(store); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := RefreshJWT(tokens.RefreshToken, allowAll); !errors.Is(err, ErrTokenReused) {
+		t.Fatalf("err = %v, want ErrTokenReused", err)
+	}
+}
+
+func TestRefreshJWTRejects(t *testing.T) {
+	useRevocationStore(t)
+
+	tokens, err := CreateJWT("u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := RefreshJWT(tokens.AccessToken, allowAll); err == nil {
+		t.Fatal("an access token was exchanged")
+	}
+
+	if _, err := ValidateJWT(tokens.RefreshToken); err == nil {
+		t.Fatal("a refresh token was accepted for access")
+	}
+
+	expired, err := signToken("u1", "family", useRefresh, -time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := RefreshJWT(expired, allowAll); err == nil {
+		t.Fatal("an expired refresh token was exchanged")
+	}
+
+	gone := errors.New("user not found")
+	if _, err := RefreshJWT(tokens.RefreshToken, func(string) error { return gone }); !errors.Is(err, gone) {
+		t.Fatalf("err = %v, want the refusal", err)
+	}
+
+	// A refused exchange does not use the token up.
+	if _, err := RefreshJWT(tokens.RefreshToken, allowAll); err != nil {
+		t.Fatal(err)
+	}
+
+	saved := issuer
+	issuer = "elsewhere"
+	foreign, err := signToken("u1", "family", useRefresh, time.Minute)
+	issuer = saved
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := RefreshJWT(foreign, allowAll); err == nil {
+		t.Fatal("a token of another issuer was exchanged")
+	}
+}
+
+func TestInvalidateToken(t *testing.T) {
+	useRevocationStore(t)
+
+	tokens, err := CreateJWT("u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := InvalidateToken("Bearer " + tokens.AccessToken); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ValidateJWT(tokens.AccessToken); !errors.Is(err, ErrTokenRevoked) {
+		t.Fatalf("err = %v, want ErrTokenRevoked", err)
+	}
+
+	if _, err := RefreshJWT(tokens.RefreshToken, allowAll); !errors.Is(err, ErrTokenRevoked) {
+		t.Fatalf("err = %v, want ErrTokenRevoked", err)
+	}
+}
@@ -0,0 +1,208 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/c4me-caro/drive"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var ErrTokenRevoked = errors.New("token has been revoked")
+
+// RevocationStore is where revoked tokens are kept, so every instance
+// rejects them and they survive restarts.
+type RevocationStore interface {
+	SaveRevocation(revocation drive.Revocation) error
+	AddRevocation(revocation drive.Revocation) (bool, error)
+	ListRevocations(now time.Time) ([]drive.Revocation, error)
+	PruneRevocations(now time.Time) error
+}
+
+// revocationList mirrors the store in memory, so checking a token needs no
+// query: tokens maps revoked jti to expiry, users maps user ids to the
+// time before which their tokens are revoked.
+type revocationList struct {
+	mu     sync.RWMutex
+	store  RevocationStore
+	tokens map[string]time.Time
+	users  map[string]time.Time
+}
+
+var revoked = &revocationList{
+	tokens: make(map[string]time.Time),
+	users:  make(map[string]time.Time),
+}
+
+// UseRevocations keeps revocations in store and loads those still active.
+func UseRevocations(store RevocationStore) error {
+	revoked.mu.Lock()
+	revoked.store = store
+	revoked.mu.Unlock()
+
+	return SyncRevocations()
+}
+
+// SyncRevocations prunes expired revocations from the store and reloads
+// the others, picking up those made by other instances.
+func SyncRevocations() error {
+	revoked.mu.RLock()
+	store := revoked.store
+	revoked.mu.RUnlock()
+
+	if store == nil {
+		return nil
+	}
+
+	now := time.Now()
+	if err := store.PruneRevocations(now); err != nil {
+		return err
+	}
+
+	revocations, err := store.ListRevocations(now)
+	if err != nil {
+		return err
+	}
+
+	tokens := make(map[string]time.Time)
+	users := make(map[string]time.Time)
+	for _, revocation := range revocations {
+		if revocation.Jti != "" {
+			tokens[revocation.Jti] = revocation.ExpiresAt
+			continue
+		}
+
+		if revocation.IssuedBefore.After(users[revocation.UserId]) {
+			users[revocation.UserId] = revocation.IssuedBefore
+		}
+	}
+
+	revoked.mu.Lock()
+	revoked.tokens = tokens
+	revoked.users = users
+	revoked.mu.Unlock()
+
+	return nil
+}
+
+// StartRevocationSync runs SyncRevocations every interval.
+func StartRevocationSync(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if err := SyncRevocations(); err != nil {
+				fmt.Println("revocation sync:", err)
+			}
+		}
+	}()
+}
+
+func claimTime(claims jwt.MapClaims, read func() (*jwt.NumericDate, error)) time.Time {
+	date, err := read()
+	if err != nil || date == nil {
+		return time.Time{}
+	}
+
+	return date.Time
+}
+
+// issuedAt keeps the fraction of the iat claim, which NumericDate drops, so
+// a session revoked in the same second as a new login does not take it.
+func issuedAt(claims jwt.MapClaims) time.Time {
+	iat, ok := claims["iat"].(float64)
+	if !ok {
+		return time.Time{}
+	}
+
+	return time.UnixMicro(int64(iat * 1e6))
+}
+
+// isRevoked tells whether the token itself, its family or every token of
+// its user issued until then was revoked.
+func isRevoked(claims jwt.MapClaims) bool {
+	revoked.mu.RLock()
+	_, ok := revoked.tokens[stringClaim(claims, "jti")]
+	revoked.mu.RUnlock()
+
+	return ok || familyRevoked(claims)
+}
+
+func familyRevoked(claims jwt.MapClaims) bool {
+	revoked.mu.RLock()
+	defer revoked.mu.RUnlock()
+
+	if _, ok := revoked.tokens[stringClaim(claims, "fam")]; ok {
+		return true
+	}
+
+	before, ok := revoked.users[stringClaim(claims, "sub")]
+	return ok && issuedAt(claims).Before(before)
+}
+
+func (list *revocationList) save(revocation drive.Revocation) error {
+	list.mu.Lock()
+	defer list.mu.Unlock()
+
+	if list.store != nil {
+		if err := list.store.SaveRevocation(revocation); err != nil {
+			return err
+		}
+	}
+
+	if revocation.Jti != "" {
+		list.tokens[revocation.Jti] = revocation.ExpiresAt
+	} else if revocation.IssuedBefore.After(list.users[revocation.UserId]) {
+		list.users[revocation.UserId] = revocation.IssuedBefore
+	}
+
+	return nil
+}
+
+// add revokes a token unless it already was, telling whether it did. The
+// store settles races between instances.
+func (list *revocationList) add(revocation drive.Revocation) (bool, error) {
+	list.mu.Lock()
+	defer list.mu.Unlock()
+
+	if _, ok := list.tokens[revocation.Jti]; ok {
+		return false, nil
+	}
+
+	if list.store != nil {
+		added, err := list.store.AddRevocation(revocation)
+		if err != nil || !added {
+			return false, err
+		}
+	}
+
+	list.tokens[revocation.Jti] = revocation.ExpiresAt
+	return true, nil
+}
+
+// sessionLifetime is how long the tokens issued now may stay valid.
+func sessionLifetime() time.Duration {
+	return max(accessLifetime, refreshLifetime)
+}
+
+// revokeFamily revokes every token of the session a token belongs to.
+func revokeFamily(claims jwt.MapClaims) error {
+	return revoked.save(drive.Revocation{
+		Jti:       stringClaim(claims, "fam"),
+		UserId:    stringClaim(claims, "sub"),
+		ExpiresAt: time.Now().Add(sessionLifetime()),
+	})
+}
+
+// RevokeUserSessions revokes every token issued to userId so far.
+func RevokeUserSessions(userId string) error {
+	now := time.Now()
+	return revoked.save(drive.Revocation{
+		UserId:       userId,
+		IssuedBefore: now,
+		ExpiresAt:    now.Add(sessionLifetime()),
+	})
+}
@@ -0,0 +1,93 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/c4me-caro/drive"
+)
+
+func TestRevokeUserSessions(t *testing.T) {
+	store := useRevocationStore(t)
+
+	before, err := CreateJWT("u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other, err := CreateJWT("u2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := RevokeUserSessions("u1"); err != nil {
+		t.Fatal(err)
+	}
+
+	after, err := CreateJWT("u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The revocation survives a restart.
+	if err := UseRevocations(store); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ValidateJWT(before.AccessToken); !errors.Is(err, ErrTokenRevoked) {
+		t.Fatalf("err = %v, want ErrTokenRevoked", err)
+	}
+
+	if _, err := RefreshJWT(before.RefreshToken, allowAll); !errors.Is(err, ErrTokenRevoked) {
+		t.Fatalf("err = %v, want ErrTokenRevoked", err)
+	}
+
+	for _, token := range []string{after.AccessToken, other.AccessToken} {
+		if _, err := ValidateJWT(token); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSyncRevocations(t *testing.T) {
+	store := useRevocationStore(t)
+
+	tokens, err := CreateJWT("u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Another instance revokes the session straight in the store.
+	family := stringClaim(claimsOf(t, tokens.AccessToken, useAccess), "fam")
+	expired := drive.Revocation{Jti: "old", UserId: "u1", ExpiresAt: time.Now().Add(-time.Minute)}
+	for _, revocation := range []drive.Revocation{{Jti: family, UserId: "u1", ExpiresAt: time.Now().Add(time.Hour)}, expired} {
+		if err := store.SaveRevocation(revocation); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := ValidateJWT(tokens.AccessToken); err != nil {
+		t.Fatal("the revocation was seen before a sync")
+	}
+
+	if err := SyncRevocations(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ValidateJWT(tokens.AccessToken); !errors.Is(err, ErrTokenRevoked) {
+		t.Fatalf("err = %v, want ErrTokenRevoked", err)
+	}
+
+	// Revocations of tokens that expired anyway are pruned.
+	revocations, err := store.ListRevocations(time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, revocation := range revocations {
+		if revocation.Jti == expired.Jti {
+			t.Fatal("an expired revocation was kept")
+		}
+	}
+}
@@ -0,0 +1,507 @@
+package fsck
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/c4me-caro/drive"
+	"github.com/c4me-caro/drive/cmd/auth"
+	"github.com/c4me-caro/drive/database"
+	"github.com/c4me-caro/drive/storage"
+)
+
+// gracePeriod keeps blobs written by requests still in flight, whose
+// metadata is not committed yet, out of the orphan list.
+const gracePeriod = time.Hour
+
+type MissingBlob struct {
+	Id       string `json:"id"`
+	Name     string `json:"name"`
+	Location string `json:"location"`
+	Version  int    `json:"version"`
+}
+
+type DanglingContent struct {
+	Id    string `json:"id"`
+	Child string `json:"child"`
+}
+
+type Unreachable struct {
+	Id     string `json:"id"`
+	Name   string `json:"name"`
+	Parent string `json:"parent"`
+}
+
+type Report struct {
+	CheckedAt       time.Time         `json:"checkedAt"`
+	Resources       int               `json:"resources"`
+	Blobs           int               `json:"blobs"`
+	OrphanBlobs     []string          `json:"orphanBlobs"`
+	MissingBlobs    []MissingBlob     `json:"missingBlobs"`
+	DanglingContent []DanglingContent `json:"danglingContent"`
+	Unreachable     []Unreachable     `json:"unreachable"`
+	StaleAncestors  []string          `json:"staleAncestors"`
+	Repaired        bool              `json:"repaired"`
+	Errors          []string          `json:"errors"`
+}
+
+func (r Report) Problems() int {
+	return len(r.OrphanBlobs) + len(r.MissingBlobs) + len(r.DanglingContent) + len(r.Unreachable) + len(r.StaleAncestors)
+}
+
+type Checker struct {
+	db    database.ResourceStore
+	store storage.Backend
+	root  string
+}
+
+// NewChecker compares the metadata in db with the blobs in store. root is
+// the FILES_ROOT used to resolve absolute locations of older resources.
+func NewChecker(db database.ResourceStore, store storage.Backend, root string) *Checker {
+	return &Checker{
+		db:    db,
+		store: store,
+		root:  root,
+	}
+}
+
+// key maps a resource location to the key reported by the backend.
+func (c *Checker) key(location string) string {
+	if c.root != "" && filepath.IsAbs(location) {
+		if rel, err := filepath.Rel(filepath.Clean(c.root), filepath.Clean(location)); err == nil {
+			return filepath.ToSlash(rel)
+		}
+	}
+
+	return location
+}
+
+func locations(resource drive.Resource) []drive.Version {
+	if len(resource.Versions) > 0 {
+		return resource.Versions
+	}
+
+	return []drive.Version{{
+		Number:   resource.Version,
+		Location: resource.Location,
+		Size:     resource.Size,
+		Hash:     resource.Hash,
+	}}
+}
+
+// Run checks the drive and, when repair is set, fixes what it found:
+// orphan blobs are deleted, missing versions are dropped, dangling content
+// is unlinked, unreachable resources are relinked to their parent or
+// moved to the top level and stale ancestor paths are recomputed.
+func (c *Checker) Run(repair bool) (Report, error) {
+	report := Report{
+		CheckedAt:       time.Now().UTC(),
+		OrphanBlobs:     []string{},
+		MissingBlobs:    []MissingBlob{},
+		DanglingContent: []DanglingContent{},
+		Unreachable:     []Unreachable{},
+		StaleAncestors:  []string{},
+		Errors:          []string{},
+	}
+
+	resources, err := c.db.ListResources()
+	if err != nil {
+		return report, err
+	}
+
+	uploads, err := c.db.ListUploads()
+	if err != nil {
+		return report, err
+	}
+
+	keys, err := c.store.List()
+	if err != nil {
+		return report, err
+	}
+
+	report.Resources = len(resources)
+	report.Blobs = len(keys)
+
+	byId := make(map[string]drive.Resource, len(resources))
+	for _, resource := range resources {
+		byId[resource.Id] = resource
+	}
+
+	referenced := make(map[string]struct{})
+	for _, upload := range uploads {
+		referenced[c.key(upload.Location)] = struct{}{}
+	}
+
+	for _, resource := range resources {
+		if resource.Type != "file" {
+			continue
+		}
+
+		for _, version := range locations(resource) {
+			referenced[c.key(version.Location)] = struct{}{}
+		}
+	}
+
+	stored := make(map[string]struct{}, len(keys))
+	for _, key := range keys {
+		stored[key] = struct{}{}
+		if _, ok := referenced[key]; ok {
+			continue
+		}
+
+		info, err := c.store.Stat(key)
+		if err == nil && time.Since(info.ModTime) < gracePeriod {
+			continue
+		}
+
+		report.OrphanBlobs = append(report.OrphanBlobs, key)
+	}
+
+	for _, resource := range resources {
+		if resource.Type != "file" {
+			continue
+		}
+
+		for _, version := range locations(resource) {
+			if _, ok := stored[c.key(version.Location)]; !ok {
+				report.MissingBlobs = append(report.MissingBlobs, MissingBlob{
+					Id:       resource.Id,
+					Name:     resource.Name,
+					Location: version.Location,
+					Version:  version.Number,
+				})
+			}
+		}
+	}
+
+	for _, resource := range resources {
+		for _, child := range resource.Content {
+			if _, ok := byId[child]; !ok {
+				report.DanglingContent = append(report.DanglingContent, DanglingContent{
+					Id:    resource.Id,
+					Child: child,
+				})
+			}
+		}
+	}
+
+	reachable := reachableFrom(resources, byId)
+	for _, resource := range resources {
+		if resource.Trashed {
+			continue
+		}
+
+		if _, ok := reachable[resource.Id]; !ok {
+			report.Unreachable = append(report.Unreachable, Unreachable{
+				Id:     resource.Id,
+				Name:   resource.Name,
+				Parent: resource.Parent,
+			})
+		}
+	}
+
+	for id, ancestors := range expectedAncestors(resources, byId) {
+		if !slices.Equal(byId[id].Ancestors, ancestors) {
+			report.StaleAncestors = append(report.StaleAncestors, id)
+		}
+	}
+
+	slices.Sort(report.StaleAncestors)
+
+	if repair {
+		c.repair(&report, byId, stored, reachable)
+		report.Repaired = true
+	}
+
+	return report, nil
+}
+
+// reachableFrom walks the Content of every top level resource. Trashed
+// resources are left out, since the trash keeps them apart on purpose.
+func reachableFrom(resources []drive.Resource, byId map[string]drive.Resource) map[string]struct{} {
+	held := make(map[string]struct{})
+	for _, resource := range resources {
+		if resource.Trashed {
+			continue
+		}
+
+		for _, child := range resource.Content {
+			held[child] = struct{}{}
+		}
+	}
+
+	queue := []drive.Resource{}
+	reachable := make(map[string]struct{})
+	for _, resource := range resources {
+		if _, ok := held[resource.Id]; ok || resource.Trashed || resource.Parent != "" {
+			continue
+		}
+
+		reachable[resource.Id] = struct{}{}
+		queue = append(queue, resource)
+	}
+
+	for i := 0; i < len(queue); i++ {
+		for _, id := range queue[i].Content {
+			child, ok := byId[id]
+			if !ok || child.Trashed {
+				continue
+			}
+
+			if _, seen := reachable[id]; seen {
+				continue
+			}
+
+			reachable[id] = struct{}{}
+			queue = append(queue, child)
+		}
+	}
+
+	return reachable
+}
+
+// expectedAncestors computes the Ancestors every reachable resource should
+// store, walking down from the top level like reachableFrom.
+func expectedAncestors(resources []drive.Resource, byId map[string]drive.Resource) map[string][]string {
+	reachable := reachableFrom(resources, byId)
+	expected := make(map[string][]string, len(reachable))
+	queue := []drive.Resource{}
+	for _, resource := range resources {
+		if _, ok := reachable[resource.Id]; ok && resource.Parent == "" {
+			expected[resource.Id] = auth.Ancestors(resource, drive.Resource{})
+			queue = append(queue, resource)
+		}
+	}
+
+	for i := 0; i < len(queue); i++ {
+		folder := queue[i]
+		folder.Ancestors = expected[folder.Id]
+		for _, id := range folder.Content {
+			child, ok := byId[id]
+			if _, seen := expected[id]; !ok || seen || child.Trashed {
+				continue
+			}
+
+			expected[id] = auth.Ancestors(child, folder)
+			queue = append(queue, child)
+		}
+	}
+
+	return expected
+}
+
+func (c *Checker) fail(report *Report, err error) {
+	if err != nil {
+		report.Errors = append(report.Errors, err.Error())
+	}
+}
+
+func (c *Checker) repair(report *Report, byId map[string]drive.Resource, stored map[string]struct{}, reachable map[string]struct{}) {
+	for _, key := range report.OrphanBlobs {
+		c.fail(report, c.store.Delete(key))
+	}
+
+	for id := range missingIds(report.MissingBlobs) {
+		c.fail(report, c.repairMissing(byId[id], stored))
+	}
+
+	for _, dangling := range report.DanglingContent {
+		resource, err := c.db.GetResource(dangling.Id)
+		if err != nil {
+			continue
+		}
+
+		c.fail(report, c.db.RemoveResourceChildren(resource, dangling.Child))
+	}
+
+	unreachable := make(map[string]struct{}, len(report.Unreachable))
+	for _, item := range report.Unreachable {
+		unreachable[item.Id] = struct{}{}
+	}
+
+	holders := make(map[string]string)
+	for _, resource := range byId {
+		for _, child := range resource.Content {
+			holders[child] = resource.Id
+		}
+	}
+
+	for _, item := range report.Unreachable {
+		// Only the top of an unreachable subtree needs fixing; the rest
+		// becomes reachable through it.
+		if _, ok := unreachable[item.Parent]; ok {
+			continue
+		}
+
+		if _, ok := unreachable[holders[item.Id]]; ok {
+			continue
+		}
+
+		resource, err := c.db.GetResource(item.Id)
+		if err != nil {
+			continue
+		}
+
+		c.fail(report, c.relink(resource, reachable))
+	}
+
+	if len(report.StaleAncestors) > 0 || len(report.Unreachable) > 0 {
+		c.fail(report, c.repairAncestors())
+	}
+}
+
+// repairAncestors stores the expected Ancestors on every resource, reading
+// the tree again since the repairs above may have moved resources.
+func (c *Checker) repairAncestors() error {
+	resources, err := c.db.ListResources()
+	if err != nil {
+		return err
+	}
+
+	byId := make(map[string]drive.Resource, len(resources))
+	for _, resource := range resources {
+		byId[resource.Id] = resource
+	}
+
+	for id, ancestors := range expectedAncestors(resources, byId) {
+		resource := byId[id]
+		if slices.Equal(resource.Ancestors, ancestors) {
+			continue
+		}
+
+		resource.Ancestors = ancestors
+		if err := c.db.UpdateResource(resource); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func missingIds(missing []MissingBlob) map[string]struct{} {
+	ids := make(map[string]struct{})
+	for _, blob := range missing {
+		ids[blob.Id] = struct{}{}
+	}
+
+	return ids
+}
+
+// repairMissing drops the versions whose bytes are gone, along with their
+// blob references. A file without any readable version is deleted.
+func (c *Checker) repairMissing(resource drive.Resource, stored map[string]struct{}) error {
+	versions := []drive.Version{}
+	missing := make(map[string]struct{})
+	for _, version := range locations(resource) {
+		if _, ok := stored[c.key(version.Location)]; ok {
+			versions = append(versions, version)
+			continue
+		}
+
+		if hash, ok := storage.BlobHash(version.Location); ok {
+			missing[hash] = struct{}{}
+		}
+	}
+
+	release := func(tx database.ResourceStore) error {
+		for hash := range missing {
+			if _, err := tx.ReleaseBlob(hash); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	if len(versions) == 0 {
+		parent, perr := c.db.GetParent(resource.Id)
+		return c.db.Transaction(func(tx database.ResourceStore) error {
+			if perr == nil {
+				if err := tx.RemoveResourceChildren(parent, resource.Id); err != nil {
+					return err
+				}
+			}
+
+			if err := tx.DeleteResource(resource); err != nil {
+				return err
+			}
+
+			return release(tx)
+		})
+	}
+
+	latest := versions[len(versions)-1]
+	resource.Location = latest.Location
+	resource.Size = latest.Size
+	resource.Hash = latest.Hash
+	resource.Version = latest.Number
+	if len(resource.Versions) > 0 {
+		resource.Versions = versions
+		resource.ModTime = latest.ModTime
+	}
+
+	return c.db.Transaction(func(tx database.ResourceStore) error {
+		if err := tx.UpdateResource(resource); err != nil {
+			return err
+		}
+
+		return release(tx)
+	})
+}
+
+// relink puts resource back inside its recorded parent when that folder is
+// reachable, and otherwise moves it to the top level.
+func (c *Checker) relink(resource drive.Resource, reachable map[string]struct{}) error {
+	if _, ok := reachable[resource.Parent]; ok {
+		parent, err := c.db.GetResource(resource.Parent)
+		if err == nil {
+			return c.db.AddResourceChildren(parent, resource.Id)
+		}
+	}
+
+	holder, herr := c.db.GetParent(resource.Id)
+
+	resource.Parent = ""
+	if resource.Type == "folder" {
+		resource.Location = ""
+	}
+
+	if existing, err := c.db.GetChild("", resource.OwnerId, resource.Name); err == nil && existing.Id != resource.Id {
+		resource.Name = fmt.Sprintf("%s (%s)", resource.Name, resource.Id)
+	}
+
+	return c.db.Transaction(func(tx database.ResourceStore) error {
+		if herr == nil {
+			if err := tx.RemoveResourceChildren(holder, resource.Id); err != nil {
+				return err
+			}
+		}
+
+		return tx.UpdateResource(resource)
+	})
+}
+
+// Start runs the check once per interval in the background and prints each
+// report that found something.
+func (c *Checker) Start(interval time.Duration, repair bool) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			report, err := c.Run(repair)
+			if err != nil {
+				fmt.Println("fsck failed:", err)
+				continue
+			}
+
+			if report.Problems() > 0 {
+				json.NewEncoder(os.Stdout).Encode(report)
+			}
+		}
+	}()
+}
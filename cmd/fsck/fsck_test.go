@@ -0,0 +1,225 @@
+package fsck
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/c4me-caro/drive"
+	"github.com/c4me-caro/drive/database"
+	"github.com/c4me-caro/drive/storage"
+)
+
+type fixture struct {
+	t     *testing.T
+	root  string
+	db    *database.MemoryStore
+	store *storage.LocalBackend
+}
+
+func newFixture(t *testing.T) fixture {
+	root := t.TempDir()
+	store, err := storage.NewLocalBackend(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return fixture{t: t, root: root, db: database.NewMemoryStore(), store: store}
+}
+
+// put stores a blob, written age ago.
+func (f fixture) put(key string, age time.Duration) {
+	f.t.Helper()
+
+	if _, err := f.store.Put(key, strings.NewReader(key)); err != nil {
+		f.t.Fatal(err)
+	}
+
+	at := time.Now().Add(-age)
+	if err := os.Chtimes(filepath.Join(f.root, filepath.FromSlash(key)), at, at); err != nil {
+		f.t.Fatal(err)
+	}
+}
+
+func (f fixture) create(resources ...drive.Resource) {
+	f.t.Helper()
+
+	for _, resource := range resources {
+		if err := f.db.CreateResource(resource); err != nil {
+			f.t.Fatal(err)
+		}
+	}
+}
+
+func (f fixture) resource(id string) drive.Resource {
+	f.t.Helper()
+
+	resource, err := f.db.GetResource(id)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+
+	return resource
+}
+
+func (f fixture) run(repair bool) Report {
+	f.t.Helper()
+
+	report, err := NewChecker(f.db, f.store, f.root).Run(repair)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+
+	if len(report.Errors) > 0 {
+		f.t.Fatalf("errors = %v", report.Errors)
+	}
+
+	return report
+}
+
+func TestRun(t *testing.T) {
+	f := newFixture(t)
+	missing := storage.BlobKey("deadbeef")
+	f.put("a.txt", 2*time.Hour)
+	f.put("lost.txt", 2*time.Hour)
+	f.put("orphan.bin", 2*time.Hour)
+	// A blob of an upload still being committed.
+	f.put("fresh.bin", 0)
+
+	if _, err := f.db.AcquireBlob(drive.Blob{Hash: "deadbeef"}); err != nil {
+		t.Fatal(err)
+	}
+
+	f.create(
+		drive.Resource{Id: "docs", Name: "docs", OwnerId: "u1", Type: "folder", Content: []string{"a", "ghost"}, Ancestors: []string{}},
+		drive.Resource{Id: "a", Name: "a.txt", OwnerId: "u1", Type: "file", Location: "a.txt", Parent: "docs", Ancestors: []string{}},
+		drive.Resource{Id: "lost", Name: "lost.txt", OwnerId: "u1", Type: "file", Location: filepath.Join(f.root, "lost.txt"), Parent: "docs", Ancestors: []string{}},
+		drive.Resource{Id: "gone", Name: "gone.txt", OwnerId: "u1", Type: "file", Location: missing, Hash: "deadbeef", Ancestors: []string{}},
+	)
+
+	report := f.run(false)
+	if !slices.Equal(report.OrphanBlobs, []string{"orphan.bin"}) {
+		t.Errorf("orphan blobs = %v", report.OrphanBlobs)
+	}
+
+	if !slices.Equal(report.MissingBlobs, []MissingBlob{{Id: "gone", Name: "gone.txt", Location: missing}}) {
+		t.Errorf("missing blobs = %v", report.MissingBlobs)
+	}
+
+	if !slices.Equal(report.DanglingContent, []DanglingContent{{Id: "docs", Child: "ghost"}}) {
+		t.Errorf("dangling content = %v", report.DanglingContent)
+	}
+
+	if !slices.Equal(report.Unreachable, []Unreachable{{Id: "lost", Name: "lost.txt", Parent: "docs"}}) {
+		t.Errorf("unreachable = %v", report.Unreachable)
+	}
+
+	if !slices.Equal(report.StaleAncestors, []string{"a"}) {
+		t.Errorf("stale ancestors = %v", report.StaleAncestors)
+	}
+
+	if report.Problems() != 5 || report.Repaired {
+		t.Fatalf("report = %+v", report)
+	}
+
+	// A check without repair changes nothing.
+	if _, err := f.store.Stat("orphan.bin"); err != nil {
+		t.Fatal(err)
+	}
+
+	report = f.run(true)
+	if !report.Repaired {
+		t.Fatal("report not marked repaired")
+	}
+
+	if _, err := f.store.Stat("orphan.bin"); err == nil {
+		t.Error("orphan blob was kept")
+	}
+
+	if _, err := f.store.Stat("fresh.bin"); err != nil {
+		t.Error("recent blob was deleted")
+	}
+
+	if _, err := f.db.GetResource("gone"); err == nil {
+		t.Error("file without content was kept")
+	}
+
+	if _, err := f.db.GetBlob("deadbeef"); err == nil {
+		t.Error("reference of the missing blob was kept")
+	}
+
+	if docs := f.resource("docs"); !slices.Equal(docs.Content, []string{"a", "lost"}) {
+		t.Errorf("docs content = %v", docs.Content)
+	}
+
+	for _, id := range []string{"a", "lost"} {
+		if ancestors := f.resource(id).Ancestors; !slices.Equal(ancestors, []string{"docs"}) {
+			t.Errorf("%s ancestors = %v", id, ancestors)
+		}
+	}
+
+	if report := f.run(false); report.Problems() != 0 {
+		t.Fatalf("problems left after repair: %+v", report)
+	}
+}
+
+func TestRepairMissingVersion(t *testing.T) {
+	f := newFixture(t)
+	f.put("v2.txt", 2*time.Hour)
+	f.create(drive.Resource{
+		Id: "a", Name: "a.txt", OwnerId: "u1", Type: "file", Location: "v3.txt", Version: 3, Ancestors: []string{},
+		Versions: []drive.Version{
+			{Number: 1, Location: "v1.txt"},
+			{Number: 2, Location: "v2.txt", Size: 6},
+			{Number: 3, Location: "v3.txt"},
+		},
+	})
+
+	report := f.run(true)
+	if len(report.MissingBlobs) != 2 {
+		t.Fatalf("missing blobs = %v", report.MissingBlobs)
+	}
+
+	// The newest readable version becomes the current one.
+	a := f.resource("a")
+	if a.Version != 2 || a.Location != "v2.txt" || a.Size != 6 || len(a.Versions) != 1 {
+		t.Fatalf("resource = %+v", a)
+	}
+}
+
+func TestRelinkToTopLevel(t *testing.T) {
+	f := newFixture(t)
+	f.create(
+		drive.Resource{Id: "taken", Name: "notes", OwnerId: "u1", Type: "folder", Content: []string{}, Ancestors: []string{}},
+		drive.Resource{Id: "trashed", Name: "old", OwnerId: "u1", Type: "folder", Content: []string{"notes"}, Ancestors: []string{}, Trashed: true},
+		drive.Resource{Id: "notes", Name: "notes", OwnerId: "u1", Type: "folder", Parent: "gone", Content: []string{"n1"}, Ancestors: []string{"gone"}},
+		drive.Resource{Id: "n1", Name: "n1", OwnerId: "u1", Type: "folder", Parent: "notes", Content: []string{}, Ancestors: []string{"gone", "notes"}},
+	)
+
+	report := f.run(true)
+	if len(report.Unreachable) != 2 {
+		t.Fatalf("unreachable = %v", report.Unreachable)
+	}
+
+	// The parent is gone, so the subtree moves to the top level under a
+	// name that does not clash with the folder already there.
+	notes := f.resource("notes")
+	if notes.Parent != "" || notes.Name != "notes (notes)" || len(notes.Ancestors) != 0 {
+		t.Fatalf("notes = %+v", notes)
+	}
+
+	if trashed := f.resource("trashed"); len(trashed.Content) != 0 {
+		t.Fatalf("trashed folder still holds %v", trashed.Content)
+	}
+
+	if n1 := f.resource("n1"); !slices.Equal(n1.Ancestors, []string{"notes"}) {
+		t.Fatalf("n1 ancestors = %v", n1.Ancestors)
+	}
+
+	if report := f.run(false); report.Problems() != 0 {
+		t.Fatalf("problems left after repair: %+v", report)
+	}
+}
@@ -1,34 +1,103 @@
 package main
 
 import (
+	"encoding/json"
+	"flag"
 	"fmt"
 	"os"
 
 	"github.com/c4me-caro/drive/cmd/api"
+	"github.com/c4me-caro/drive/cmd/fsck"
 	"github.com/c4me-caro/drive/database"
+	"github.com/c4me-caro/drive/storage"
 	"github.com/joho/godotenv"
 )
 
 func main() {
 	godotenv.Load()
 	
-	client, err := database.ConnectDB(os.Getenv("MONGO_URI"))
+	worker, err := openDatabase(os.Getenv("METADATA_BACKEND"))
 	if err != nil {
 		fmt.Println(err)
 		return
 	}
 
-	worker := database.NewDriveWorker(client, os.Getenv("MONGO_DB"))
 	err = worker.Start()
 	if err != nil {
 		fmt.Println(err)
 		return
 	}
+
+	store, err := storage.NewBackend(os.Getenv("STORAGE_BACKEND"), os.Getenv("FILES_ROOT"))
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	keys, err := storage.LoadKeyring(os.Getenv("MASTER_KEY_FILE"), os.Getenv("MASTER_KEY"), os.Getenv("MASTER_KEY_ID"))
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "fsck":
+			runFsck(worker, store, os.Args[2:])
+			return
+		case "rotate-master-key":
+			runRotateMasterKey(worker, keys)
+			return
+		}
+	}
   
   fmt.Printf("Server running on: %s", os.Getenv("ADDRESS"))
-	server := api.NewApiServer(os.Getenv("ADDRESS"), worker)
+	server := api.NewApiServer(os.Getenv("ADDRESS"), worker, store, keys)
 	if err := server.Run(); err != nil {
 		fmt.Println(err)
 		return
 	}
 }
+
+func openDatabase(backend string) (database.Store, error) {
+	switch backend {
+	case "", "mongo":
+		client, err := database.ConnectDB(os.Getenv("MONGO_URI"))
+		if err != nil {
+			return nil, err
+		}
+
+		return database.NewDriveWorker(client, os.Getenv("MONGO_DB")), nil
+	case "sqlite", "postgres":
+		worker, err := database.NewSQLWorker(backend, os.Getenv("METADATA_DSN"))
+		if err != nil {
+			return nil, err
+		}
+
+		return worker, nil
+	}
+
+	return nil, fmt.Errorf("unknown metadata backend: %s", backend)
+}
+
+// runFsck prints a JSON report of the drive consistency and exits with
+// status 1 when problems were left unrepaired.
+func runFsck(worker database.Store, store storage.Backend, args []string) {
+	flags := flag.NewFlagSet("fsck", flag.ExitOnError)
+	repair := flags.Bool("repair", false, "fix the problems found")
+	flags.Parse(args)
+
+	report, err := fsck.NewChecker(worker, store, os.Getenv("FILES_ROOT")).Run(*repair)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(report)
+
+	if report.Problems() > 0 && !report.Repaired {
+		os.Exit(1)
+	}
+}
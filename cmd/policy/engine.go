@@ -0,0 +1,263 @@
+package policy
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/c4me-caro/drive"
+)
+
+// Subject is who a request is made by.
+type Subject struct {
+	Id     string   `json:"id"`
+	Role   string   `json:"role"`
+	Groups []string `json:"groups"`
+}
+
+// Request is one access check. Path is only called when a rule selects a
+// path prefix, since finding it walks up the folders.
+type Request struct {
+	Subject  Subject
+	Action   string
+	Resource drive.Resource
+	System   bool
+	Path     func() string
+	Time     time.Time
+}
+
+// Step tells why a rule matched the request or not.
+type Step struct {
+	Rule    string `json:"rule"`
+	Effect  string `json:"effect"`
+	Matched bool   `json:"matched"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// Decision is the outcome of a request and the rule that settled it. The
+// trace of every rule is only kept by Explain.
+type Decision struct {
+	Allowed bool   `json:"allowed"`
+	Rule    string `json:"rule,omitempty"`
+	Reason  string `json:"reason"`
+	Trace   []Step `json:"trace,omitempty"`
+}
+
+// Engine evaluates requests against its rules and the rules given with
+// each request. Any matching deny rule wins over allow rules; without a
+// matching rule the request is denied.
+type Engine struct {
+	rules []Rule
+}
+
+// New validates rules and builds an engine on them. Unnamed rules are
+// named after their position.
+func New(rules []Rule) (*Engine, error) {
+	engine := &Engine{rules: make([]Rule, 0, len(rules))}
+	for i, rule := range rules {
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule %d", i+1)
+		}
+
+		if err := rule.Validate(); err != nil {
+			return nil, err
+		}
+
+		engine.rules = append(engine.rules, rule)
+	}
+
+	return engine, nil
+}
+
+func (e *Engine) Evaluate(request Request, extra ...Rule) Decision {
+	return e.decide(request, extra, false)
+}
+
+// Explain evaluates request like Evaluate, keeping the reason every rule
+// matched or not.
+func (e *Engine) Explain(request Request, extra ...Rule) Decision {
+	return e.decide(request, extra, true)
+}
+
+func (e *Engine) decide(request Request, extra []Rule, explain bool) Decision {
+	if request.Time.IsZero() {
+		request.Time = time.Now()
+	}
+
+	rules := extra
+	if e != nil {
+		rules = append(slices.Clone(e.rules), extra...)
+	}
+
+	eval := &evaluation{Request: request}
+	decision := Decision{Reason: "no rule allows " + request.Action}
+	denied := false
+	for _, rule := range rules {
+		reason := eval.mismatch(rule)
+		if explain {
+			decision.Trace = append(decision.Trace, Step{
+				Rule:    rule.Name,
+				Effect:  rule.Effect,
+				Matched: reason == "",
+				Reason:  reason,
+			})
+		}
+
+		if reason != "" || denied {
+			continue
+		}
+
+		switch {
+		case rule.Effect == Deny:
+			denied = true
+			decision.Allowed = false
+			decision.Rule = rule.Name
+			decision.Reason = "denied by " + rule.Name
+		case !decision.Allowed:
+			decision.Allowed = true
+			decision.Rule = rule.Name
+			decision.Reason = "allowed by " + rule.Name
+		}
+
+		if denied && !explain {
+			break
+		}
+	}
+
+	return decision
+}
+
+type evaluation struct {
+	Request
+	path  string
+	found bool
+}
+
+func (eval *evaluation) resourcePath() string {
+	if !eval.found {
+		eval.found = true
+		if eval.Path != nil {
+			eval.path = eval.Path()
+		}
+	}
+
+	return eval.path
+}
+
+func overlaps(a []string, b []string) bool {
+	return slices.ContainsFunc(a, func(item string) bool {
+		return slices.Contains(b, item)
+	})
+}
+
+// mismatch returns why rule does not match the request, or nothing when
+// it does.
+func (eval *evaluation) mismatch(rule Rule) string {
+	if !rule.allows(eval.Action) {
+		return "action " + eval.Action + " not listed"
+	}
+
+	if reason := eval.subjectMismatch(rule.Subjects); reason != "" {
+		return reason
+	}
+
+	if reason := eval.resourceMismatch(rule.Resources); reason != "" {
+		return reason
+	}
+
+	return eval.timeMismatch(rule.Conditions.Time)
+}
+
+func (eval *evaluation) subjectMismatch(subjects Subjects) string {
+	subject := eval.Subject
+	resource := eval.Resource
+	switch {
+	case len(subjects.Users) > 0 && !slices.Contains(subjects.Users, subject.Id):
+		return "user not selected"
+	case len(subjects.Groups) > 0 && !overlaps(subjects.Groups, subject.Groups):
+		return "user in none of the groups"
+	case len(subjects.Roles) > 0 && !slices.Contains(subjects.Roles, subject.Role):
+		return "role " + subject.Role + " not selected"
+	case subjects.Owner && resource.OwnerId != subject.Id:
+		return "user does not own the resource"
+	case subjects.Shared && !slices.Contains(resource.SharedId, subject.Id) && !overlaps(resource.SharedId, subject.Groups):
+		return "resource not shared with the user"
+	}
+
+	return ""
+}
+
+func (eval *evaluation) resourceMismatch(resources Resources) string {
+	resource := eval.Resource
+	switch {
+	case resources.System != eval.System && eval.System:
+		return "rule does not apply to the drive"
+	case resources.System != eval.System:
+		return "rule only applies to the drive"
+	case len(resources.Ids) > 0 && !slices.Contains(resources.Ids, resource.Id):
+		return "resource not selected"
+	case len(resources.Under) > 0 && !slices.Contains(resources.Under, resource.Id) && !overlaps(resources.Under, resource.Ancestors):
+		return "resource not under the selected folders"
+	case len(resources.Names) > 0 && !slices.Contains(resources.Names, resource.Name):
+		return "name " + resource.Name + " not selected"
+	case len(resources.Types) > 0 && !slices.Contains(resources.Types, resource.Type):
+		return "type " + resource.Type + " not selected"
+	case len(resources.Tags) > 0 && !overlaps(resources.Tags, resource.Tags):
+		return "resource has none of the tags"
+	case len(resources.SharedWith) > 0 && !overlaps(resources.SharedWith, resource.SharedId):
+		return "resource not shared with the selected principals"
+	case resources.PathPrefix != "" && !underPath(eval.resourcePath(), resources.PathPrefix):
+		return "path " + eval.resourcePath() + " outside " + resources.PathPrefix
+	}
+
+	return ""
+}
+
+func underPath(path string, prefix string) bool {
+	prefix = strings.TrimSuffix(prefix, "/")
+	return prefix == "" || path == prefix || strings.HasPrefix(path, prefix+"/")
+}
+
+func (eval *evaluation) timeMismatch(window *TimeWindow) string {
+	if window == nil {
+		return ""
+	}
+
+	now := eval.Time
+	if !window.NotBefore.IsZero() && now.Before(window.NotBefore) {
+		return "rule not active yet"
+	}
+
+	if !window.NotAfter.IsZero() && now.After(window.NotAfter) {
+		return "rule no longer active"
+	}
+
+	// Zones were checked by Validate.
+	location, _ := time.LoadLocation(window.Zone)
+	now = now.In(location)
+
+	if len(window.Days) > 0 && !slices.ContainsFunc(window.Days, func(day string) bool {
+		return weekdays[strings.ToLower(day)] == now.Weekday()
+	}) {
+		return "outside the days of the rule"
+	}
+
+	if window.From == "" {
+		return ""
+	}
+
+	from, _ := parseClock(window.From)
+	to, _ := parseClock(window.To)
+	minute := now.Hour()*60 + now.Minute()
+	inside := from <= minute && minute < to
+	if to < from {
+		inside = minute >= from || minute < to
+	}
+
+	if !inside {
+		return "outside " + window.From + "-" + window.To
+	}
+
+	return ""
+}
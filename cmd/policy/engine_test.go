@@ -0,0 +1,281 @@
+package policy
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/c4me-caro/drive"
+)
+
+func newEngine(t *testing.T, rules ...Rule) *Engine {
+	t.Helper()
+
+	engine, err := New(rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return engine
+}
+
+func request(subject string, action string, resource drive.Resource) Request {
+	return Request{Subject: Subject{Id: subject}, Action: action, Resource: resource}
+}
+
+func TestEvaluateDefaultsToDeny(t *testing.T) {
+	decision := newEngine(t).Evaluate(request("u1", "read", drive.Resource{Id: "r"}))
+	if decision.Allowed || decision.Rule != "" {
+		t.Fatalf("unexpected decision: %+v", decision)
+	}
+
+	// A nil engine only knows the rules given with the request.
+	var engine *Engine
+	decision = engine.Evaluate(request("u1", "read", drive.Resource{Id: "r"}), Rule{Name: "extra", Effect: Allow, Actions: []string{"read"}})
+	if !decision.Allowed || decision.Rule != "extra" {
+		t.Fatalf("unexpected decision: %+v", decision)
+	}
+}
+
+func TestEvaluateDenyWins(t *testing.T) {
+	engine := newEngine(t,
+		Rule{Name: "owners", Effect: Allow, Actions: []string{AnyAction}, Subjects: Subjects{Owner: true}},
+		Rule{Name: "frozen", Effect: Deny, Actions: []string{"delete"}, Resources: Resources{Tags: []string{"frozen"}}},
+	)
+
+	resource := drive.Resource{Id: "r", OwnerId: "u1", Tags: []string{"frozen"}}
+	if decision := engine.Evaluate(request("u1", "read", resource)); !decision.Allowed || decision.Rule != "owners" {
+		t.Fatalf("read: %+v", decision)
+	}
+
+	if decision := engine.Evaluate(request("u1", "delete", resource)); decision.Allowed || decision.Rule != "frozen" {
+		t.Fatalf("delete: %+v", decision)
+	}
+
+	// Deny rules win over allow rules given with the request as well.
+	extra := Rule{Name: "grant", Effect: Allow, Actions: []string{"delete"}}
+	if decision := engine.Evaluate(request("u2", "delete", resource), extra); decision.Allowed {
+		t.Fatalf("delete by grant: %+v", decision)
+	}
+}
+
+func TestSubjects(t *testing.T) {
+	resource := drive.Resource{Id: "r", OwnerId: "u1", SharedId: []string{"u2", "g1"}}
+	cases := []struct {
+		name     string
+		subjects Subjects
+		subject  Subject
+		allowed  bool
+	}{
+		{"user", Subjects{Users: []string{"u2"}}, Subject{Id: "u2"}, true},
+		{"other user", Subjects{Users: []string{"u2"}}, Subject{Id: "u3"}, false},
+		{"group", Subjects{Groups: []string{"g1"}}, Subject{Id: "u3", Groups: []string{"g1"}}, true},
+		{"other group", Subjects{Groups: []string{"g1"}}, Subject{Id: "u3", Groups: []string{"g2"}}, false},
+		{"role", Subjects{Roles: []string{"admin"}}, Subject{Id: "u3", Role: "admin"}, true},
+		{"owner", Subjects{Owner: true}, Subject{Id: "u1"}, true},
+		{"not owner", Subjects{Owner: true}, Subject{Id: "u2"}, false},
+		{"shared", Subjects{Shared: true}, Subject{Id: "u2"}, true},
+		{"shared with group", Subjects{Shared: true}, Subject{Id: "u3", Groups: []string{"g1"}}, true},
+		{"not shared", Subjects{Shared: true}, Subject{Id: "u3"}, false},
+		{"all selectors", Subjects{Users: []string{"u2"}, Roles: []string{"admin"}}, Subject{Id: "u2"}, false},
+	}
+
+	for _, c := range cases {
+		engine := newEngine(t, Rule{Effect: Allow, Actions: []string{"read"}, Subjects: c.subjects})
+		decision := engine.Evaluate(Request{Subject: c.subject, Action: "read", Resource: resource})
+		if decision.Allowed != c.allowed {
+			t.Errorf("%s: allowed = %v, want %v", c.name, decision.Allowed, c.allowed)
+		}
+	}
+}
+
+func TestResources(t *testing.T) {
+	resource := drive.Resource{Id: "r", Name: "a.txt", Type: "file", Ancestors: []string{"top", "mid"}, Tags: []string{"public"}, SharedId: []string{"g1"}}
+	cases := []struct {
+		name      string
+		resources Resources
+		allowed   bool
+	}{
+		{"any", Resources{}, true},
+		{"id", Resources{Ids: []string{"r"}}, true},
+		{"other id", Resources{Ids: []string{"x"}}, false},
+		{"under itself", Resources{Under: []string{"r"}}, true},
+		{"under ancestor", Resources{Under: []string{"top"}}, true},
+		{"under other", Resources{Under: []string{"x"}}, false},
+		{"name", Resources{Names: []string{"a.txt"}}, true},
+		{"type", Resources{Types: []string{"folder"}}, false},
+		{"tag", Resources{Tags: []string{"public", "x"}}, true},
+		{"other tag", Resources{Tags: []string{"x"}}, false},
+		{"shared with", Resources{SharedWith: []string{"g1"}}, true},
+		{"path", Resources{PathPrefix: "/docs"}, true},
+		{"path with slash", Resources{PathPrefix: "/docs/"}, true},
+		{"similar path", Resources{PathPrefix: "/doc"}, false},
+		{"system", Resources{System: true}, false},
+	}
+
+	for _, c := range cases {
+		engine := newEngine(t, Rule{Effect: Allow, Actions: []string{"read"}, Resources: c.resources})
+		r := request("u1", "read", resource)
+		r.Path = func() string { return "/docs/a.txt" }
+		if decision := engine.Evaluate(r); decision.Allowed != c.allowed {
+			t.Errorf("%s: allowed = %v, want %v", c.name, decision.Allowed, c.allowed)
+		}
+	}
+
+	// Rules for the drive apply to it alone.
+	engine := newEngine(t, Rule{Effect: Allow, Actions: []string{"read"}, Resources: Resources{System: true}})
+	r := request("u1", "read", drive.Resource{Id: "0"})
+	r.System = true
+	if decision := engine.Evaluate(r); !decision.Allowed {
+		t.Fatalf("system: %+v", decision)
+	}
+
+	if decision := newEngine(t, Rule{Effect: Allow, Actions: []string{"read"}}).Evaluate(r); decision.Allowed {
+		t.Fatalf("ordinary rule on the drive: %+v", decision)
+	}
+}
+
+func TestPathIsLookedUpOnce(t *testing.T) {
+	engine := newEngine(t,
+		Rule{Effect: Allow, Actions: []string{"read"}, Resources: Resources{PathPrefix: "/a"}},
+		Rule{Effect: Allow, Actions: []string{"read"}, Resources: Resources{PathPrefix: "/b"}},
+		Rule{Effect: Allow, Actions: []string{"read"}, Resources: Resources{Ids: []string{"x"}}},
+	)
+
+	calls := 0
+	r := request("u1", "read", drive.Resource{Id: "r"})
+	r.Path = func() string {
+		calls++
+		return "/c"
+	}
+
+	engine.Evaluate(r)
+	if calls != 1 {
+		t.Fatalf("path looked up %d times, want 1", calls)
+	}
+
+	// Without path rules it is never looked up.
+	calls = 0
+	newEngine(t, Rule{Effect: Allow, Actions: []string{"read"}}).Evaluate(r)
+	if calls != 0 {
+		t.Fatalf("path looked up %d times, want 0", calls)
+	}
+}
+
+func TestTimeWindow(t *testing.T) {
+	// Monday 2024-01-01.
+	monday := func(clock string) time.Time {
+		at, err := time.Parse("2006-01-02 15:04", "2024-01-01 "+clock)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return at
+	}
+
+	cases := []struct {
+		name    string
+		window  TimeWindow
+		at      time.Time
+		allowed bool
+	}{
+		{"office hours", TimeWindow{Days: []string{"mon"}, From: "09:00", To: "17:00"}, monday("10:00"), true},
+		{"after hours", TimeWindow{Days: []string{"mon"}, From: "09:00", To: "17:00"}, monday("17:00"), false},
+		{"other day", TimeWindow{Days: []string{"Tue"}}, monday("10:00"), false},
+		{"overnight late", TimeWindow{From: "22:00", To: "06:00"}, monday("23:30"), true},
+		{"overnight early", TimeWindow{From: "22:00", To: "06:00"}, monday("05:59"), true},
+		{"overnight day", TimeWindow{From: "22:00", To: "06:00"}, monday("12:00"), false},
+		{"zone", TimeWindow{From: "09:00", To: "17:00", Zone: "America/New_York"}, monday("10:00"), false},
+		{"not yet", TimeWindow{NotBefore: monday("12:00")}, monday("10:00"), false},
+		{"no longer", TimeWindow{NotAfter: monday("09:00")}, monday("10:00"), false},
+		{"between", TimeWindow{NotBefore: monday("09:00"), NotAfter: monday("12:00")}, monday("10:00"), true},
+	}
+
+	for _, c := range cases {
+		window := c.window
+		engine := newEngine(t, Rule{Effect: Allow, Actions: []string{"read"}, Conditions: Conditions{Time: &window}})
+		r := request("u1", "read", drive.Resource{Id: "r"})
+		r.Time = c.at
+		if decision := engine.Evaluate(r); decision.Allowed != c.allowed {
+			t.Errorf("%s: allowed = %v, want %v", c.name, decision.Allowed, c.allowed)
+		}
+	}
+}
+
+func TestExplain(t *testing.T) {
+	engine := newEngine(t,
+		Rule{Effect: Allow, Actions: []string{"read"}},
+		Rule{Name: "no deletes", Effect: Deny, Actions: []string{"delete"}},
+	)
+
+	decision := engine.Explain(request("u1", "read", drive.Resource{Id: "r"}))
+	if !decision.Allowed || decision.Rule != "rule 1" || len(decision.Trace) != 2 {
+		t.Fatalf("unexpected decision: %+v", decision)
+	}
+
+	if step := decision.Trace[1]; step.Rule != "no deletes" || step.Matched || step.Reason != "action read not listed" {
+		t.Fatalf("unexpected step: %+v", step)
+	}
+
+	if decision := engine.Evaluate(request("u1", "read", drive.Resource{Id: "r"})); decision.Trace != nil {
+		t.Fatalf("Evaluate kept a trace: %+v", decision.Trace)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	invalid := []Rule{
+		{Effect: "maybe", Actions: []string{"read"}},
+		{Effect: Allow},
+		{Effect: Allow, Actions: []string{"read"}, Conditions: Conditions{Time: &TimeWindow{Days: []string{"someday"}}}},
+		{Effect: Allow, Actions: []string{"read"}, Conditions: Conditions{Time: &TimeWindow{From: "9am", To: "17:00"}}},
+		{Effect: Allow, Actions: []string{"read"}, Conditions: Conditions{Time: &TimeWindow{From: "09:00"}}},
+		{Effect: Allow, Actions: []string{"read"}, Conditions: Conditions{Time: &TimeWindow{Zone: "Nowhere/City"}}},
+	}
+
+	for _, rule := range invalid {
+		if _, err := New([]Rule{rule}); err == nil {
+			t.Errorf("rule %+v was accepted", rule)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"policy.yaml": "rules:\n  - name: readers\n    effect: allow\n    actions: [read]\n    subjects:\n      groups: [g1]\n",
+		"policy.json": `{"rules":[{"name":"readers","effect":"allow","actions":["read"],"subjects":{"groups":["g1"]}}]}`,
+	}
+
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		engine, err := Load(path)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		r := Request{Subject: Subject{Id: "u1", Groups: []string{"g1"}}, Action: "read", Resource: drive.Resource{Id: "r"}}
+		if decision := engine.Evaluate(r); !decision.Allowed || decision.Rule != "readers" {
+			t.Fatalf("%s: %+v", name, decision)
+		}
+	}
+
+	// A misspelt selector must not load as a rule matching everyone.
+	for name, content := range map[string]string{
+		"typo.yaml": "rules:\n  - effect: allow\n    actions: [read]\n    subject:\n      users: [u1]\n",
+		"typo.json": `{"rules":[{"effect":"allow","actions":["read"],"subject":{"users":["u1"]}}]}`,
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := Load(path); err == nil {
+			t.Errorf("%s was loaded", name)
+		}
+	}
+}
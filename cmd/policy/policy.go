@@ -0,0 +1,173 @@
+package policy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	Allow = "allow"
+	Deny  = "deny"
+)
+
+// AnyAction matches every action in Rule.Actions.
+const AnyAction = "*"
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// Policy is the content of a policy file.
+type Policy struct {
+	Rules []Rule `json:"rules" yaml:"rules"`
+}
+
+// Rule allows or denies its actions when the subject, the resource and the
+// conditions all match. Empty selectors match everything, and a list
+// matches when any of its entries does.
+type Rule struct {
+	Name       string     `json:"name" yaml:"name"`
+	Effect     string     `json:"effect" yaml:"effect"`
+	Subjects   Subjects   `json:"subjects" yaml:"subjects"`
+	Actions    []string   `json:"actions" yaml:"actions"`
+	Resources  Resources  `json:"resources" yaml:"resources"`
+	Conditions Conditions `json:"conditions" yaml:"conditions"`
+}
+
+// Subjects selects who a rule applies to. Owner and Shared relate the
+// subject to the resource of the request.
+type Subjects struct {
+	Users  []string `json:"users,omitempty" yaml:"users"`
+	Groups []string `json:"groups,omitempty" yaml:"groups"`
+	Roles  []string `json:"roles,omitempty" yaml:"roles"`
+	Owner  bool     `json:"owner,omitempty" yaml:"owner"`
+	Shared bool     `json:"shared,omitempty" yaml:"shared"`
+}
+
+// Resources selects what a rule applies to. Under matches a resource and
+// everything below it, PathPrefix matches on the path of names from the
+// top level, like /docs/reports. Rules only apply to the drive itself when
+// System is set.
+type Resources struct {
+	System     bool     `json:"system,omitempty" yaml:"system"`
+	Ids        []string `json:"ids,omitempty" yaml:"ids"`
+	Under      []string `json:"under,omitempty" yaml:"under"`
+	Names      []string `json:"names,omitempty" yaml:"names"`
+	Types      []string `json:"types,omitempty" yaml:"types"`
+	PathPrefix string   `json:"pathPrefix,omitempty" yaml:"pathPrefix"`
+	Tags       []string `json:"tags,omitempty" yaml:"tags"`
+	SharedWith []string `json:"sharedWith,omitempty" yaml:"sharedWith"`
+}
+
+// Conditions restrict when a rule applies.
+type Conditions struct {
+	Time *TimeWindow `json:"time,omitempty" yaml:"time"`
+}
+
+// TimeWindow holds between From and To, as HH:MM, on the given Days, in
+// the Zone location. A window whose To is before its From spans midnight.
+// NotBefore and NotAfter bound the rule in absolute time.
+type TimeWindow struct {
+	Days      []string  `json:"days,omitempty" yaml:"days"`
+	From      string    `json:"from,omitempty" yaml:"from"`
+	To        string    `json:"to,omitempty" yaml:"to"`
+	Zone      string    `json:"zone,omitempty" yaml:"zone"`
+	NotBefore time.Time `json:"notBefore,omitempty" yaml:"notBefore"`
+	NotAfter  time.Time `json:"notAfter,omitempty" yaml:"notAfter"`
+}
+
+// Load reads the rules of a JSON or YAML policy file, told apart by its
+// extension.
+func Load(path string) (*Engine, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// Unknown keys are rejected, since a misspelt selector would
+	// otherwise widen the rule.
+	var policy Policy
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(raw))
+		decoder.KnownFields(true)
+		err = decoder.Decode(&policy)
+	default:
+		decoder := json.NewDecoder(bytes.NewReader(raw))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(&policy)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid policy file %s: %w", path, err)
+	}
+
+	return New(policy.Rules)
+}
+
+// Validate checks a rule before it is used, so mistakes in a policy file
+// fail at startup instead of denying requests.
+func (rule Rule) Validate() error {
+	if rule.Effect != Allow && rule.Effect != Deny {
+		return fmt.Errorf("rule %q: effect must be allow or deny", rule.Name)
+	}
+
+	if len(rule.Actions) == 0 {
+		return fmt.Errorf("rule %q: no actions", rule.Name)
+	}
+
+	window := rule.Conditions.Time
+	if window == nil {
+		return nil
+	}
+
+	for _, day := range window.Days {
+		if _, ok := weekdays[strings.ToLower(day)]; !ok {
+			return fmt.Errorf("rule %q: unknown day %s", rule.Name, day)
+		}
+	}
+
+	for _, clock := range []string{window.From, window.To} {
+		if _, err := parseClock(clock); clock != "" && err != nil {
+			return fmt.Errorf("rule %q: invalid time %s, use HH:MM", rule.Name, clock)
+		}
+	}
+
+	if (window.From == "") != (window.To == "") {
+		return fmt.Errorf("rule %q: time window needs both from and to", rule.Name)
+	}
+
+	if _, err := time.LoadLocation(window.Zone); err != nil {
+		return fmt.Errorf("rule %q: unknown zone %s", rule.Name, window.Zone)
+	}
+
+	return nil
+}
+
+// parseClock returns the minutes after midnight of an HH:MM time.
+func parseClock(clock string) (int, error) {
+	parsed, err := time.Parse("15:04", clock)
+	if err != nil {
+		return 0, err
+	}
+
+	return parsed.Hour()*60 + parsed.Minute(), nil
+}
+
+func (rule Rule) allows(action string) bool {
+	return slices.Contains(rule.Actions, AnyAction) || slices.Contains(rule.Actions, action)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/c4me-caro/drive/database"
+	"github.com/c4me-caro/drive/storage"
+)
+
+type rotateReport struct {
+	KeyId     string `json:"keyId"`
+	Blobs     int    `json:"blobs"`
+	Resources int    `json:"resources"`
+}
+
+// runRotateMasterKey rewraps the data key of every encrypted blob with the
+// current master key and records the new key id on the files using it. The
+// content itself is not encrypted again.
+func runRotateMasterKey(worker database.Store, keys *storage.Keyring) {
+	if keys == nil {
+		fmt.Println("no master key configured")
+		os.Exit(1)
+	}
+
+	report, err := rotateMasterKey(worker, keys)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(report)
+}
+
+func rotateMasterKey(worker database.ResourceStore, keys *storage.Keyring) (rotateReport, error) {
+	report := rotateReport{KeyId: keys.Current()}
+
+	blobs, err := worker.ListBlobs()
+	if err != nil {
+		return report, err
+	}
+
+	keyIds := make(map[string]string, len(blobs))
+	for _, blob := range blobs {
+		if blob.KeyId == "" || blob.KeyId == keys.Current() {
+			keyIds[blob.Hash] = blob.KeyId
+			continue
+		}
+
+		dataKey, err := keys.Unwrap(blob.KeyId, blob.DataKey)
+		if err != nil {
+			return report, fmt.Errorf("blob %s: %w", blob.Hash, err)
+		}
+
+		blob.KeyId, blob.DataKey, err = keys.Wrap(dataKey)
+		if err != nil {
+			return report, err
+		}
+
+		if err := worker.SetBlobKey(blob); err != nil {
+			return report, err
+		}
+
+		keyIds[blob.Hash] = blob.KeyId
+		report.Blobs++
+	}
+
+	// Resources only mirror the key id, so they are brought in line with the
+	// blobs afterwards. Running the command again finishes an interrupted
+	// rotation.
+	resources, err := worker.ListResources()
+	if err != nil {
+		return report, err
+	}
+
+	for _, resource := range resources {
+		changed := false
+		for i, version := range resource.Versions {
+			if keyId, ok := keyIds[version.Hash]; ok && storage.BlobKey(version.Hash) == version.Location && version.KeyId != keyId {
+				resource.Versions[i].KeyId = keyId
+				changed = true
+			}
+		}
+
+		if keyId, ok := keyIds[resource.Hash]; ok && storage.BlobKey(resource.Hash) == resource.Location && resource.KeyId != keyId {
+			resource.KeyId = keyId
+			changed = true
+		}
+
+		if !changed {
+			continue
+		}
+
+		if err := worker.UpdateResource(resource); err != nil {
+			return report, err
+		}
+
+		report.Resources++
+	}
+
+	return report, nil
+}
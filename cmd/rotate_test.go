@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/c4me-caro/drive"
+	"github.com/c4me-caro/drive/database"
+	"github.com/c4me-caro/drive/storage"
+)
+
+func TestRotateMasterKey(t *testing.T) {
+	old, err := storage.NewKeyring("old", map[string][]byte{"old": bytes.Repeat([]byte{1}, 32)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dataKey, _ := storage.NewDataKey()
+	keyId, wrapped, err := old.Wrap(dataKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db := database.NewMemoryStore()
+	for _, blob := range []drive.Blob{{Hash: "aa11", KeyId: keyId, DataKey: wrapped}, {Hash: "bb22"}} {
+		if _, err := db.AcquireBlob(blob); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	encrypted := drive.Resource{
+		Id: "f1", Name: "a.txt", Type: "file", Hash: "aa11", Location: storage.BlobKey("aa11"), KeyId: "old",
+		Versions: []drive.Version{{Number: 1, Hash: "aa11", Location: storage.BlobKey("aa11"), KeyId: "old"}},
+	}
+
+	plain := drive.Resource{Id: "f2", Name: "b.txt", Type: "file", Hash: "bb22", Location: storage.BlobKey("bb22")}
+	for _, resource := range []drive.Resource{encrypted, plain} {
+		if err := db.CreateResource(resource); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	keys, err := storage.NewKeyring("new", map[string][]byte{"old": bytes.Repeat([]byte{1}, 32), "new": bytes.Repeat([]byte{2}, 32)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := rotateMasterKey(db, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report != (rotateReport{KeyId: "new", Blobs: 1, Resources: 1}) {
+		t.Fatalf("report = %+v", report)
+	}
+
+	blob, err := db.GetBlob("aa11")
+	if err != nil || blob.KeyId != "new" || blob.Refs != 1 {
+		t.Fatalf("blob = %+v, %v", blob, err)
+	}
+
+	if unwrapped, err := keys.Unwrap(blob.KeyId, blob.DataKey); err != nil || !bytes.Equal(unwrapped, dataKey) {
+		t.Fatalf("data key changed: %v", err)
+	}
+
+	resource, err := db.GetResource("f1")
+	if err != nil || resource.KeyId != "new" || resource.Versions[0].KeyId != "new" {
+		t.Fatalf("resource = %+v, %v", resource, err)
+	}
+
+	if blob, _ := db.GetBlob("bb22"); blob.KeyId != "" {
+		t.Fatalf("an unencrypted blob got key %s", blob.KeyId)
+	}
+
+	// Running it again finds nothing left to do.
+	if report, err := rotateMasterKey(db, keys); err != nil || report.Blobs != 0 || report.Resources != 0 {
+		t.Fatalf("second run = %+v, %v", report, err)
+	}
+}
+
+func TestRotateMasterKeyWithoutOldKey(t *testing.T) {
+	old, _ := storage.NewKeyring("old", map[string][]byte{"old": bytes.Repeat([]byte{1}, 32)})
+	dataKey, _ := storage.NewDataKey()
+	keyId, wrapped, _ := old.Wrap(dataKey)
+
+	db := database.NewMemoryStore()
+	if _, err := db.AcquireBlob(drive.Blob{Hash: "aa11", KeyId: keyId, DataKey: wrapped}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The old key was removed from the file too early.
+	keys, _ := storage.NewKeyring("new", map[string][]byte{"new": bytes.Repeat([]byte{2}, 32)})
+	if _, err := rotateMasterKey(db, keys); err == nil {
+		t.Fatal("a blob was rotated without its master key")
+	}
+
+	if blob, _ := db.GetBlob("aa11"); blob.KeyId != "old" {
+		t.Fatalf("blob key = %s, want old", blob.KeyId)
+	}
+}
@@ -0,0 +1,81 @@
+package database
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/c4me-caro/drive"
+)
+
+// stores returns every store that runs without a server.
+func stores(t *testing.T) map[string]Store {
+	return map[string]Store{
+		"memory": NewMemoryStore(),
+		"sqlite": newSQLiteWorker(t, len(migrations)),
+	}
+}
+
+func TestBlobReferences(t *testing.T) {
+	for name, store := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			first := drive.Blob{Hash: "h", KeyId: "k1", DataKey: []byte("one")}
+			if blob, err := store.AcquireBlob(first); err != nil || blob.Refs != 1 {
+				t.Fatalf("first acquire = %+v, %v, want 1 reference", blob, err)
+			}
+
+			// Later references share the key of the first.
+			blob, err := store.AcquireBlob(drive.Blob{Hash: "h", KeyId: "k2", DataKey: []byte("two")})
+			if err != nil || blob.Refs != 2 || blob.KeyId != "k1" || !bytes.Equal(blob.DataKey, first.DataKey) {
+				t.Fatalf("second acquire = %+v, %v, want 2 references under k1", blob, err)
+			}
+
+			if refs, err := store.ReleaseBlob("h"); err != nil || refs != 1 {
+				t.Fatalf("release = %d, %v, want 1", refs, err)
+			}
+
+			if refs, err := store.ReleaseBlob("h"); err != nil || refs != 0 {
+				t.Fatalf("release = %d, %v, want 0", refs, err)
+			}
+
+			if _, err := store.GetBlob("h"); err == nil {
+				t.Fatal("the counter outlived its last reference")
+			}
+
+			if refs, err := store.ReleaseBlob("h"); err != nil || refs != 0 {
+				t.Fatalf("release of a missing blob = %d, %v, want 0", refs, err)
+			}
+
+			// A released blob starts over with the key it is acquired with.
+			if blob, err := store.AcquireBlob(drive.Blob{Hash: "h", KeyId: "k2"}); err != nil || blob.Refs != 1 || blob.KeyId != "k2" {
+				t.Fatalf("acquire after release = %+v, %v", blob, err)
+			}
+		})
+	}
+}
+
+func TestSetBlobKey(t *testing.T) {
+	for name, store := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			if _, err := store.AcquireBlob(drive.Blob{Hash: "h", KeyId: "k1", DataKey: []byte("one")}); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := store.AcquireBlob(drive.Blob{Hash: "h"}); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := store.SetBlobKey(drive.Blob{Hash: "h", KeyId: "k2", DataKey: []byte("two")}); err != nil {
+				t.Fatal(err)
+			}
+
+			blobs, err := store.ListBlobs()
+			if err != nil || len(blobs) != 1 {
+				t.Fatalf("blobs = %+v, %v", blobs, err)
+			}
+
+			if blob := blobs[0]; blob.Refs != 2 || blob.KeyId != "k2" || string(blob.DataKey) != "two" {
+				t.Fatalf("blob = %+v, want 2 references under k2", blob)
+			}
+		})
+	}
+}
@@ -2,7 +2,9 @@ package database
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"time"
 
 	"github.com/c4me-caro/drive"
 	"go.mongodb.org/mongo-driver/bson"
@@ -11,8 +13,10 @@ import (
 )
 
 type DriveWorker struct {
-	client *mongo.Client
-	db     string
+	client       *mongo.Client
+	db           string
+	session      context.Context
+	transactions bool
 }
 
 func NewDriveWorker(c *mongo.Client, db string) *DriveWorker {
@@ -22,6 +26,15 @@ func NewDriveWorker(c *mongo.Client, db string) *DriveWorker {
 	}
 }
 
+// ctx returns the transaction session when the worker runs inside one.
+func (cfw *DriveWorker) ctx() context.Context {
+	if cfw.session != nil {
+		return cfw.session
+	}
+
+	return context.TODO()
+}
+
 func (cfw *DriveWorker) AddResourceChildren(resource drive.Resource, children string) error {
 	if resource.Id == "0" {
 		return fmt.Errorf("system update forbiden")
@@ -30,10 +43,33 @@ func (cfw *DriveWorker) AddResourceChildren(resource drive.Resource, children st
 	coll := cfw.client.Database(cfw.db).Collection("resources")
 	filter := bson.M{"id": resource.Id, "name": resource.Name}
 	update := bson.M{
-		"$push": bson.M{"resource.content": children},
+		"$push": bson.M{"content": children},
+	}
+
+	result, err := coll.UpdateOne(cfw.ctx(), filter, update)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("%w: %s", ErrResourceNotFound, resource.Id)
+	}
+
+	return nil
+}
+
+func (cfw *DriveWorker) RemoveResourceChildren(resource drive.Resource, children string) error {
+	if resource.Id == "0" {
+		return fmt.Errorf("system update forbiden")
+	}
+
+	coll := cfw.client.Database(cfw.db).Collection("resources")
+	filter := bson.M{"id": resource.Id}
+	update := bson.M{
+		"$pull": bson.M{"content": children},
 	}
 
-	_, err := coll.UpdateOne(context.TODO(), filter, update)
+	_, err := coll.UpdateOne(cfw.ctx(), filter, update)
 	if err != nil {
 		return err
 	}
@@ -41,6 +77,42 @@ func (cfw *DriveWorker) AddResourceChildren(resource drive.Resource, children st
 	return nil
 }
 
+func (cfw *DriveWorker) GetParent(children string) (drive.Resource, error) {
+	coll := cfw.client.Database(cfw.db).Collection("resources")
+
+	var resource drive.Resource
+	err := coll.FindOne(cfw.ctx(), bson.M{"content": children}).Decode(&resource)
+	if err == mongo.ErrNoDocuments {
+		return drive.Resource{}, fmt.Errorf("parent not found: %s", children)
+	}
+
+	if err != nil {
+		return drive.Resource{}, err
+	}
+
+	return resource, nil
+}
+
+func (cfw *DriveWorker) GetChild(parent string, owner string, name string) (drive.Resource, error) {
+	coll := cfw.client.Database(cfw.db).Collection("resources")
+	filter := bson.M{"parent": parent, "name": name, "trashed": bson.M{"$ne": true}}
+	if parent == "" {
+		filter["ownerId"] = owner
+	}
+
+	var resource drive.Resource
+	err := coll.FindOne(cfw.ctx(), filter).Decode(&resource)
+	if err == mongo.ErrNoDocuments {
+		return drive.Resource{}, fmt.Errorf("resource not found: %s", name)
+	}
+
+	if err != nil {
+		return drive.Resource{}, err
+	}
+
+	return resource, nil
+}
+
 func (cfw *DriveWorker) CheckResource(resource drive.Resource) error {
 	if resource.Id == "0" {
 		return fmt.Errorf("system check forbiden")
@@ -49,44 +121,74 @@ func (cfw *DriveWorker) CheckResource(resource drive.Resource) error {
 	coll := cfw.client.Database(cfw.db).Collection("resources")
 	filter := bson.M{"id": resource.Id, "name": resource.Name}
 
-	_, err := coll.Find(context.TODO(), filter)
+	count, err := coll.CountDocuments(cfw.ctx(), filter, options.Count().SetLimit(1))
 	if err != nil {
 		return err
 	}
 
+	if count == 0 {
+		return fmt.Errorf("resource not found: %s", resource.Name)
+	}
+
 	return nil
 }
 
 func (cfw *DriveWorker) GetResource(search string) (drive.Resource, error) {
 	coll := cfw.client.Database(cfw.db).Collection("resources")
-	cursor, err := coll.Find(context.TODO(), bson.D{})
+
+	var resource drive.Resource
+	err := coll.FindOne(cfw.ctx(), bson.M{"id": search}).Decode(&resource)
+	if err == mongo.ErrNoDocuments {
+		filter := bson.M{"name": search, "trashed": bson.M{"$ne": true}}
+		err = coll.FindOne(cfw.ctx(), filter).Decode(&resource)
+	}
+
+	if err == mongo.ErrNoDocuments {
+		return drive.Resource{}, fmt.Errorf("resource not found: %s", search)
+	}
+
 	if err != nil {
 		return drive.Resource{}, err
 	}
 
-	defer cursor.Close(context.TODO())
+	return resource, nil
+}
 
-	for cursor.Next(context.TODO()) {
-		var resource drive.Resource
-		if err := cursor.Decode(&resource); err != nil {
-			return drive.Resource{}, err
-		}
+func (cfw *DriveWorker) CreateResource(resource drive.Resource) error {
+	coll := cfw.client.Database(cfw.db).Collection("resources")
+	_, err := coll.InsertOne(cfw.ctx(), resource)
+	if mongo.IsDuplicateKeyError(err) {
+		return fmt.Errorf("%w: %s", ErrNameTaken, resource.Name)
+	}
 
-		if resource.Name == search || resource.Id == search {
-			return resource, nil
-		}
+	if err != nil {
+		return err
 	}
 
-	return drive.Resource{}, fmt.Errorf("resource not found: %s", search)
+	return nil
 }
 
-func (cfw *DriveWorker) CreateResource(resource drive.Resource) error {
+func (cfw *DriveWorker) UpdateResource(resource drive.Resource) error {
+	if resource.Id == "0" {
+		return fmt.Errorf("system update forbiden")
+	}
+
 	coll := cfw.client.Database(cfw.db).Collection("resources")
-	_, err := coll.InsertOne(context.TODO(), resource)
+	filter := bson.M{"id": resource.Id}
+
+	result, err := coll.ReplaceOne(cfw.ctx(), filter, resource)
+	if mongo.IsDuplicateKeyError(err) {
+		return fmt.Errorf("%w: %s", ErrNameTaken, resource.Name)
+	}
+
 	if err != nil {
 		return err
 	}
 
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("resource not found: %s", resource.Id)
+	}
+
 	return nil
 }
 
@@ -98,66 +200,662 @@ func (cfw *DriveWorker) DeleteResource(resource drive.Resource) error {
 	coll := cfw.client.Database(cfw.db).Collection("resources")
 	filter := bson.M{"id": resource.Id, "name": resource.Name}
 
-	_, err := coll.DeleteOne(context.TODO(), filter)
+	result, err := coll.DeleteOne(cfw.ctx(), filter)
 	if err != nil {
 		return err
 	}
 
+	if result.DeletedCount == 0 {
+		return fmt.Errorf("%w: %s", ErrResourceNotFound, resource.Id)
+	}
+
 	return nil
 }
 
-func (cfw *DriveWorker) GetUserById(userid string) (drive.User, error) {
-	coll := cfw.client.Database(cfw.db).Collection("users")
-	cursor, err := coll.Find(context.TODO(), bson.D{})
+func (cfw *DriveWorker) findResources(filter bson.M) ([]drive.Resource, error) {
+	coll := cfw.client.Database(cfw.db).Collection("resources")
+	cursor, err := coll.Find(cfw.ctx(), filter)
 	if err != nil {
-		return drive.User{}, err
+		return nil, err
 	}
 
-	defer cursor.Close(context.TODO())
+	resources := []drive.Resource{}
+	if err := cursor.All(cfw.ctx(), &resources); err != nil {
+		return nil, err
+	}
+
+	return resources, nil
+}
+
+func (cfw *DriveWorker) ListTrash(userid string) ([]drive.Resource, error) {
+	return cfw.findResources(bson.M{
+		"trashed":   true,
+		"trashedBy": userid,
+		"$expr":     bson.M{"$eq": bson.A{"$trashRoot", "$id"}},
+	})
+}
 
-	for cursor.Next(context.TODO()) {
-		var user drive.User
-		if err := cursor.Decode(&user); err != nil {
-			return drive.User{}, err
+func (cfw *DriveWorker) ListTrashTree(root string) ([]drive.Resource, error) {
+	return cfw.findResources(bson.M{"trashed": true, "trashRoot": root})
+}
+
+func (cfw *DriveWorker) ListExpiredTrash(before time.Time) ([]drive.Resource, error) {
+	return cfw.findResources(bson.M{
+		"trashed":   true,
+		"trashedAt": bson.M{"$lt": before},
+		"$expr":     bson.M{"$eq": bson.A{"$trashRoot", "$id"}},
+	})
+}
+
+func (cfw *DriveWorker) ListResources() ([]drive.Resource, error) {
+	return cfw.findResources(bson.M{})
+}
+
+func (cfw *DriveWorker) CreateUpload(upload drive.Upload) error {
+	coll := cfw.client.Database(cfw.db).Collection("uploads")
+	_, err := coll.InsertOne(cfw.ctx(), upload)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (cfw *DriveWorker) GetUpload(id string) (drive.Upload, error) {
+	coll := cfw.client.Database(cfw.db).Collection("uploads")
+
+	var upload drive.Upload
+	err := coll.FindOne(cfw.ctx(), bson.M{"id": id}).Decode(&upload)
+	if err == mongo.ErrNoDocuments {
+		return drive.Upload{}, fmt.Errorf("upload not found: %s", id)
+	}
+
+	if err != nil {
+		return drive.Upload{}, err
+	}
+
+	return upload, nil
+}
+
+func (cfw *DriveWorker) UpdateUploadOffset(id string, offset int64) error {
+	coll := cfw.client.Database(cfw.db).Collection("uploads")
+	filter := bson.M{"id": id}
+	update := bson.M{
+		"$set": bson.M{"offset": offset},
+	}
+
+	_, err := coll.UpdateOne(cfw.ctx(), filter, update)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (cfw *DriveWorker) DeleteUpload(id string) error {
+	coll := cfw.client.Database(cfw.db).Collection("uploads")
+	_, err := coll.DeleteOne(cfw.ctx(), bson.M{"id": id})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (cfw *DriveWorker) ListUploads() ([]drive.Upload, error) {
+	coll := cfw.client.Database(cfw.db).Collection("uploads")
+	cursor, err := coll.Find(cfw.ctx(), bson.M{})
+	if err != nil {
+		return nil, err
+	}
+
+	uploads := []drive.Upload{}
+	if err := cursor.All(cfw.ctx(), &uploads); err != nil {
+		return nil, err
+	}
+
+	return uploads, nil
+}
+
+// AcquireBlob counts one more resource pointing to the blob of hash and
+// returns the stored blob. The data key of blob is only kept when the blob
+// is new.
+func (cfw *DriveWorker) AcquireBlob(blob drive.Blob) (drive.Blob, error) {
+	coll := cfw.client.Database(cfw.db).Collection("blobs")
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	update := bson.M{
+		"$inc":         bson.M{"refs": 1},
+		"$setOnInsert": bson.M{"keyId": blob.KeyId, "dataKey": blob.DataKey},
+	}
+
+	var stored drive.Blob
+	err := coll.FindOneAndUpdate(cfw.ctx(), bson.M{"hash": blob.Hash}, update, opts).Decode(&stored)
+	if err != nil {
+		return drive.Blob{}, err
+	}
+
+	return stored, nil
+}
+
+// ReleaseBlob drops one reference to the blob of hash and returns how many
+// are left. The counter is removed with the last reference, unless one was
+// acquired again in the meantime.
+func (cfw *DriveWorker) ReleaseBlob(hash string) (int, error) {
+	coll := cfw.client.Database(cfw.db).Collection("blobs")
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var blob drive.Blob
+	err := coll.FindOneAndUpdate(cfw.ctx(), bson.M{"hash": hash}, bson.M{"$inc": bson.M{"refs": -1}}, opts).Decode(&blob)
+	if err == mongo.ErrNoDocuments {
+		return 0, nil
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	if blob.Refs > 0 {
+		return blob.Refs, nil
+	}
+
+	// An upload of the same content may have taken a reference since, so
+	// only a counter still at zero is removed.
+	deleted, err := coll.DeleteOne(cfw.ctx(), bson.M{"hash": hash, "refs": bson.M{"$lte": 0}})
+	if err != nil || deleted.DeletedCount > 0 {
+		return 0, err
+	}
+
+	err = coll.FindOne(cfw.ctx(), bson.M{"hash": hash}).Decode(&blob)
+	if err == mongo.ErrNoDocuments {
+		return 0, nil
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	return blob.Refs, nil
+}
+
+func (cfw *DriveWorker) GetBlob(hash string) (drive.Blob, error) {
+	coll := cfw.client.Database(cfw.db).Collection("blobs")
+
+	var blob drive.Blob
+	err := coll.FindOne(cfw.ctx(), bson.M{"hash": hash}).Decode(&blob)
+	if err == mongo.ErrNoDocuments {
+		return drive.Blob{}, fmt.Errorf("blob not found: %s", hash)
+	}
+
+	if err != nil {
+		return drive.Blob{}, err
+	}
+
+	return blob, nil
+}
+
+// SetBlobKey replaces the wrapped data key of a blob, keeping its
+// references.
+func (cfw *DriveWorker) SetBlobKey(blob drive.Blob) error {
+	coll := cfw.client.Database(cfw.db).Collection("blobs")
+	update := bson.M{
+		"$set": bson.M{"keyId": blob.KeyId, "dataKey": blob.DataKey},
+	}
+
+	_, err := coll.UpdateOne(cfw.ctx(), bson.M{"hash": blob.Hash}, update)
+	return err
+}
+
+func (cfw *DriveWorker) ListBlobs() ([]drive.Blob, error) {
+	coll := cfw.client.Database(cfw.db).Collection("blobs")
+	cursor, err := coll.Find(cfw.ctx(), bson.M{})
+	if err != nil {
+		return nil, err
+	}
+
+	blobs := []drive.Blob{}
+	if err := cursor.All(cfw.ctx(), &blobs); err != nil {
+		return nil, err
+	}
+
+	return blobs, nil
+}
+
+func (cfw *DriveWorker) CreateShareLink(link drive.ShareLink) error {
+	coll := cfw.client.Database(cfw.db).Collection("links")
+	_, err := coll.InsertOne(cfw.ctx(), link)
+	return err
+}
+
+func (cfw *DriveWorker) GetShareLink(tokenHash string) (drive.ShareLink, error) {
+	coll := cfw.client.Database(cfw.db).Collection("links")
+
+	var link drive.ShareLink
+	err := coll.FindOne(cfw.ctx(), bson.M{"tokenHash": tokenHash}).Decode(&link)
+	if err == mongo.ErrNoDocuments {
+		return drive.ShareLink{}, fmt.Errorf("share link not found")
+	}
+
+	if err != nil {
+		return drive.ShareLink{}, err
+	}
+
+	return link, nil
+}
+
+func (cfw *DriveWorker) ListShareLinks(ownerId string) ([]drive.ShareLink, error) {
+	coll := cfw.client.Database(cfw.db).Collection("links")
+	cursor, err := coll.Find(cfw.ctx(), bson.M{"ownerId": ownerId})
+	if err != nil {
+		return nil, err
+	}
+
+	links := []drive.ShareLink{}
+	if err := cursor.All(cfw.ctx(), &links); err != nil {
+		return nil, err
+	}
+
+	return links, nil
+}
+
+func (cfw *DriveWorker) DeleteShareLink(id string) error {
+	coll := cfw.client.Database(cfw.db).Collection("links")
+	_, err := coll.DeleteOne(cfw.ctx(), bson.M{"id": id})
+	return err
+}
+
+// UseShareLink counts a download in the same update that checks the limit,
+// so concurrent downloads cannot go past it.
+func (cfw *DriveWorker) UseShareLink(id string) error {
+	coll := cfw.client.Database(cfw.db).Collection("links")
+	filter := bson.M{
+		"id": id,
+		"$or": bson.A{
+			bson.M{"maxDownloads": 0},
+			bson.M{"$expr": bson.M{"$lt": bson.A{"$downloads", "$maxDownloads"}}},
+		},
+	}
+
+	result, err := coll.UpdateOne(cfw.ctx(), filter, bson.M{"$inc": bson.M{"downloads": 1}})
+	if err != nil {
+		return err
+	}
+
+	if result.ModifiedCount == 0 {
+		return fmt.Errorf("share link download limit reached: %s", id)
+	}
+
+	return nil
+}
+
+func (cfw *DriveWorker) SaveGrant(grant drive.Grant) error {
+	coll := cfw.client.Database(cfw.db).Collection("grants")
+	filter := bson.M{"resourceId": grant.ResourceId, "userId": grant.UserId, "groupId": grant.GroupId}
+	_, err := coll.ReplaceOne(cfw.ctx(), filter, grant, options.Replace().SetUpsert(true))
+	return err
+}
+
+func (cfw *DriveWorker) ListGrants(resourceIds ...string) ([]drive.Grant, error) {
+	coll := cfw.client.Database(cfw.db).Collection("grants")
+	cursor, err := coll.Find(cfw.ctx(), bson.M{"resourceId": bson.M{"$in": resourceIds}})
+	if err != nil {
+		return nil, err
+	}
+
+	grants := []drive.Grant{}
+	if err := cursor.All(cfw.ctx(), &grants); err != nil {
+		return nil, err
+	}
+
+	return grants, nil
+}
+
+func (cfw *DriveWorker) DeleteGrant(resourceId string, userId string, groupId string) error {
+	coll := cfw.client.Database(cfw.db).Collection("grants")
+	_, err := coll.DeleteOne(cfw.ctx(), bson.M{"resourceId": resourceId, "userId": userId, "groupId": groupId})
+	return err
+}
+
+func (cfw *DriveWorker) CreateGroup(group drive.Group) error {
+	coll := cfw.client.Database(cfw.db).Collection("groups")
+	_, err := coll.InsertOne(cfw.ctx(), group)
+	return err
+}
+
+func (cfw *DriveWorker) GetGroup(id string) (drive.Group, error) {
+	coll := cfw.client.Database(cfw.db).Collection("groups")
+
+	var group drive.Group
+	err := coll.FindOne(cfw.ctx(), bson.M{"id": id}).Decode(&group)
+	if err == mongo.ErrNoDocuments {
+		return drive.Group{}, fmt.Errorf("group not found: %s", id)
+	}
+
+	if err != nil {
+		return drive.Group{}, err
+	}
+
+	return group, nil
+}
+
+func (cfw *DriveWorker) ListGroups(userId string) ([]drive.Group, error) {
+	coll := cfw.client.Database(cfw.db).Collection("groups")
+	cursor, err := coll.Find(cfw.ctx(), bson.M{"members": userId})
+	if err != nil {
+		return nil, err
+	}
+
+	groups := []drive.Group{}
+	if err := cursor.All(cfw.ctx(), &groups); err != nil {
+		return nil, err
+	}
+
+	return groups, nil
+}
+
+func (cfw *DriveWorker) updateGroup(groupId string, update bson.M) error {
+	coll := cfw.client.Database(cfw.db).Collection("groups")
+	result, err := coll.UpdateOne(cfw.ctx(), bson.M{"id": groupId}, update)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("group not found: %s", groupId)
+	}
+
+	return nil
+}
+
+func (cfw *DriveWorker) AddGroupMember(groupId string, userId string) error {
+	return cfw.updateGroup(groupId, bson.M{"$addToSet": bson.M{"members": userId}})
+}
+
+func (cfw *DriveWorker) RemoveGroupMember(groupId string, userId string) error {
+	return cfw.updateGroup(groupId, bson.M{"$pull": bson.M{"members": userId}})
+}
+
+func (cfw *DriveWorker) DeleteGroup(id string) error {
+	coll := cfw.client.Database(cfw.db).Collection("groups")
+	_, err := coll.DeleteOne(cfw.ctx(), bson.M{"id": id})
+	return err
+}
+
+func (cfw *DriveWorker) SaveRevocation(revocation drive.Revocation) error {
+	coll := cfw.client.Database(cfw.db).Collection("revocations")
+	filter := bson.M{"jti": revocation.Jti, "userId": revocation.UserId}
+	_, err := coll.ReplaceOne(cfw.ctx(), filter, revocation, options.Replace().SetUpsert(true))
+	return err
+}
+
+// AddRevocation relies on the unique index on jti and userId to refuse a
+// second insert.
+func (cfw *DriveWorker) AddRevocation(revocation drive.Revocation) (bool, error) {
+	coll := cfw.client.Database(cfw.db).Collection("revocations")
+	_, err := coll.InsertOne(cfw.ctx(), revocation)
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+func (cfw *DriveWorker) ListRevocations(now time.Time) ([]drive.Revocation, error) {
+	coll := cfw.client.Database(cfw.db).Collection("revocations")
+	cursor, err := coll.Find(cfw.ctx(), bson.M{"expiresAt": bson.M{"$gt": now}})
+	if err != nil {
+		return nil, err
+	}
+
+	revocations := []drive.Revocation{}
+	if err := cursor.All(cfw.ctx(), &revocations); err != nil {
+		return nil, err
+	}
+
+	return revocations, nil
+}
+
+// PruneRevocations deletes expired revocations right away. The TTL index
+// on expiresAt does the same in the background.
+func (cfw *DriveWorker) PruneRevocations(now time.Time) error {
+	coll := cfw.client.Database(cfw.db).Collection("revocations")
+	_, err := coll.DeleteMany(cfw.ctx(), bson.M{"expiresAt": bson.M{"$lte": now}})
+	return err
+}
+
+// ErrNoChangeStreams is returned by WatchPermissions on a standalone
+// server, which has no change streams.
+var ErrNoChangeStreams = errors.New("change streams need a replica set or sharded cluster")
+
+func (cfw *DriveWorker) WatchPermissions(ctx context.Context, notify func(PermissionChange)) error {
+	if !cfw.transactions {
+		return ErrNoChangeStreams
+	}
+
+	pipeline := mongo.Pipeline{{{Key: "$match", Value: bson.M{"ns.coll": bson.M{"$in": []string{"groups", "grants"}}}}}}
+	stream, err := cfw.client.Database(cfw.db).Watch(ctx, pipeline, options.ChangeStream().SetFullDocument(options.UpdateLookup))
+	if err != nil {
+		return err
+	}
+
+	defer stream.Close(context.Background())
+
+	for stream.Next(ctx) {
+		var event changeEvent
+		if err := stream.Decode(&event); err != nil {
+			notify(PermissionChange{All: true})
+			continue
 		}
 
-		if user.Id == userid {
-			return user, nil
+		notify(event.permissionChange())
+	}
+
+	return stream.Err()
+}
+
+type changeEvent struct {
+	OperationType string `bson:"operationType"`
+	Namespace     struct {
+		Coll string `bson:"coll"`
+	} `bson:"ns"`
+	FullDocument bson.Raw `bson:"fullDocument"`
+}
+
+// permissionChange reads what a change event touched. Deletions carry no
+// document, and members removed from a group are no longer in it, so
+// those invalidate everything.
+func (event changeEvent) permissionChange() PermissionChange {
+	if len(event.FullDocument) == 0 {
+		return PermissionChange{All: true}
+	}
+
+	switch {
+	case event.Namespace.Coll == "grants":
+		var grant drive.Grant
+		if err := bson.Unmarshal(event.FullDocument, &grant); err == nil {
+			return PermissionChange{Resources: []string{grant.ResourceId}}
+		}
+	case event.Namespace.Coll == "groups" && event.OperationType == "insert":
+		var group drive.Group
+		if err := bson.Unmarshal(event.FullDocument, &group); err == nil {
+			return PermissionChange{Users: group.Members}
 		}
 	}
 
-	return drive.User{}, fmt.Errorf("userid not found: %s", userid)
+	return PermissionChange{All: true}
 }
 
-func (cfw *DriveWorker) GetUser(username string, password string) (drive.User, error) {
+func (cfw *DriveWorker) GetUserById(userid string) (drive.User, error) {
 	coll := cfw.client.Database(cfw.db).Collection("users")
-	cursor, err := coll.Find(context.TODO(), bson.D{})
+
+	var user drive.User
+	err := coll.FindOne(cfw.ctx(), bson.M{"id": userid}).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return drive.User{}, fmt.Errorf("userid not found: %s", userid)
+	}
+
 	if err != nil {
 		return drive.User{}, err
 	}
 
-	defer cursor.Close(context.TODO())
+	return user, nil
+}
 
-	for cursor.Next(context.TODO()) {
-		var user drive.User
-		if err := cursor.Decode(&user); err != nil {
-			return drive.User{}, err
-		}
+func (cfw *DriveWorker) GetUser(username string, password string) (drive.User, error) {
+	coll := cfw.client.Database(cfw.db).Collection("users")
+	filter := bson.M{"name": username, "password": password}
 
-		if user.Name == username && user.Password == password {
-			return user, nil
-		}
+	var user drive.User
+	err := coll.FindOne(cfw.ctx(), filter).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return drive.User{}, fmt.Errorf("authuser not found: %s", username)
+	}
+
+	if err != nil {
+		return drive.User{}, err
 	}
 
-	return drive.User{}, fmt.Errorf("authuser not found: %s", username)
+	return user, nil
 }
 
 func (cfw *DriveWorker) Start() error {
-	err := cfw.client.Ping(context.TODO(), nil)
+	err := cfw.client.Ping(cfw.ctx(), nil)
 	if err != nil {
 		return err
 	}
 
+	var hello bson.M
+	err = cfw.client.Database("admin").RunCommand(cfw.ctx(), bson.D{{Key: "hello", Value: 1}}).Decode(&hello)
+	if err != nil {
+		return err
+	}
+
+	_, replicaSet := hello["setName"]
+	cfw.transactions = replicaSet || hello["msg"] == "isdbgrid"
+
+	if err := cfw.migrateGrants(); err != nil {
+		return err
+	}
+
+	if err := cfw.migrateParents(); err != nil {
+		return err
+	}
+
+	return cfw.createIndexes()
+}
+
+// migrateGrants moves grants saved before groups existed to the key that
+// includes the group, so user and group grants can share a resource.
+func (cfw *DriveWorker) migrateGrants() error {
+	coll := cfw.client.Database(cfw.db).Collection("grants")
+	_, err := coll.UpdateMany(cfw.ctx(), bson.M{"groupId": bson.M{"$exists": false}}, bson.M{"$set": bson.M{"groupId": ""}})
+	if err != nil {
+		return err
+	}
+
+	_, err = coll.Indexes().DropOne(cfw.ctx(), "resourceId_1_userId_1")
+	var cmdErr mongo.CommandError
+	if errors.As(err, &cmdErr) && (cmdErr.Code == 26 || cmdErr.Code == 27) {
+		return nil
+	}
+
+	return err
+}
+
+// migrateParents fills in the parent and trashed fields of resources saved
+// before they were stored, so path lookups and the unique name indexes
+// cover them. The parent is the folder whose content lists the resource,
+// and resources no folder lists sit at the top level.
+func (cfw *DriveWorker) migrateParents() error {
+	coll := cfw.client.Database(cfw.db).Collection("resources")
+	_, err := coll.UpdateMany(cfw.ctx(), bson.M{"trashed": bson.M{"$exists": false}}, bson.M{"$set": bson.M{"trashed": false}})
+	if err != nil {
+		return err
+	}
+
+	cursor, err := coll.Find(cfw.ctx(), bson.M{"parent": bson.M{"$exists": false}})
+	if err != nil {
+		return err
+	}
+
+	var resources []drive.Resource
+	if err := cursor.All(cfw.ctx(), &resources); err != nil {
+		return err
+	}
+
+	for _, resource := range resources {
+		var parent drive.Resource
+		err := coll.FindOne(cfw.ctx(), bson.M{"content": resource.Id}).Decode(&parent)
+		if err != nil && err != mongo.ErrNoDocuments {
+			return err
+		}
+
+		_, err = coll.UpdateOne(cfw.ctx(), bson.M{"id": resource.Id}, bson.M{"$set": bson.M{"parent": parent.Id}})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (cfw *DriveWorker) createIndexes() error {
+	indexes := map[string][]mongo.IndexModel{
+		"resources": {
+			{Keys: bson.D{{Key: "id", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "parent", Value: 1}, {Key: "name", Value: 1}}},
+			// Names are unique per folder, and per owner at the top level.
+			{
+				Keys: bson.D{{Key: "parent", Value: 1}, {Key: "name", Value: 1}, {Key: "trashed", Value: 1}},
+				Options: options.Index().SetName("resources_child_name").SetUnique(true).
+					SetPartialFilterExpression(bson.M{"parent": bson.M{"$gt": ""}, "trashed": false}),
+			},
+			{
+				Keys: bson.D{{Key: "ownerId", Value: 1}, {Key: "name", Value: 1}, {Key: "parent", Value: 1}},
+				Options: options.Index().SetName("resources_top_name").SetUnique(true).
+					SetPartialFilterExpression(bson.M{"parent": "", "trashed": false}),
+			},
+			{Keys: bson.D{{Key: "name", Value: 1}}},
+			{Keys: bson.D{{Key: "content", Value: 1}}},
+			{Keys: bson.D{{Key: "trashRoot", Value: 1}}},
+			{Keys: bson.D{{Key: "trashedBy", Value: 1}}},
+		},
+		"users": {
+			{Keys: bson.D{{Key: "id", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "name", Value: 1}}, Options: options.Index().SetUnique(true)},
+		},
+		"uploads": {
+			{Keys: bson.D{{Key: "id", Value: 1}}, Options: options.Index().SetUnique(true)},
+		},
+		"blobs": {
+			{Keys: bson.D{{Key: "hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		},
+		"links": {
+			{Keys: bson.D{{Key: "id", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "tokenHash", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "ownerId", Value: 1}}},
+		},
+		"grants": {
+			{Keys: bson.D{{Key: "resourceId", Value: 1}, {Key: "userId", Value: 1}, {Key: "groupId", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "userId", Value: 1}}},
+			{Keys: bson.D{{Key: "groupId", Value: 1}}},
+		},
+		"groups": {
+			{Keys: bson.D{{Key: "id", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "members", Value: 1}}},
+		},
+		"revocations": {
+			{Keys: bson.D{{Key: "jti", Value: 1}, {Key: "userId", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "expiresAt", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+		},
+	}
+
+	for collection, models := range indexes {
+		coll := cfw.client.Database(cfw.db).Collection(collection)
+		if _, err := coll.Indexes().CreateMany(cfw.ctx(), models); err != nil {
+			return fmt.Errorf("index creation on %s failed: %w", collection, err)
+		}
+	}
+
 	return nil
 }
 
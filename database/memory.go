@@ -0,0 +1,716 @@
+package database
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/c4me-caro/drive"
+)
+
+// MemoryStore keeps every document in process memory. It mirrors the
+// behaviour of DriveWorker and is meant for tests and throwaway instances.
+type MemoryStore struct {
+	txMu      sync.Mutex
+	mu        sync.RWMutex
+	order     []string
+	resources map[string]drive.Resource
+	users     map[string]drive.User
+	uploads   map[string]drive.Upload
+	blobs     map[string]drive.Blob
+	links     map[string]drive.ShareLink
+	grants    map[string]drive.Grant
+	groups    map[string]drive.Group
+	revoked   map[string]drive.Revocation
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		order:     []string{},
+		resources: make(map[string]drive.Resource),
+		users:     make(map[string]drive.User),
+		uploads:   make(map[string]drive.Upload),
+		blobs:     make(map[string]drive.Blob),
+		links:     make(map[string]drive.ShareLink),
+		grants:    make(map[string]drive.Grant),
+		groups:    make(map[string]drive.Group),
+		revoked:   make(map[string]drive.Revocation),
+	}
+}
+
+// cloneResource copies the slices of a resource so callers never share
+// memory with the stored document.
+func cloneResource(resource drive.Resource) drive.Resource {
+	resource.SharedId = slices.Clone(resource.SharedId)
+	resource.Content = slices.Clone(resource.Content)
+	resource.Ancestors = slices.Clone(resource.Ancestors)
+	resource.Tags = slices.Clone(resource.Tags)
+	resource.Versions = slices.Clone(resource.Versions)
+	if resource.Retention != nil {
+		retention := *resource.Retention
+		resource.Retention = &retention
+	}
+
+	return resource
+}
+
+func cloneUser(user drive.User) drive.User {
+	user.Permissions = slices.Clone(user.Permissions)
+	return user
+}
+
+func cloneGroup(group drive.Group) drive.Group {
+	group.Members = slices.Clone(group.Members)
+	group.Permissions = slices.Clone(group.Permissions)
+	return group
+}
+
+func (ms *MemoryStore) findResources(match func(drive.Resource) bool) []drive.Resource {
+	resources := []drive.Resource{}
+	for _, id := range ms.order {
+		if resource := ms.resources[id]; match(resource) {
+			resources = append(resources, cloneResource(resource))
+		}
+	}
+
+	return resources
+}
+
+func (ms *MemoryStore) AddResourceChildren(resource drive.Resource, children string) error {
+	if resource.Id == "0" {
+		return fmt.Errorf("system update forbiden")
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	stored, ok := ms.resources[resource.Id]
+	if !ok || stored.Name != resource.Name {
+		return fmt.Errorf("%w: %s", ErrResourceNotFound, resource.Id)
+	}
+
+	stored.Content = append(slices.Clone(stored.Content), children)
+	ms.resources[resource.Id] = stored
+	return nil
+}
+
+func (ms *MemoryStore) RemoveResourceChildren(resource drive.Resource, children string) error {
+	if resource.Id == "0" {
+		return fmt.Errorf("system update forbiden")
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	stored, ok := ms.resources[resource.Id]
+	if ok {
+		stored.Content = slices.DeleteFunc(slices.Clone(stored.Content), func(id string) bool {
+			return id == children
+		})
+		ms.resources[resource.Id] = stored
+	}
+
+	return nil
+}
+
+func (ms *MemoryStore) GetParent(children string) (drive.Resource, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	found := ms.findResources(func(resource drive.Resource) bool {
+		return slices.Contains(resource.Content, children)
+	})
+
+	if len(found) == 0 {
+		return drive.Resource{}, fmt.Errorf("parent not found: %s", children)
+	}
+
+	return found[0], nil
+}
+
+func (ms *MemoryStore) GetChild(parent string, owner string, name string) (drive.Resource, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	found := ms.findResources(func(resource drive.Resource) bool {
+		return sameFolder(resource, drive.Resource{Parent: parent, OwnerId: owner}) && resource.Name == name && !resource.Trashed
+	})
+
+	if len(found) == 0 {
+		return drive.Resource{}, fmt.Errorf("resource not found: %s", name)
+	}
+
+	return found[0], nil
+}
+
+func (ms *MemoryStore) CheckResource(resource drive.Resource) error {
+	if resource.Id == "0" {
+		return fmt.Errorf("system check forbiden")
+	}
+
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	stored, ok := ms.resources[resource.Id]
+	if !ok || stored.Name != resource.Name {
+		return fmt.Errorf("resource not found: %s", resource.Name)
+	}
+
+	return nil
+}
+
+func (ms *MemoryStore) GetResource(search string) (drive.Resource, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	if resource, ok := ms.resources[search]; ok {
+		return cloneResource(resource), nil
+	}
+
+	found := ms.findResources(func(resource drive.Resource) bool {
+		return resource.Name == search && !resource.Trashed
+	})
+
+	if len(found) == 0 {
+		return drive.Resource{}, fmt.Errorf("resource not found: %s", search)
+	}
+
+	return found[0], nil
+}
+
+// sameFolder tells whether two resources sit in the same folder, top level
+// resources being kept apart by owner.
+func sameFolder(a drive.Resource, b drive.Resource) bool {
+	return a.Parent == b.Parent && (a.Parent != "" || a.OwnerId == b.OwnerId)
+}
+
+// nameConflict holds the memory store to the unique names the other stores
+// get from their indexes.
+func (ms *MemoryStore) nameConflict(resource drive.Resource) error {
+	if resource.Trashed {
+		return nil
+	}
+
+	for _, stored := range ms.resources {
+		if stored.Id != resource.Id && !stored.Trashed && stored.Name == resource.Name && sameFolder(stored, resource) {
+			return fmt.Errorf("%w: %s", ErrNameTaken, resource.Name)
+		}
+	}
+
+	return nil
+}
+
+func (ms *MemoryStore) CreateResource(resource drive.Resource) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if _, ok := ms.resources[resource.Id]; ok {
+		return fmt.Errorf("duplicated resource id: %s", resource.Id)
+	}
+
+	if err := ms.nameConflict(resource); err != nil {
+		return err
+	}
+
+	ms.order = append(ms.order, resource.Id)
+	ms.resources[resource.Id] = cloneResource(resource)
+	return nil
+}
+
+func (ms *MemoryStore) UpdateResource(resource drive.Resource) error {
+	if resource.Id == "0" {
+		return fmt.Errorf("system update forbiden")
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if _, ok := ms.resources[resource.Id]; !ok {
+		return fmt.Errorf("resource not found: %s", resource.Id)
+	}
+
+	if err := ms.nameConflict(resource); err != nil {
+		return err
+	}
+
+	ms.resources[resource.Id] = cloneResource(resource)
+	return nil
+}
+
+func (ms *MemoryStore) DeleteResource(resource drive.Resource) error {
+	if resource.Id == "0" {
+		return fmt.Errorf("system deletion forbiden")
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	stored, ok := ms.resources[resource.Id]
+	if !ok || stored.Name != resource.Name {
+		return fmt.Errorf("%w: %s", ErrResourceNotFound, resource.Id)
+	}
+
+	delete(ms.resources, resource.Id)
+	ms.order = slices.DeleteFunc(ms.order, func(id string) bool {
+		return id == resource.Id
+	})
+
+	return nil
+}
+
+func (ms *MemoryStore) ListTrash(userid string) ([]drive.Resource, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	return ms.findResources(func(resource drive.Resource) bool {
+		return resource.Trashed && resource.TrashedBy == userid && resource.TrashRoot == resource.Id
+	}), nil
+}
+
+func (ms *MemoryStore) ListTrashTree(root string) ([]drive.Resource, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	return ms.findResources(func(resource drive.Resource) bool {
+		return resource.Trashed && resource.TrashRoot == root
+	}), nil
+}
+
+func (ms *MemoryStore) ListExpiredTrash(before time.Time) ([]drive.Resource, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	return ms.findResources(func(resource drive.Resource) bool {
+		return resource.Trashed && resource.TrashedAt.Before(before) && resource.TrashRoot == resource.Id
+	}), nil
+}
+
+func (ms *MemoryStore) ListResources() ([]drive.Resource, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	return ms.findResources(func(drive.Resource) bool {
+		return true
+	}), nil
+}
+
+// Transaction restores a snapshot of the store when fn fails. Transactions
+// are serialized with each other, not with plain writes.
+func (ms *MemoryStore) Transaction(fn func(ResourceStore) error) error {
+	ms.txMu.Lock()
+	defer ms.txMu.Unlock()
+
+	ms.mu.RLock()
+	order := slices.Clone(ms.order)
+	resources := make(map[string]drive.Resource, len(ms.resources))
+	for id, resource := range ms.resources {
+		resources[id] = cloneResource(resource)
+	}
+
+	uploads := make(map[string]drive.Upload, len(ms.uploads))
+	for id, upload := range ms.uploads {
+		uploads[id] = upload
+	}
+
+	blobs := make(map[string]drive.Blob, len(ms.blobs))
+	for hash, blob := range ms.blobs {
+		blobs[hash] = blob
+	}
+	ms.mu.RUnlock()
+
+	err := fn(ms)
+	if err != nil {
+		ms.mu.Lock()
+		ms.order = order
+		ms.resources = resources
+		ms.uploads = uploads
+		ms.blobs = blobs
+		ms.mu.Unlock()
+	}
+
+	return err
+}
+
+func (ms *MemoryStore) CreateUpload(upload drive.Upload) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	ms.uploads[upload.Id] = upload
+	return nil
+}
+
+func (ms *MemoryStore) GetUpload(id string) (drive.Upload, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	upload, ok := ms.uploads[id]
+	if !ok {
+		return drive.Upload{}, fmt.Errorf("upload not found: %s", id)
+	}
+
+	return upload, nil
+}
+
+func (ms *MemoryStore) UpdateUploadOffset(id string, offset int64) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if upload, ok := ms.uploads[id]; ok {
+		upload.Offset = offset
+		ms.uploads[id] = upload
+	}
+
+	return nil
+}
+
+func (ms *MemoryStore) DeleteUpload(id string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	delete(ms.uploads, id)
+	return nil
+}
+
+func (ms *MemoryStore) ListUploads() ([]drive.Upload, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	uploads := make([]drive.Upload, 0, len(ms.uploads))
+	for _, upload := range ms.uploads {
+		uploads = append(uploads, upload)
+	}
+
+	return uploads, nil
+}
+
+func (ms *MemoryStore) AcquireBlob(blob drive.Blob) (drive.Blob, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	stored, ok := ms.blobs[blob.Hash]
+	if !ok {
+		stored = blob
+		stored.Refs = 0
+	}
+
+	stored.Refs++
+	ms.blobs[blob.Hash] = stored
+	return stored, nil
+}
+
+func (ms *MemoryStore) ReleaseBlob(hash string) (int, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	stored := ms.blobs[hash]
+	stored.Refs--
+	if stored.Refs <= 0 {
+		delete(ms.blobs, hash)
+		return 0, nil
+	}
+
+	ms.blobs[hash] = stored
+	return stored.Refs, nil
+}
+
+func (ms *MemoryStore) GetBlob(hash string) (drive.Blob, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	blob, ok := ms.blobs[hash]
+	if !ok {
+		return drive.Blob{}, fmt.Errorf("blob not found: %s", hash)
+	}
+
+	return blob, nil
+}
+
+func (ms *MemoryStore) SetBlobKey(blob drive.Blob) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if stored, ok := ms.blobs[blob.Hash]; ok {
+		stored.KeyId = blob.KeyId
+		stored.DataKey = blob.DataKey
+		ms.blobs[blob.Hash] = stored
+	}
+
+	return nil
+}
+
+func (ms *MemoryStore) ListBlobs() ([]drive.Blob, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	blobs := make([]drive.Blob, 0, len(ms.blobs))
+	for _, blob := range ms.blobs {
+		blobs = append(blobs, blob)
+	}
+
+	return blobs, nil
+}
+
+func (ms *MemoryStore) CreateShareLink(link drive.ShareLink) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	ms.links[link.Id] = link
+	return nil
+}
+
+func (ms *MemoryStore) GetShareLink(tokenHash string) (drive.ShareLink, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	for _, link := range ms.links {
+		if link.TokenHash == tokenHash {
+			return link, nil
+		}
+	}
+
+	return drive.ShareLink{}, fmt.Errorf("share link not found")
+}
+
+func (ms *MemoryStore) ListShareLinks(ownerId string) ([]drive.ShareLink, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	links := []drive.ShareLink{}
+	for _, link := range ms.links {
+		if link.OwnerId == ownerId {
+			links = append(links, link)
+		}
+	}
+
+	return links, nil
+}
+
+func (ms *MemoryStore) DeleteShareLink(id string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	delete(ms.links, id)
+	return nil
+}
+
+func (ms *MemoryStore) UseShareLink(id string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	link, ok := ms.links[id]
+	if !ok || (link.MaxDownloads > 0 && link.Downloads >= link.MaxDownloads) {
+		return fmt.Errorf("share link download limit reached: %s", id)
+	}
+
+	link.Downloads++
+	ms.links[id] = link
+	return nil
+}
+
+func grantKey(resourceId string, userId string, groupId string) string {
+	return resourceId + "/" + userId + "/" + groupId
+}
+
+func (ms *MemoryStore) SaveGrant(grant drive.Grant) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	ms.grants[grantKey(grant.ResourceId, grant.UserId, grant.GroupId)] = grant
+	return nil
+}
+
+func (ms *MemoryStore) ListGrants(resourceIds ...string) ([]drive.Grant, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	grants := []drive.Grant{}
+	for _, grant := range ms.grants {
+		if slices.Contains(resourceIds, grant.ResourceId) {
+			grants = append(grants, grant)
+		}
+	}
+
+	return grants, nil
+}
+
+func (ms *MemoryStore) DeleteGrant(resourceId string, userId string, groupId string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	delete(ms.grants, grantKey(resourceId, userId, groupId))
+	return nil
+}
+
+func (ms *MemoryStore) CreateGroup(group drive.Group) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if _, ok := ms.groups[group.Id]; ok {
+		return fmt.Errorf("duplicated group id: %s", group.Id)
+	}
+
+	ms.groups[group.Id] = cloneGroup(group)
+	return nil
+}
+
+func (ms *MemoryStore) GetGroup(id string) (drive.Group, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	group, ok := ms.groups[id]
+	if !ok {
+		return drive.Group{}, fmt.Errorf("group not found: %s", id)
+	}
+
+	return cloneGroup(group), nil
+}
+
+func (ms *MemoryStore) ListGroups(userId string) ([]drive.Group, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	groups := []drive.Group{}
+	for _, group := range ms.groups {
+		if slices.Contains(group.Members, userId) {
+			groups = append(groups, cloneGroup(group))
+		}
+	}
+
+	return groups, nil
+}
+
+func (ms *MemoryStore) AddGroupMember(groupId string, userId string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	group, ok := ms.groups[groupId]
+	if !ok {
+		return fmt.Errorf("group not found: %s", groupId)
+	}
+
+	if !slices.Contains(group.Members, userId) {
+		group.Members = append(slices.Clone(group.Members), userId)
+		ms.groups[groupId] = group
+	}
+
+	return nil
+}
+
+func (ms *MemoryStore) RemoveGroupMember(groupId string, userId string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	group, ok := ms.groups[groupId]
+	if !ok {
+		return fmt.Errorf("group not found: %s", groupId)
+	}
+
+	group.Members = slices.DeleteFunc(slices.Clone(group.Members), func(id string) bool {
+		return id == userId
+	})
+
+	ms.groups[groupId] = group
+	return nil
+}
+
+func (ms *MemoryStore) DeleteGroup(id string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	delete(ms.groups, id)
+	return nil
+}
+
+func (ms *MemoryStore) SaveRevocation(revocation drive.Revocation) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	ms.revoked[revocation.Jti+"/"+revocation.UserId] = revocation
+	return nil
+}
+
+func (ms *MemoryStore) AddRevocation(revocation drive.Revocation) (bool, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	key := revocation.Jti + "/" + revocation.UserId
+	if _, ok := ms.revoked[key]; ok {
+		return false, nil
+	}
+
+	ms.revoked[key] = revocation
+	return true, nil
+}
+
+func (ms *MemoryStore) ListRevocations(now time.Time) ([]drive.Revocation, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	revocations := []drive.Revocation{}
+	for _, revocation := range ms.revoked {
+		if revocation.ExpiresAt.After(now) {
+			revocations = append(revocations, revocation)
+		}
+	}
+
+	return revocations, nil
+}
+
+func (ms *MemoryStore) PruneRevocations(now time.Time) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	for key, revocation := range ms.revoked {
+		if !revocation.ExpiresAt.After(now) {
+			delete(ms.revoked, key)
+		}
+	}
+
+	return nil
+}
+
+// CreateUser seeds a user, since users are otherwise managed outside the
+// API.
+func (ms *MemoryStore) CreateUser(user drive.User) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	for _, stored := range ms.users {
+		if stored.Name == user.Name {
+			return fmt.Errorf("duplicated user name: %s", user.Name)
+		}
+	}
+
+	ms.users[user.Id] = cloneUser(user)
+	return nil
+}
+
+func (ms *MemoryStore) GetUserById(userid string) (drive.User, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	user, ok := ms.users[userid]
+	if !ok {
+		return drive.User{}, fmt.Errorf("userid not found: %s", userid)
+	}
+
+	return cloneUser(user), nil
+}
+
+func (ms *MemoryStore) GetUser(username string, password string) (drive.User, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	for _, user := range ms.users {
+		if user.Name == username && user.Password == password {
+			return cloneUser(user), nil
+		}
+	}
+
+	return drive.User{}, fmt.Errorf("authuser not found: %s", username)
+}
+
+func (ms *MemoryStore) Start() error {
+	return nil
+}
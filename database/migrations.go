@@ -0,0 +1,193 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// migrationStep changes data in ways a single portable statement cannot.
+// It runs in the transaction of its migration, between the statements
+// around it.
+type migrationStep func(sw *SQLWorker, tx *sql.Tx) error
+
+// migrations holds the relational schema, one entry per version. Each
+// entry lists SQL statements and migration steps, run in order. Entries
+// are append only: existing installs replay only the versions they miss.
+var migrations = [][]any{
+	{
+		`CREATE TABLE resources (
+			id TEXT PRIMARY KEY,
+			name TEXT NOT NULL,
+			parent TEXT NOT NULL DEFAULT '',
+			trashed INTEGER NOT NULL DEFAULT 0,
+			trash_root TEXT NOT NULL DEFAULT '',
+			trashed_by TEXT NOT NULL DEFAULT '',
+			trashed_at BIGINT NOT NULL DEFAULT 0,
+			data TEXT NOT NULL
+		)`,
+		`CREATE INDEX resources_parent_name ON resources (parent, name)`,
+		`CREATE INDEX resources_name ON resources (name)`,
+		`CREATE INDEX resources_trash_root ON resources (trash_root)`,
+		`CREATE INDEX resources_trashed_by ON resources (trashed_by)`,
+		`CREATE TABLE resource_content (
+			parent TEXT NOT NULL,
+			child TEXT NOT NULL
+		)`,
+		`CREATE INDEX resource_content_parent ON resource_content (parent)`,
+		`CREATE INDEX resource_content_child ON resource_content (child)`,
+		`CREATE TABLE users (
+			id TEXT PRIMARY KEY,
+			name TEXT NOT NULL UNIQUE,
+			role TEXT NOT NULL DEFAULT '',
+			password TEXT NOT NULL,
+			permissions TEXT NOT NULL DEFAULT '[]'
+		)`,
+		`CREATE TABLE uploads (
+			id TEXT PRIMARY KEY,
+			data TEXT NOT NULL
+		)`,
+	},
+	{
+		`CREATE TABLE blobs (
+			hash TEXT PRIMARY KEY,
+			refs INTEGER NOT NULL
+		)`,
+	},
+	{
+		`ALTER TABLE blobs ADD COLUMN key_id TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE blobs ADD COLUMN data_key TEXT NOT NULL DEFAULT ''`,
+	},
+	{
+		`CREATE TABLE share_links (
+			id TEXT PRIMARY KEY,
+			token_hash TEXT NOT NULL UNIQUE,
+			owner_id TEXT NOT NULL,
+			max_downloads INTEGER NOT NULL DEFAULT 0,
+			downloads INTEGER NOT NULL DEFAULT 0,
+			data TEXT NOT NULL
+		)`,
+		`CREATE INDEX share_links_owner_id ON share_links (owner_id)`,
+	},
+	{
+		`CREATE TABLE grants (
+			resource_id TEXT NOT NULL,
+			user_id TEXT NOT NULL,
+			data TEXT NOT NULL,
+			PRIMARY KEY (resource_id, user_id)
+		)`,
+		`CREATE INDEX grants_user_id ON grants (user_id)`,
+	},
+	{
+		`CREATE TABLE grants_v6 (
+			resource_id TEXT NOT NULL,
+			user_id TEXT NOT NULL,
+			group_id TEXT NOT NULL DEFAULT '',
+			data TEXT NOT NULL,
+			PRIMARY KEY (resource_id, user_id, group_id)
+		)`,
+		`INSERT INTO grants_v6 (resource_id, user_id, data) SELECT resource_id, user_id, data FROM grants`,
+		`DROP TABLE grants`,
+		`ALTER TABLE grants_v6 RENAME TO grants`,
+		`CREATE INDEX grants_user_id ON grants (user_id)`,
+		`CREATE INDEX grants_group_id ON grants (group_id)`,
+		`CREATE TABLE user_groups (
+			id TEXT PRIMARY KEY,
+			owner_id TEXT NOT NULL,
+			data TEXT NOT NULL
+		)`,
+		`CREATE TABLE group_members (
+			group_id TEXT NOT NULL,
+			user_id TEXT NOT NULL,
+			PRIMARY KEY (group_id, user_id)
+		)`,
+		`CREATE INDEX group_members_user_id ON group_members (user_id)`,
+	},
+	{
+		`CREATE TABLE revocations (
+			jti TEXT NOT NULL,
+			user_id TEXT NOT NULL,
+			expires_at BIGINT NOT NULL,
+			data TEXT NOT NULL,
+			PRIMARY KEY (jti, user_id)
+		)`,
+		`CREATE INDEX revocations_expires_at ON revocations (expires_at)`,
+	},
+	{
+		// Names are unique per folder, and per owner at the top level.
+		// owner_id has to be filled in before the index on it is built.
+		`ALTER TABLE resources ADD COLUMN owner_id TEXT NOT NULL DEFAULT ''`,
+		migrationStep(backfillOwners),
+		`CREATE UNIQUE INDEX resources_child_name ON resources (parent, name) WHERE parent <> '' AND trashed = 0`,
+		`CREATE UNIQUE INDEX resources_top_name ON resources (owner_id, name) WHERE parent = '' AND trashed = 0`,
+	},
+}
+
+func (sw *SQLWorker) migrate() error {
+	_, err := sw.db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY)`)
+	if err != nil {
+		return err
+	}
+
+	var current int
+	err = sw.db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current)
+	if err != nil {
+		return err
+	}
+
+	for version := current + 1; version <= len(migrations); version++ {
+		tx, err := sw.db.Begin()
+		if err != nil {
+			return err
+		}
+
+		for _, step := range migrations[version-1] {
+			var err error
+			switch step := step.(type) {
+			case string:
+				_, err = tx.Exec(step)
+			case migrationStep:
+				err = step(sw, tx)
+			default:
+				err = fmt.Errorf("unknown step %T", step)
+			}
+
+			if err != nil {
+				tx.Rollback()
+				return fmt.Errorf("migration %d failed: %w", version, err)
+			}
+		}
+
+		if _, err := tx.Exec(sw.rebind(`INSERT INTO schema_migrations (version) VALUES (?)`), version); err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// backfillOwners copies the owner of resources saved before it had a
+// column of its own out of their documents.
+func backfillOwners(sw *SQLWorker, tx *sql.Tx) error {
+	resources, err := sw.queryResources(tx, `owner_id = ''`)
+	if err != nil {
+		return err
+	}
+
+	for _, resource := range resources {
+		if resource.OwnerId == "" {
+			continue
+		}
+
+		_, err := tx.Exec(sw.rebind(`UPDATE resources SET owner_id = ? WHERE id = ?`), resource.OwnerId, resource.Id)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
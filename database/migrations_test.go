@@ -0,0 +1,86 @@
+package database
+
+import (
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/c4me-caro/drive"
+)
+
+// newSQLiteWorker opens a fresh SQLite store migrated to version.
+func newSQLiteWorker(t *testing.T, version int) *SQLWorker {
+	t.Helper()
+
+	sw, err := NewSQLWorker("sqlite", filepath.Join(t.TempDir(), "drive.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { sw.db.Close() })
+
+	all := migrations
+	migrations = all[:version]
+	defer func() { migrations = all }()
+
+	if err := sw.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	return sw
+}
+
+func TestMigrateFromEmpty(t *testing.T) {
+	sw := newSQLiteWorker(t, len(migrations))
+
+	var version int
+	if err := sw.db.QueryRow(`SELECT MAX(version) FROM schema_migrations`).Scan(&version); err != nil {
+		t.Fatal(err)
+	}
+
+	if version != len(migrations) {
+		t.Fatalf("version = %d, want %d", version, len(migrations))
+	}
+
+	// Starting again replays nothing.
+	if err := sw.Start(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMigrateBackfillsOwners(t *testing.T) {
+	sw := newSQLiteWorker(t, 7)
+
+	// Before version 8 top level names were only unique per folder, so two
+	// users may both have a "docs".
+	for _, resource := range []drive.Resource{
+		{Id: "a", Name: "docs", OwnerId: "u1", Type: "folder"},
+		{Id: "b", Name: "docs", OwnerId: "u2", Type: "folder"},
+	} {
+		data, err := json.Marshal(resource)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := sw.db.Exec(`INSERT INTO resources (id, name, data) VALUES (?, ?, ?)`, resource.Id, resource.Name, string(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := sw.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	for owner, id := range map[string]string{"u1": "a", "u2": "b"} {
+		resource, err := sw.GetChild("", owner, "docs")
+		if err != nil || resource.Id != id {
+			t.Fatalf("docs of %s = %+v, %v, want %s", owner, resource, err, id)
+		}
+	}
+
+	err := sw.CreateResource(drive.Resource{Id: "c", Name: "docs", OwnerId: "u1", Type: "folder"})
+	if !errors.Is(err, ErrNameTaken) {
+		t.Fatalf("err = %v, want ErrNameTaken", err)
+	}
+}
@@ -0,0 +1,955 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/c4me-caro/drive"
+	"github.com/lib/pq"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// SQLWorker stores metadata in SQLite or PostgreSQL. Documents are kept as
+// JSON next to the columns the queries filter on.
+type SQLWorker struct {
+	db      *sql.DB
+	tx      *sql.Tx
+	dialect string
+}
+
+type sqlRunner interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+func NewSQLWorker(dialect string, dsn string) (*SQLWorker, error) {
+	if dialect != "sqlite" && dialect != "postgres" {
+		return nil, fmt.Errorf("unknown sql dialect: %s", dialect)
+	}
+
+	// SQLite takes the write lock when a transaction begins, so rows read
+	// to be changed cannot be written by another connection meanwhile, and
+	// waits for that lock instead of failing at once.
+	if dialect == "sqlite" && !strings.Contains(dsn, "_txlock=") {
+		separator := "?"
+		if strings.Contains(dsn, "?") {
+			separator = "&"
+		}
+
+		dsn += separator + "_txlock=immediate&_pragma=busy_timeout(5000)"
+	}
+
+	db, err := sql.Open(dialect, dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	if dialect == "sqlite" {
+		db.SetMaxOpenConns(1)
+	}
+
+	return &SQLWorker{
+		db:      db,
+		dialect: dialect,
+	}, nil
+}
+
+// rebind turns ? placeholders into the $n form PostgreSQL expects.
+func (sw *SQLWorker) rebind(query string) string {
+	if sw.dialect != "postgres" {
+		return query
+	}
+
+	var b strings.Builder
+	n := 0
+	for _, c := range query {
+		if c == '?' {
+			n++
+			fmt.Fprintf(&b, "$%d", n)
+			continue
+		}
+
+		b.WriteRune(c)
+	}
+
+	return b.String()
+}
+
+// runner returns the open transaction, if any, so every query of a worker
+// bound to a transaction goes through it.
+func (sw *SQLWorker) runner() sqlRunner {
+	if sw.tx != nil {
+		return sw.tx
+	}
+
+	return sw.db
+}
+
+// forUpdate locks the rows a query reads until its transaction ends, so
+// read, change and write back cycles do not lose concurrent updates.
+// SQLite needs no clause: its transactions hold the write lock already.
+func (sw *SQLWorker) forUpdate() string {
+	if sw.dialect == "postgres" {
+		return ` FOR UPDATE`
+	}
+
+	return ""
+}
+
+func (sw *SQLWorker) inTx(fn func(tx *sql.Tx) error) error {
+	if sw.tx != nil {
+		return fn(sw.tx)
+	}
+
+	tx, err := sw.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (sw *SQLWorker) queryResources(runner sqlRunner, where string, args ...any) ([]drive.Resource, error) {
+	rows, err := runner.Query(sw.rebind(`SELECT data FROM resources WHERE `+where), args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	resources := []drive.Resource{}
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+
+		var resource drive.Resource
+		if err := json.Unmarshal([]byte(data), &resource); err != nil {
+			return nil, err
+		}
+
+		resources = append(resources, resource)
+	}
+
+	return resources, rows.Err()
+}
+
+func (sw *SQLWorker) queryResource(runner sqlRunner, where string, args ...any) (drive.Resource, bool, error) {
+	resources, err := sw.queryResources(runner, where+` LIMIT 1`, args...)
+	if err != nil || len(resources) == 0 {
+		return drive.Resource{}, false, err
+	}
+
+	return resources[0], true, nil
+}
+
+func boolInt(value bool) int {
+	if value {
+		return 1
+	}
+
+	return 0
+}
+
+func trashTime(resource drive.Resource) int64 {
+	if resource.TrashedAt.IsZero() {
+		return 0
+	}
+
+	return resource.TrashedAt.UnixNano()
+}
+
+// saveResource writes the resource row and rebuilds its content rows so the
+// child lookups used by GetParent stay indexed.
+func (sw *SQLWorker) saveResource(tx *sql.Tx, resource drive.Resource, insert bool) (bool, error) {
+	data, err := json.Marshal(resource)
+	if err != nil {
+		return false, err
+	}
+
+	args := []any{resource.Name, resource.Parent, resource.OwnerId, boolInt(resource.Trashed), resource.TrashRoot, resource.TrashedBy, trashTime(resource), string(data), resource.Id}
+	query := `UPDATE resources SET name = ?, parent = ?, owner_id = ?, trashed = ?, trash_root = ?, trashed_by = ?, trashed_at = ?, data = ? WHERE id = ?`
+	if insert {
+		query = `INSERT INTO resources (name, parent, owner_id, trashed, trash_root, trashed_by, trashed_at, data, id) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	}
+
+	result, err := tx.Exec(sw.rebind(query), args...)
+	if isUniqueViolation(err) {
+		return false, fmt.Errorf("%w: %s", ErrNameTaken, resource.Name)
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+		return false, err
+	}
+
+	if _, err := tx.Exec(sw.rebind(`DELETE FROM resource_content WHERE parent = ?`), resource.Id); err != nil {
+		return false, err
+	}
+
+	for _, child := range resource.Content {
+		if _, err := tx.Exec(sw.rebind(`INSERT INTO resource_content (parent, child) VALUES (?, ?)`), resource.Id, child); err != nil {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+// isUniqueViolation tells whether err comes from one of the unique name
+// indexes on resources.
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == "23505" && strings.HasPrefix(pqErr.Constraint, "resources_") && strings.HasSuffix(pqErr.Constraint, "_name")
+	}
+
+	var liteErr *sqlite.Error
+	return errors.As(err, &liteErr) && liteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE && strings.Contains(liteErr.Error(), "resources.name")
+}
+
+func (sw *SQLWorker) AddResourceChildren(resource drive.Resource, children string) error {
+	if resource.Id == "0" {
+		return fmt.Errorf("system update forbiden")
+	}
+
+	return sw.inTx(func(tx *sql.Tx) error {
+		stored, ok, err := sw.queryResource(tx, `id = ? AND name = ?`+sw.forUpdate(), resource.Id, resource.Name)
+		if err != nil {
+			return err
+		}
+
+		if !ok {
+			return fmt.Errorf("%w: %s", ErrResourceNotFound, resource.Id)
+		}
+
+		stored.Content = append(stored.Content, children)
+		_, err = sw.saveResource(tx, stored, false)
+		return err
+	})
+}
+
+func (sw *SQLWorker) RemoveResourceChildren(resource drive.Resource, children string) error {
+	if resource.Id == "0" {
+		return fmt.Errorf("system update forbiden")
+	}
+
+	return sw.inTx(func(tx *sql.Tx) error {
+		stored, ok, err := sw.queryResource(tx, `id = ?`+sw.forUpdate(), resource.Id)
+		if err != nil || !ok {
+			return err
+		}
+
+		stored.Content = slices.DeleteFunc(stored.Content, func(id string) bool {
+			return id == children
+		})
+
+		_, err = sw.saveResource(tx, stored, false)
+		return err
+	})
+}
+
+func (sw *SQLWorker) GetParent(children string) (drive.Resource, error) {
+	resource, ok, err := sw.queryResource(sw.runner(), `id IN (SELECT parent FROM resource_content WHERE child = ?)`, children)
+	if err != nil {
+		return drive.Resource{}, err
+	}
+
+	if !ok {
+		return drive.Resource{}, fmt.Errorf("parent not found: %s", children)
+	}
+
+	return resource, nil
+}
+
+func (sw *SQLWorker) GetChild(parent string, owner string, name string) (drive.Resource, error) {
+	where, args := `parent = ? AND name = ? AND trashed = 0`, []any{parent, name}
+	if parent == "" {
+		where, args = where+` AND owner_id = ?`, append(args, owner)
+	}
+
+	resource, ok, err := sw.queryResource(sw.runner(), where, args...)
+	if err != nil {
+		return drive.Resource{}, err
+	}
+
+	if !ok {
+		return drive.Resource{}, fmt.Errorf("resource not found: %s", name)
+	}
+
+	return resource, nil
+}
+
+func (sw *SQLWorker) CheckResource(resource drive.Resource) error {
+	if resource.Id == "0" {
+		return fmt.Errorf("system check forbiden")
+	}
+
+	_, ok, err := sw.queryResource(sw.runner(), `id = ? AND name = ?`, resource.Id, resource.Name)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return fmt.Errorf("resource not found: %s", resource.Name)
+	}
+
+	return nil
+}
+
+func (sw *SQLWorker) GetResource(search string) (drive.Resource, error) {
+	resource, ok, err := sw.queryResource(sw.runner(), `id = ?`, search)
+	if err == nil && !ok {
+		resource, ok, err = sw.queryResource(sw.runner(), `name = ? AND trashed = 0`, search)
+	}
+
+	if err != nil {
+		return drive.Resource{}, err
+	}
+
+	if !ok {
+		return drive.Resource{}, fmt.Errorf("resource not found: %s", search)
+	}
+
+	return resource, nil
+}
+
+func (sw *SQLWorker) CreateResource(resource drive.Resource) error {
+	return sw.inTx(func(tx *sql.Tx) error {
+		_, err := sw.saveResource(tx, resource, true)
+		return err
+	})
+}
+
+func (sw *SQLWorker) UpdateResource(resource drive.Resource) error {
+	if resource.Id == "0" {
+		return fmt.Errorf("system update forbiden")
+	}
+
+	return sw.inTx(func(tx *sql.Tx) error {
+		found, err := sw.saveResource(tx, resource, false)
+		if err != nil {
+			return err
+		}
+
+		if !found {
+			return fmt.Errorf("resource not found: %s", resource.Id)
+		}
+
+		return nil
+	})
+}
+
+func (sw *SQLWorker) DeleteResource(resource drive.Resource) error {
+	if resource.Id == "0" {
+		return fmt.Errorf("system deletion forbiden")
+	}
+
+	return sw.inTx(func(tx *sql.Tx) error {
+		result, err := tx.Exec(sw.rebind(`DELETE FROM resources WHERE id = ? AND name = ?`), resource.Id, resource.Name)
+		if err != nil {
+			return err
+		}
+
+		affected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		if affected == 0 {
+			return fmt.Errorf("%w: %s", ErrResourceNotFound, resource.Id)
+		}
+
+		_, err = tx.Exec(sw.rebind(`DELETE FROM resource_content WHERE parent = ?`), resource.Id)
+		return err
+	})
+}
+
+func (sw *SQLWorker) ListTrash(userid string) ([]drive.Resource, error) {
+	return sw.queryResources(sw.runner(), `trashed = 1 AND trashed_by = ? AND trash_root = id`, userid)
+}
+
+func (sw *SQLWorker) ListTrashTree(root string) ([]drive.Resource, error) {
+	return sw.queryResources(sw.runner(), `trashed = 1 AND trash_root = ?`, root)
+}
+
+func (sw *SQLWorker) ListExpiredTrash(before time.Time) ([]drive.Resource, error) {
+	return sw.queryResources(sw.runner(), `trashed = 1 AND trashed_at < ? AND trash_root = id`, before.UnixNano())
+}
+
+func (sw *SQLWorker) ListResources() ([]drive.Resource, error) {
+	return sw.queryResources(sw.runner(), `1 = 1`)
+}
+
+func (sw *SQLWorker) Transaction(fn func(ResourceStore) error) error {
+	if sw.tx != nil {
+		return fn(sw)
+	}
+
+	tx, err := sw.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if err := fn(&SQLWorker{db: sw.db, tx: tx, dialect: sw.dialect}); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (sw *SQLWorker) CreateUpload(upload drive.Upload) error {
+	data, err := json.Marshal(upload)
+	if err != nil {
+		return err
+	}
+
+	_, err = sw.runner().Exec(sw.rebind(`INSERT INTO uploads (id, data) VALUES (?, ?)`), upload.Id, string(data))
+	return err
+}
+
+func (sw *SQLWorker) GetUpload(id string) (drive.Upload, error) {
+	var data string
+	err := sw.runner().QueryRow(sw.rebind(`SELECT data FROM uploads WHERE id = ?`), id).Scan(&data)
+	if err == sql.ErrNoRows {
+		return drive.Upload{}, fmt.Errorf("upload not found: %s", id)
+	}
+
+	if err != nil {
+		return drive.Upload{}, err
+	}
+
+	var upload drive.Upload
+	if err := json.Unmarshal([]byte(data), &upload); err != nil {
+		return drive.Upload{}, err
+	}
+
+	return upload, nil
+}
+
+func (sw *SQLWorker) UpdateUploadOffset(id string, offset int64) error {
+	upload, err := sw.GetUpload(id)
+	if err != nil {
+		return err
+	}
+
+	upload.Offset = offset
+	data, err := json.Marshal(upload)
+	if err != nil {
+		return err
+	}
+
+	_, err = sw.runner().Exec(sw.rebind(`UPDATE uploads SET data = ? WHERE id = ?`), string(data), id)
+	return err
+}
+
+func (sw *SQLWorker) DeleteUpload(id string) error {
+	_, err := sw.runner().Exec(sw.rebind(`DELETE FROM uploads WHERE id = ?`), id)
+	return err
+}
+
+func (sw *SQLWorker) ListUploads() ([]drive.Upload, error) {
+	rows, err := sw.runner().Query(`SELECT data FROM uploads`)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	uploads := []drive.Upload{}
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+
+		var upload drive.Upload
+		if err := json.Unmarshal([]byte(data), &upload); err != nil {
+			return nil, err
+		}
+
+		uploads = append(uploads, upload)
+	}
+
+	return uploads, rows.Err()
+}
+
+func (sw *SQLWorker) AcquireBlob(blob drive.Blob) (drive.Blob, error) {
+	var stored drive.Blob
+	err := sw.inTx(func(tx *sql.Tx) error {
+		query := `INSERT INTO blobs (hash, refs, key_id, data_key) VALUES (?, 1, ?, ?) ON CONFLICT (hash) DO UPDATE SET refs = blobs.refs + 1`
+		_, err := tx.Exec(sw.rebind(query), blob.Hash, blob.KeyId, base64.StdEncoding.EncodeToString(blob.DataKey))
+		if err != nil {
+			return err
+		}
+
+		stored, err = sw.queryBlob(tx, blob.Hash)
+		return err
+	})
+
+	return stored, err
+}
+
+func (sw *SQLWorker) queryBlob(runner sqlRunner, hash string) (drive.Blob, error) {
+	var blob drive.Blob
+	var dataKey string
+	err := runner.QueryRow(sw.rebind(`SELECT hash, refs, key_id, data_key FROM blobs WHERE hash = ?`), hash).Scan(&blob.Hash, &blob.Refs, &blob.KeyId, &dataKey)
+	if err == sql.ErrNoRows {
+		return drive.Blob{}, fmt.Errorf("blob not found: %s", hash)
+	}
+
+	if err != nil {
+		return drive.Blob{}, err
+	}
+
+	blob.DataKey, err = base64.StdEncoding.DecodeString(dataKey)
+	return blob, err
+}
+
+func (sw *SQLWorker) ReleaseBlob(hash string) (int, error) {
+	var refs int
+	err := sw.inTx(func(tx *sql.Tx) error {
+		_, err := tx.Exec(sw.rebind(`UPDATE blobs SET refs = refs - 1 WHERE hash = ?`), hash)
+		if err != nil {
+			return err
+		}
+
+		err = tx.QueryRow(sw.rebind(`SELECT refs FROM blobs WHERE hash = ?`), hash).Scan(&refs)
+		if err == sql.ErrNoRows {
+			refs = 0
+			return nil
+		}
+
+		if err != nil || refs > 0 {
+			return err
+		}
+
+		refs = 0
+		_, err = tx.Exec(sw.rebind(`DELETE FROM blobs WHERE hash = ?`), hash)
+		return err
+	})
+
+	return refs, err
+}
+
+func (sw *SQLWorker) GetBlob(hash string) (drive.Blob, error) {
+	return sw.queryBlob(sw.runner(), hash)
+}
+
+func (sw *SQLWorker) SetBlobKey(blob drive.Blob) error {
+	_, err := sw.runner().Exec(sw.rebind(`UPDATE blobs SET key_id = ?, data_key = ? WHERE hash = ?`), blob.KeyId, base64.StdEncoding.EncodeToString(blob.DataKey), blob.Hash)
+	return err
+}
+
+func (sw *SQLWorker) ListBlobs() ([]drive.Blob, error) {
+	rows, err := sw.runner().Query(`SELECT hash, refs, key_id, data_key FROM blobs`)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	blobs := []drive.Blob{}
+	for rows.Next() {
+		var blob drive.Blob
+		var dataKey string
+		if err := rows.Scan(&blob.Hash, &blob.Refs, &blob.KeyId, &dataKey); err != nil {
+			return nil, err
+		}
+
+		if blob.DataKey, err = base64.StdEncoding.DecodeString(dataKey); err != nil {
+			return nil, err
+		}
+
+		blobs = append(blobs, blob)
+	}
+
+	return blobs, rows.Err()
+}
+
+func (sw *SQLWorker) CreateShareLink(link drive.ShareLink) error {
+	data, err := json.Marshal(link)
+	if err != nil {
+		return err
+	}
+
+	_, err = sw.runner().Exec(sw.rebind(`INSERT INTO share_links (id, token_hash, owner_id, max_downloads, downloads, data) VALUES (?, ?, ?, ?, ?, ?)`),
+		link.Id, link.TokenHash, link.OwnerId, link.MaxDownloads, link.Downloads, string(data))
+	return err
+}
+
+// queryShareLinks reads the download count from its column, since
+// UseShareLink only updates that one.
+func (sw *SQLWorker) queryShareLinks(where string, args ...any) ([]drive.ShareLink, error) {
+	rows, err := sw.runner().Query(sw.rebind(`SELECT downloads, data FROM share_links WHERE `+where), args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	links := []drive.ShareLink{}
+	for rows.Next() {
+		var downloads int
+		var data string
+		if err := rows.Scan(&downloads, &data); err != nil {
+			return nil, err
+		}
+
+		var link drive.ShareLink
+		if err := json.Unmarshal([]byte(data), &link); err != nil {
+			return nil, err
+		}
+
+		link.Downloads = downloads
+		links = append(links, link)
+	}
+
+	return links, rows.Err()
+}
+
+func (sw *SQLWorker) GetShareLink(tokenHash string) (drive.ShareLink, error) {
+	links, err := sw.queryShareLinks(`token_hash = ?`, tokenHash)
+	if err != nil {
+		return drive.ShareLink{}, err
+	}
+
+	if len(links) == 0 {
+		return drive.ShareLink{}, fmt.Errorf("share link not found")
+	}
+
+	return links[0], nil
+}
+
+func (sw *SQLWorker) ListShareLinks(ownerId string) ([]drive.ShareLink, error) {
+	return sw.queryShareLinks(`owner_id = ?`, ownerId)
+}
+
+func (sw *SQLWorker) DeleteShareLink(id string) error {
+	_, err := sw.runner().Exec(sw.rebind(`DELETE FROM share_links WHERE id = ?`), id)
+	return err
+}
+
+func (sw *SQLWorker) UseShareLink(id string) error {
+	result, err := sw.runner().Exec(sw.rebind(`UPDATE share_links SET downloads = downloads + 1
+		WHERE id = ? AND (max_downloads = 0 OR downloads < max_downloads)`), id)
+	if err != nil {
+		return err
+	}
+
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return fmt.Errorf("share link download limit reached: %s", id)
+	}
+
+	return nil
+}
+
+func (sw *SQLWorker) SaveGrant(grant drive.Grant) error {
+	data, err := json.Marshal(grant)
+	if err != nil {
+		return err
+	}
+
+	_, err = sw.runner().Exec(sw.rebind(`INSERT INTO grants (resource_id, user_id, group_id, data) VALUES (?, ?, ?, ?)
+		ON CONFLICT (resource_id, user_id, group_id) DO UPDATE SET data = excluded.data`),
+		grant.ResourceId, grant.UserId, grant.GroupId, string(data))
+	return err
+}
+
+func (sw *SQLWorker) ListGrants(resourceIds ...string) ([]drive.Grant, error) {
+	if len(resourceIds) == 0 {
+		return []drive.Grant{}, nil
+	}
+
+	args := make([]any, len(resourceIds))
+	for i, id := range resourceIds {
+		args[i] = id
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(args)), ", ")
+	rows, err := sw.runner().Query(sw.rebind(`SELECT data FROM grants WHERE resource_id IN (`+placeholders+`)`), args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	grants := []drive.Grant{}
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+
+		var grant drive.Grant
+		if err := json.Unmarshal([]byte(data), &grant); err != nil {
+			return nil, err
+		}
+
+		grants = append(grants, grant)
+	}
+
+	return grants, rows.Err()
+}
+
+func (sw *SQLWorker) DeleteGrant(resourceId string, userId string, groupId string) error {
+	_, err := sw.runner().Exec(sw.rebind(`DELETE FROM grants WHERE resource_id = ? AND user_id = ? AND group_id = ?`),
+		resourceId, userId, groupId)
+	return err
+}
+
+func (sw *SQLWorker) queryGroups(runner sqlRunner, where string, args ...any) ([]drive.Group, error) {
+	rows, err := runner.Query(sw.rebind(`SELECT data FROM user_groups WHERE `+where), args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	groups := []drive.Group{}
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+
+		var group drive.Group
+		if err := json.Unmarshal([]byte(data), &group); err != nil {
+			return nil, err
+		}
+
+		groups = append(groups, group)
+	}
+
+	return groups, rows.Err()
+}
+
+// saveGroup writes the group row and rebuilds its member rows, which keep
+// ListGroups indexed.
+func (sw *SQLWorker) saveGroup(tx *sql.Tx, group drive.Group, insert bool) error {
+	data, err := json.Marshal(group)
+	if err != nil {
+		return err
+	}
+
+	query := `UPDATE user_groups SET owner_id = ?, data = ? WHERE id = ?`
+	if insert {
+		query = `INSERT INTO user_groups (owner_id, data, id) VALUES (?, ?, ?)`
+	}
+
+	if _, err := tx.Exec(sw.rebind(query), group.OwnerId, string(data), group.Id); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(sw.rebind(`DELETE FROM group_members WHERE group_id = ?`), group.Id); err != nil {
+		return err
+	}
+
+	for _, member := range group.Members {
+		if _, err := tx.Exec(sw.rebind(`INSERT INTO group_members (group_id, user_id) VALUES (?, ?)`), group.Id, member); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (sw *SQLWorker) updateGroup(groupId string, change func(*drive.Group)) error {
+	return sw.inTx(func(tx *sql.Tx) error {
+		groups, err := sw.queryGroups(tx, `id = ?`+sw.forUpdate(), groupId)
+		if err != nil {
+			return err
+		}
+
+		if len(groups) == 0 {
+			return fmt.Errorf("group not found: %s", groupId)
+		}
+
+		change(&groups[0])
+		return sw.saveGroup(tx, groups[0], false)
+	})
+}
+
+func (sw *SQLWorker) CreateGroup(group drive.Group) error {
+	return sw.inTx(func(tx *sql.Tx) error {
+		return sw.saveGroup(tx, group, true)
+	})
+}
+
+func (sw *SQLWorker) GetGroup(id string) (drive.Group, error) {
+	groups, err := sw.queryGroups(sw.runner(), `id = ?`, id)
+	if err != nil {
+		return drive.Group{}, err
+	}
+
+	if len(groups) == 0 {
+		return drive.Group{}, fmt.Errorf("group not found: %s", id)
+	}
+
+	return groups[0], nil
+}
+
+func (sw *SQLWorker) ListGroups(userId string) ([]drive.Group, error) {
+	return sw.queryGroups(sw.runner(), `id IN (SELECT group_id FROM group_members WHERE user_id = ?)`, userId)
+}
+
+func (sw *SQLWorker) AddGroupMember(groupId string, userId string) error {
+	return sw.updateGroup(groupId, func(group *drive.Group) {
+		if !slices.Contains(group.Members, userId) {
+			group.Members = append(group.Members, userId)
+		}
+	})
+}
+
+func (sw *SQLWorker) RemoveGroupMember(groupId string, userId string) error {
+	return sw.updateGroup(groupId, func(group *drive.Group) {
+		group.Members = slices.DeleteFunc(group.Members, func(id string) bool {
+			return id == userId
+		})
+	})
+}
+
+func (sw *SQLWorker) DeleteGroup(id string) error {
+	return sw.inTx(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(sw.rebind(`DELETE FROM group_members WHERE group_id = ?`), id); err != nil {
+			return err
+		}
+
+		_, err := tx.Exec(sw.rebind(`DELETE FROM user_groups WHERE id = ?`), id)
+		return err
+	})
+}
+
+func (sw *SQLWorker) SaveRevocation(revocation drive.Revocation) error {
+	data, err := json.Marshal(revocation)
+	if err != nil {
+		return err
+	}
+
+	_, err = sw.runner().Exec(sw.rebind(`INSERT INTO revocations (jti, user_id, expires_at, data) VALUES (?, ?, ?, ?)
+		ON CONFLICT (jti, user_id) DO UPDATE SET expires_at = excluded.expires_at, data = excluded.data`),
+		revocation.Jti, revocation.UserId, revocation.ExpiresAt.Unix(), string(data))
+	return err
+}
+
+func (sw *SQLWorker) AddRevocation(revocation drive.Revocation) (bool, error) {
+	data, err := json.Marshal(revocation)
+	if err != nil {
+		return false, err
+	}
+
+	result, err := sw.runner().Exec(sw.rebind(`INSERT INTO revocations (jti, user_id, expires_at, data) VALUES (?, ?, ?, ?)
+		ON CONFLICT (jti, user_id) DO NOTHING`),
+		revocation.Jti, revocation.UserId, revocation.ExpiresAt.Unix(), string(data))
+	if err != nil {
+		return false, err
+	}
+
+	added, err := result.RowsAffected()
+	return added > 0, err
+}
+
+func (sw *SQLWorker) ListRevocations(now time.Time) ([]drive.Revocation, error) {
+	rows, err := sw.runner().Query(sw.rebind(`SELECT data FROM revocations WHERE expires_at > ?`), now.Unix())
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	revocations := []drive.Revocation{}
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+
+		var revocation drive.Revocation
+		if err := json.Unmarshal([]byte(data), &revocation); err != nil {
+			return nil, err
+		}
+
+		revocations = append(revocations, revocation)
+	}
+
+	return revocations, rows.Err()
+}
+
+func (sw *SQLWorker) PruneRevocations(now time.Time) error {
+	_, err := sw.runner().Exec(sw.rebind(`DELETE FROM revocations WHERE expires_at <= ?`), now.Unix())
+	return err
+}
+
+func (sw *SQLWorker) queryUser(where string, args ...any) (drive.User, bool, error) {
+	row := sw.runner().QueryRow(sw.rebind(`SELECT id, name, role, password, permissions FROM users WHERE `+where), args...)
+
+	var user drive.User
+	var permissions string
+	err := row.Scan(&user.Id, &user.Name, &user.Role, &user.Password, &permissions)
+	if err == sql.ErrNoRows {
+		return drive.User{}, false, nil
+	}
+
+	if err != nil {
+		return drive.User{}, false, err
+	}
+
+	if err := json.Unmarshal([]byte(permissions), &user.Permissions); err != nil {
+		return drive.User{}, false, err
+	}
+
+	return user, true, nil
+}
+
+func (sw *SQLWorker) GetUserById(userid string) (drive.User, error) {
+	user, ok, err := sw.queryUser(`id = ?`, userid)
+	if err != nil {
+		return drive.User{}, err
+	}
+
+	if !ok {
+		return drive.User{}, fmt.Errorf("userid not found: %s", userid)
+	}
+
+	return user, nil
+}
+
+func (sw *SQLWorker) GetUser(username string, password string) (drive.User, error) {
+	user, ok, err := sw.queryUser(`name = ? AND password = ?`, username, password)
+	if err != nil {
+		return drive.User{}, err
+	}
+
+	if !ok {
+		return drive.User{}, fmt.Errorf("authuser not found: %s", username)
+	}
+
+	return user, nil
+}
+
+func (sw *SQLWorker) Start() error {
+	err := sw.db.Ping()
+	if err != nil {
+		return err
+	}
+
+	return sw.migrate()
+}
@@ -0,0 +1,86 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/c4me-caro/drive"
+)
+
+func TestAddResourceChildrenOfMissingParent(t *testing.T) {
+	for name, store := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			err := store.AddResourceChildren(drive.Resource{Id: "missing", Name: "docs"}, "child")
+			if !errors.Is(err, ErrResourceNotFound) {
+				t.Fatalf("err = %v, want ErrResourceNotFound", err)
+			}
+		})
+	}
+}
+
+// TestConcurrentUpdates changes one folder and one group from two
+// connections to the same database at once. Every change has to survive.
+func TestConcurrentUpdates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "drive.db")
+
+	var workers []*SQLWorker
+	for range 2 {
+		sw, err := NewSQLWorker("sqlite", path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		t.Cleanup(func() { sw.db.Close() })
+		if err := sw.Start(); err != nil {
+			t.Fatal(err)
+		}
+
+		workers = append(workers, sw)
+	}
+
+	folder := drive.Resource{Id: "f", Name: "docs", OwnerId: "u1", Type: "folder", Content: []string{}}
+	if err := workers[0].CreateResource(folder); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := workers[0].CreateGroup(drive.Group{Id: "g", Name: "team", OwnerId: "u1", Members: []string{}}); err != nil {
+		t.Fatal(err)
+	}
+
+	const perWorker = 20
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 2*len(workers)*perWorker)
+	for w, sw := range workers {
+		for i := range perWorker {
+			id := fmt.Sprintf("%d-%d", w, i)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				errs <- sw.AddResourceChildren(folder, id)
+				errs <- sw.AddGroupMember("g", id)
+			}()
+		}
+	}
+
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stored, err := workers[1].GetResource("f")
+	if err != nil || len(stored.Content) != len(workers)*perWorker {
+		t.Fatalf("folder holds %d children, %v, want %d", len(stored.Content), err, len(workers)*perWorker)
+	}
+
+	group, err := workers[1].GetGroup("g")
+	if err != nil || len(group.Members) != len(workers)*perWorker {
+		t.Fatalf("group holds %d members, %v, want %d", len(group.Members), err, len(workers)*perWorker)
+	}
+}
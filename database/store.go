@@ -0,0 +1,124 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/c4me-caro/drive"
+)
+
+var ErrResourceNotFound = errors.New("resource not found")
+
+// ErrNameTaken is returned when a write would give a folder two live
+// resources of the same name.
+var ErrNameTaken = errors.New("name already exists in folder")
+
+type ResourceStore interface {
+	AddResourceChildren(resource drive.Resource, children string) error
+	RemoveResourceChildren(resource drive.Resource, children string) error
+	GetParent(children string) (drive.Resource, error)
+	// GetChild finds the live resource called name in parent. Each owner
+	// has a top level of their own, where parent is empty.
+	GetChild(parent string, owner string, name string) (drive.Resource, error)
+	CheckResource(resource drive.Resource) error
+	GetResource(search string) (drive.Resource, error)
+	CreateResource(resource drive.Resource) error
+	UpdateResource(resource drive.Resource) error
+	// DeleteResource fails with ErrResourceNotFound when nothing was
+	// deleted, so callers can tell a resource removed concurrently.
+	DeleteResource(resource drive.Resource) error
+	ListTrash(userid string) ([]drive.Resource, error)
+	ListTrashTree(root string) ([]drive.Resource, error)
+	ListExpiredTrash(before time.Time) ([]drive.Resource, error)
+	ListResources() ([]drive.Resource, error)
+	CreateUpload(upload drive.Upload) error
+	GetUpload(id string) (drive.Upload, error)
+	UpdateUploadOffset(id string, offset int64) error
+	DeleteUpload(id string) error
+	ListUploads() ([]drive.Upload, error)
+	AcquireBlob(blob drive.Blob) (drive.Blob, error)
+	ReleaseBlob(hash string) (int, error)
+	GetBlob(hash string) (drive.Blob, error)
+	SetBlobKey(blob drive.Blob) error
+	ListBlobs() ([]drive.Blob, error)
+	Transaction(fn func(ResourceStore) error) error
+}
+
+type UserStore interface {
+	GetUserById(userid string) (drive.User, error)
+	GetUser(username string, password string) (drive.User, error)
+}
+
+// LinkStore keeps share links. UseShareLink counts one download and fails
+// once the link reached its download limit.
+type LinkStore interface {
+	CreateShareLink(link drive.ShareLink) error
+	GetShareLink(tokenHash string) (drive.ShareLink, error)
+	ListShareLinks(ownerId string) ([]drive.ShareLink, error)
+	DeleteShareLink(id string) error
+	UseShareLink(id string) error
+}
+
+// GrantStore keeps the roles users and groups were given on resources.
+// SaveGrant replaces the grant the user or group already has on the
+// resource, and ListGrants returns the grants on any of the given
+// resources.
+type GrantStore interface {
+	SaveGrant(grant drive.Grant) error
+	ListGrants(resourceIds ...string) ([]drive.Grant, error)
+	DeleteGrant(resourceId string, userId string, groupId string) error
+}
+
+// GroupStore keeps groups and their members. ListGroups returns the groups
+// a user is a member of.
+type GroupStore interface {
+	CreateGroup(group drive.Group) error
+	GetGroup(id string) (drive.Group, error)
+	ListGroups(userId string) ([]drive.Group, error)
+	AddGroupMember(groupId string, userId string) error
+	RemoveGroupMember(groupId string, userId string) error
+	DeleteGroup(id string) error
+}
+
+// RevocationStore keeps revoked tokens. SaveRevocation replaces the
+// revocation with the same Jti and UserId, AddRevocation only saves it
+// when there is none yet and tells whether it did, ListRevocations returns
+// those not expired at now, and PruneRevocations deletes the others.
+type RevocationStore interface {
+	SaveRevocation(revocation drive.Revocation) error
+	AddRevocation(revocation drive.Revocation) (bool, error)
+	ListRevocations(now time.Time) ([]drive.Revocation, error)
+	PruneRevocations(now time.Time) error
+}
+
+// PermissionChange tells which cached groups and grants a write to the
+// store made stale. All is set when the write does not tell, like a
+// deletion.
+type PermissionChange struct {
+	Users     []string
+	Resources []string
+	All       bool
+}
+
+// PermissionWatcher is implemented by stores shared between instances. It
+// calls notify for every write to groups and grants until ctx is done or
+// the watch fails.
+type PermissionWatcher interface {
+	WatchPermissions(ctx context.Context, notify func(PermissionChange)) error
+}
+
+type Store interface {
+	ResourceStore
+	UserStore
+	LinkStore
+	GrantStore
+	GroupStore
+	RevocationStore
+	Start() error
+}
+
+var _ Store = (*DriveWorker)(nil)
+var _ Store = (*MemoryStore)(nil)
+var _ Store = (*SQLWorker)(nil)
+var _ PermissionWatcher = (*DriveWorker)(nil)
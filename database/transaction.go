@@ -0,0 +1,184 @@
+package database
+
+import (
+	"github.com/c4me-caro/drive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Transaction runs fn as one unit. Replica sets and sharded clusters get a
+// multi-document transaction; standalone servers, which cannot run one,
+// get the same steps undone in reverse order when fn fails.
+func (cfw *DriveWorker) Transaction(fn func(ResourceStore) error) error {
+	if cfw.session != nil {
+		return fn(cfw)
+	}
+
+	if !cfw.transactions {
+		return cfw.compensate(fn)
+	}
+
+	session, err := cfw.client.StartSession()
+	if err != nil {
+		return err
+	}
+
+	defer session.EndSession(cfw.ctx())
+
+	_, err = session.WithTransaction(cfw.ctx(), func(sc mongo.SessionContext) (interface{}, error) {
+		return nil, fn(&DriveWorker{client: cfw.client, db: cfw.db, session: sc, transactions: true})
+	})
+
+	return err
+}
+
+func (cfw *DriveWorker) compensate(fn func(ResourceStore) error) error {
+	worker := &compensatingWorker{DriveWorker: cfw}
+	err := fn(worker)
+	if err != nil {
+		for i := len(worker.undo) - 1; i >= 0; i-- {
+			worker.undo[i]()
+		}
+	}
+
+	return err
+}
+
+// compensatingWorker records how to revert every write it performs.
+type compensatingWorker struct {
+	*DriveWorker
+	undo []func() error
+}
+
+func (cw *compensatingWorker) Transaction(fn func(ResourceStore) error) error {
+	return fn(cw)
+}
+
+func (cw *compensatingWorker) AddResourceChildren(resource drive.Resource, children string) error {
+	err := cw.DriveWorker.AddResourceChildren(resource, children)
+	if err == nil {
+		cw.undo = append(cw.undo, func() error {
+			return cw.DriveWorker.RemoveResourceChildren(resource, children)
+		})
+	}
+
+	return err
+}
+
+func (cw *compensatingWorker) RemoveResourceChildren(resource drive.Resource, children string) error {
+	previous, perr := cw.DriveWorker.GetResource(resource.Id)
+	err := cw.DriveWorker.RemoveResourceChildren(resource, children)
+	if err == nil && perr == nil {
+		cw.undo = append(cw.undo, func() error {
+			return cw.DriveWorker.UpdateResource(previous)
+		})
+	}
+
+	return err
+}
+
+func (cw *compensatingWorker) CreateResource(resource drive.Resource) error {
+	err := cw.DriveWorker.CreateResource(resource)
+	if err == nil {
+		cw.undo = append(cw.undo, func() error {
+			return cw.DriveWorker.DeleteResource(resource)
+		})
+	}
+
+	return err
+}
+
+func (cw *compensatingWorker) UpdateResource(resource drive.Resource) error {
+	previous, perr := cw.DriveWorker.GetResource(resource.Id)
+	err := cw.DriveWorker.UpdateResource(resource)
+	if err == nil && perr == nil {
+		cw.undo = append(cw.undo, func() error {
+			return cw.DriveWorker.UpdateResource(previous)
+		})
+	}
+
+	return err
+}
+
+func (cw *compensatingWorker) DeleteResource(resource drive.Resource) error {
+	previous, perr := cw.DriveWorker.GetResource(resource.Id)
+	err := cw.DriveWorker.DeleteResource(resource)
+	if err == nil && perr == nil {
+		cw.undo = append(cw.undo, func() error {
+			return cw.DriveWorker.CreateResource(previous)
+		})
+	}
+
+	return err
+}
+
+func (cw *compensatingWorker) CreateUpload(upload drive.Upload) error {
+	err := cw.DriveWorker.CreateUpload(upload)
+	if err == nil {
+		cw.undo = append(cw.undo, func() error {
+			return cw.DriveWorker.DeleteUpload(upload.Id)
+		})
+	}
+
+	return err
+}
+
+func (cw *compensatingWorker) UpdateUploadOffset(id string, offset int64) error {
+	previous, perr := cw.DriveWorker.GetUpload(id)
+	err := cw.DriveWorker.UpdateUploadOffset(id, offset)
+	if err == nil && perr == nil {
+		cw.undo = append(cw.undo, func() error {
+			return cw.DriveWorker.UpdateUploadOffset(id, previous.Offset)
+		})
+	}
+
+	return err
+}
+
+func (cw *compensatingWorker) DeleteUpload(id string) error {
+	previous, perr := cw.DriveWorker.GetUpload(id)
+	err := cw.DriveWorker.DeleteUpload(id)
+	if err == nil && perr == nil {
+		cw.undo = append(cw.undo, func() error {
+			return cw.DriveWorker.CreateUpload(previous)
+		})
+	}
+
+	return err
+}
+
+func (cw *compensatingWorker) AcquireBlob(blob drive.Blob) (drive.Blob, error) {
+	stored, err := cw.DriveWorker.AcquireBlob(blob)
+	if err == nil {
+		cw.undo = append(cw.undo, func() error {
+			_, err := cw.DriveWorker.ReleaseBlob(blob.Hash)
+			return err
+		})
+	}
+
+	return stored, err
+}
+
+func (cw *compensatingWorker) ReleaseBlob(hash string) (int, error) {
+	previous, perr := cw.DriveWorker.GetBlob(hash)
+	refs, err := cw.DriveWorker.ReleaseBlob(hash)
+	if err == nil && perr == nil {
+		cw.undo = append(cw.undo, func() error {
+			_, err := cw.DriveWorker.AcquireBlob(previous)
+			return err
+		})
+	}
+
+	return refs, err
+}
+
+func (cw *compensatingWorker) SetBlobKey(blob drive.Blob) error {
+	previous, perr := cw.DriveWorker.GetBlob(blob.Hash)
+	err := cw.DriveWorker.SetBlobKey(blob)
+	if err == nil && perr == nil {
+		cw.undo = append(cw.undo, func() error {
+			return cw.DriveWorker.SetBlobKey(previous)
+		})
+	}
+
+	return err
+}
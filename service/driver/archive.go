@@ -0,0 +1,221 @@
+package driver
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/c4me-caro/drive"
+	"github.com/c4me-caro/drive/cmd/auth"
+	"github.com/gorilla/mux"
+)
+
+func (h Handler) registerArchiveRoutes(router *mux.Router) {
+	router.HandleFunc("/d/{folder}/archive", h.handleArchive).Methods("GET")
+}
+
+// archiveWriter adds entries to an archive streamed to the client.
+type archiveWriter interface {
+	addFolder(name string, modTime time.Time) error
+	addFile(name string, size int64, modTime time.Time, r io.Reader) error
+	Close() error
+}
+
+type zipArchive struct {
+	zw *zip.Writer
+}
+
+func (za *zipArchive) addFolder(name string, modTime time.Time) error {
+	_, err := za.zw.CreateHeader(&zip.FileHeader{Name: name + "/", Modified: modTime})
+	return err
+}
+
+func (za *zipArchive) addFile(name string, size int64, modTime time.Time, r io.Reader) error {
+	entry, err := za.zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: modTime})
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(entry, r)
+	return err
+}
+
+func (za *zipArchive) Close() error {
+	return za.zw.Close()
+}
+
+type tarArchive struct {
+	gz *gzip.Writer
+	tw *tar.Writer
+}
+
+func (ta *tarArchive) addFolder(name string, modTime time.Time) error {
+	return ta.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeDir,
+		Name:     name + "/",
+		Mode:     0755,
+		ModTime:  modTime,
+	})
+}
+
+func (ta *tarArchive) addFile(name string, size int64, modTime time.Time, r io.Reader) error {
+	err := ta.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     0644,
+		Size:     size,
+		ModTime:  modTime,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = io.CopyN(ta.tw, r, size)
+	return err
+}
+
+func (ta *tarArchive) Close() error {
+	if err := ta.tw.Close(); err != nil {
+		return err
+	}
+
+	return ta.gz.Close()
+}
+
+// entryName keeps resource names from escaping their folder in the
+// archive.
+func entryName(prefix string, name string) string {
+	name = strings.NewReplacer("/", "_", "\\", "_").Replace(name)
+	if name == "." || name == ".." || name == "" {
+		name = "_"
+	}
+
+	return path.Join(prefix, name)
+}
+
+// writeArchive adds the content of folder below prefix, skipping trashed
+// resources and those user cannot read.
+func (h Handler) writeArchive(archive archiveWriter, folder drive.Resource, prefix string, user drive.User, seen map[string]struct{}) error {
+	for _, id := range folder.Content {
+		if _, ok := seen[id]; ok {
+			continue
+		}
+
+		child, err := h.db.GetResource(id)
+		if err != nil || child.Trashed {
+			continue
+		}
+
+		if auth.FindPermission(user, "read", child) == "" {
+			continue
+		}
+
+		seen[id] = struct{}{}
+		name := entryName(prefix, child.Name)
+
+		if child.Type == "folder" {
+			if err := archive.addFolder(name, child.ModTime); err != nil {
+				return err
+			}
+
+			if err := h.writeArchive(archive, child, name, user, seen); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if err := h.writeArchiveFile(archive, child, name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (h Handler) writeArchiveFile(archive archiveWriter, file drive.Resource, name string) error {
+	object, err := h.openObject(file.Location)
+	if err != nil {
+		return err
+	}
+
+	defer object.Close()
+
+	size, err := object.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+
+	if _, err := object.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	modTime := file.ModTime
+	if modTime.IsZero() {
+		modTime = time.Now()
+	}
+
+	return archive.addFile(name, size, modTime, object)
+}
+
+func (h Handler) handleArchive(w http.ResponseWriter, r *http.Request) {
+	user, err := h.validateAuthentication(r, "read")
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		io.WriteString(w, "Error: User not found or does not has valid permissions: " + err.Error())
+		return
+	}
+
+	resource, err := h.checkResource(mux.Vars(r)["folder"], user, "read")
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		io.WriteString(w, "Error: Resource not found or user has no valid permissions: " + err.Error())
+		return
+	}
+
+	if resource.Type != "folder" {
+		w.WriteHeader(http.StatusConflict)
+		io.WriteString(w, "Error: resource is not a folder")
+		return
+	}
+
+	var archive archiveWriter
+	format := r.URL.Query().Get("format")
+	switch format {
+	case "", "zip":
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", "attachment; filename="+resource.Name+".zip")
+		archive = &zipArchive{zw: zip.NewWriter(w)}
+	case "tar.gz":
+		w.Header().Set("Content-Type", "application/gzip")
+		w.Header().Set("Content-Disposition", "attachment; filename="+resource.Name+".tar.gz")
+		gz := gzip.NewWriter(w)
+		archive = &tarArchive{gz: gz, tw: tar.NewWriter(gz)}
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		io.WriteString(w, "Error: Unknown archive format: " + format)
+		return
+	}
+
+	// The response is already under way once the first entry is written,
+	// so a failure can only cut the archive short.
+	root := entryName("", resource.Name)
+	seen := map[string]struct{}{resource.Id: {}}
+	err = archive.addFolder(root, resource.ModTime)
+	if err == nil {
+		err = h.writeArchive(archive, resource, root, user, seen)
+	}
+
+	if err != nil {
+		fmt.Println("archive failed:", resource.Id, err)
+		return
+	}
+
+	archive.Close()
+}
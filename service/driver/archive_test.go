@@ -0,0 +1,106 @@
+package driver
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"slices"
+	"testing"
+)
+
+func zipEntries(t *testing.T, body []byte) map[string]string {
+	t.Helper()
+
+	reader, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries := make(map[string]string)
+	for _, file := range reader.File {
+		content, err := file.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		data, _ := io.ReadAll(content)
+		content.Close()
+		entries[file.Name] = string(data)
+	}
+
+	return entries
+}
+
+func TestArchive(t *testing.T) {
+	ts := newTestServer(t)
+	docs := ts.mkdir("bob", "", "docs")
+	reports := ts.mkdir("bob", docs.Id, "reports")
+	ts.file("bob", docs.Id, "a.txt", "hello")
+	ts.file("bob", reports.Id, "b.txt", "world")
+	trashed := ts.file("bob", docs.Id, "gone.txt", "gone")
+	expectStatus(t, ts.do("bob", "GET", "/drive/r/"+trashed.Id, ""), http.StatusOK)
+
+	w := ts.do("bob", "GET", "/drive/d/"+docs.Id+"/archive", "")
+	expectStatus(t, w, http.StatusOK)
+
+	entries := zipEntries(t, w.Body.Bytes())
+	if entries["docs/a.txt"] != "hello" || entries["docs/reports/b.txt"] != "world" {
+		t.Fatalf("entries = %v", entries)
+	}
+
+	if _, ok := entries["docs/gone.txt"]; ok {
+		t.Fatal("trashed file was archived")
+	}
+
+	w = ts.do("bob", "GET", "/drive/d/"+docs.Id+"/archive?format=tar.gz", "")
+	expectStatus(t, w, http.StatusOK)
+
+	gz, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := []string{}
+	for reader := tar.NewReader(gz); ; {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		names = append(names, header.Name)
+	}
+
+	if !slices.Contains(names, "docs/reports/b.txt") {
+		t.Fatalf("tar entries = %v", names)
+	}
+
+	file := ts.resource("a.txt")
+	expectStatus(t, ts.do("bob", "GET", "/drive/d/"+docs.Id+"/archive?format=rar", ""), http.StatusBadRequest)
+	expectStatus(t, ts.do("bob", "GET", "/drive/d/"+file.Id+"/archive", ""), http.StatusConflict)
+	expectStatus(t, ts.do("carol", "GET", "/drive/d/"+docs.Id+"/archive", ""), http.StatusUnauthorized)
+}
+
+func TestArchiveSkipsUnreadable(t *testing.T) {
+	ts := newTestServer(t)
+	team := ts.mkdir("alice", "", "team")
+	expectStatus(t, ts.do("alice", "POST", "/drive/shares/"+team.Id, `{"user":"u2","role":"viewer"}`), http.StatusOK)
+
+	ts.file("alice", team.Id, "open.txt", "open")
+	secret := ts.file("alice", team.Id, "secret.txt", "secret")
+	expectStatus(t, ts.do("alice", "POST", "/drive/inheritance/"+secret.Id, `{"inherit":false}`), http.StatusOK)
+
+	w := ts.do("bob", "GET", "/drive/d/"+team.Id+"/archive", "")
+	expectStatus(t, w, http.StatusOK)
+
+	entries := zipEntries(t, w.Body.Bytes())
+	if _, ok := entries["team/secret.txt"]; ok || entries["team/open.txt"] != "open" {
+		t.Fatalf("entries = %v", entries)
+	}
+}
@@ -0,0 +1,77 @@
+package driver
+
+import (
+	"github.com/c4me-caro/drive"
+	"github.com/c4me-caro/drive/database"
+	"github.com/c4me-caro/drive/storage"
+)
+
+// storeBlob counts a new reference to the staged content at temp from
+// within tx and returns the stored blob, whose key id the referencing file
+// should record. The first reference moves the staged bytes into place;
+// later ones find the bytes already stored and leave temp to be discarded.
+func (h Handler) storeBlob(tx database.ResourceStore, temp string, blob drive.Blob) (drive.Blob, error) {
+	stored, err := tx.AcquireBlob(blob)
+	if err != nil {
+		return drive.Blob{}, err
+	}
+
+	key := storage.BlobKey(blob.Hash)
+	if stored.Refs > 1 {
+		if _, err := h.store.Stat(key); err == nil {
+			return stored, nil
+		}
+
+		// The bytes went missing, so the staged copy replaces them along
+		// with the data key it was encrypted under.
+		stored.KeyId = blob.KeyId
+		stored.DataKey = blob.DataKey
+		if err := tx.SetBlobKey(stored); err != nil {
+			return drive.Blob{}, err
+		}
+	}
+
+	return stored, h.commitObject(temp, key)
+}
+
+// blobLocations returns the distinct locations used by versions.
+func blobLocations(versions []drive.Version) []string {
+	seen := make(map[string]struct{})
+	locations := []string{}
+	for _, version := range versions {
+		if _, ok := seen[version.Location]; ok {
+			continue
+		}
+
+		seen[version.Location] = struct{}{}
+		locations = append(locations, version.Location)
+	}
+
+	return locations
+}
+
+// releaseBlobs drops the references held on locations from within tx and
+// returns the keys whose bytes can be deleted once tx commits. Locations
+// written before deduplication belong to a single resource and are always
+// returned.
+func releaseBlobs(tx database.ResourceStore, locations []string) ([]string, error) {
+	unused := []string{}
+	for _, location := range locations {
+		hash, ok := storage.BlobHash(location)
+		if !ok {
+			unused = append(unused, location)
+			continue
+		}
+
+		refs, err := tx.ReleaseBlob(hash)
+		if err != nil {
+			return nil, err
+		}
+
+		if refs == 0 {
+			unused = append(unused, location)
+		}
+	}
+
+	return unused, nil
+}
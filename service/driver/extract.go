@@ -0,0 +1,479 @@
+package driver
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/c4me-caro/drive"
+	"github.com/c4me-caro/drive/cmd/auth"
+	"github.com/c4me-caro/drive/database"
+	"github.com/c4me-caro/drive/storage"
+	"github.com/google/uuid"
+)
+
+const defaultMaxArchiveEntries = 10000
+
+var errBadArchive = fmt.Errorf("invalid archive")
+var errTooManyEntries = fmt.Errorf("archive exceeds maximum entry count")
+
+func maxArchiveEntries() int {
+	entries, err := strconv.Atoi(os.Getenv("EXTRACT_MAX_ENTRIES"))
+	if err != nil || entries <= 0 {
+		return defaultMaxArchiveEntries
+	}
+
+	return entries
+}
+
+// maxExtractedSize bounds the total bytes unpacked from one archive, which
+// keeps small, highly compressed archives from filling the storage.
+func maxExtractedSize() int64 {
+	size, err := strconv.ParseInt(os.Getenv("EXTRACT_MAX_SIZE"), 10, 64)
+	if err != nil || size <= 0 {
+		return maxObjectSize()
+	}
+
+	return size
+}
+
+type extractedResource struct {
+	Id   string `json:"id"`
+	Path string `json:"path"`
+	Type string `json:"type"`
+	Size int64  `json:"size"`
+}
+
+type extractSummary struct {
+	Parent    string              `json:"parent"`
+	Folders   int                 `json:"folders"`
+	Files     int                 `json:"files"`
+	Size      int64               `json:"size"`
+	Resources []extractedResource `json:"resources"`
+	Skipped   []string            `json:"skipped"`
+}
+
+// cleanEntryName turns an archive entry name into a slash separated path
+// relative to the folder extracted into, rejecting names that would escape
+// it.
+func cleanEntryName(name string) (string, error) {
+	name = strings.ReplaceAll(name, "\\", "/")
+	if strings.HasPrefix(name, "/") || (len(name) > 1 && name[1] == ':') {
+		return "", fmt.Errorf("%w: absolute path %s", errBadArchive, name)
+	}
+
+	clean := path.Clean(name)
+	if clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", fmt.Errorf("%w: path escapes the folder %s", errBadArchive, name)
+	}
+
+	return clean, nil
+}
+
+// entryReader marks read errors of archive content as a bad archive, so
+// they are told apart from storage failures.
+type entryReader struct {
+	r io.Reader
+}
+
+func (er entryReader) Read(p []byte) (int, error) {
+	n, err := er.r.Read(p)
+	if err != nil && err != io.EOF && !errors.Is(err, errTooLarge) {
+		err = fmt.Errorf("%w: %v", errBadArchive, err)
+	}
+
+	return n, err
+}
+
+// extraction plans the resources of an archive below parent. Every file is
+// staged as it is read and nothing is saved until the whole archive was
+// accepted.
+type extraction struct {
+	h         Handler
+	user      drive.User
+	parent    drive.Resource
+	plan      treePlan
+	paths     []string
+	indexes   map[string]int
+	roots     []int
+	entries   int
+	remaining int64
+	skipped   []string
+}
+
+func (h Handler) newExtraction(parent drive.Resource, user drive.User) *extraction {
+	return &extraction{
+		h:         h,
+		user:      user,
+		parent:    parent,
+		indexes:   make(map[string]int),
+		remaining: h.maxExtracted,
+		skipped:   []string{},
+	}
+}
+
+// add plans body at name inside the planned folder at index parent, where
+// -1 stands for the folder extracted into.
+func (ex *extraction) add(parent int, name string, body drive.Resource) int {
+	holder := ex.parent
+	if parent >= 0 {
+		holder = ex.plan.resources[parent]
+	}
+
+	body.Id = uuid.New().String()
+	body.Name = path.Base(name)
+	body.OwnerId = ex.user.Id
+	body.SharedId = []string{}
+	body.Content = []string{}
+	body.Parent = holder.Id
+	body.Ancestors = auth.Ancestors(body, holder)
+	if body.Type == "folder" {
+		body.Location = holder.Name
+	}
+
+	ex.plan.resources = append(ex.plan.resources, body)
+	ex.paths = append(ex.paths, name)
+	index := len(ex.plan.resources) - 1
+	ex.indexes[name] = index
+
+	if parent >= 0 {
+		ex.plan.resources[parent].Content = append(ex.plan.resources[parent].Content, body.Id)
+	} else {
+		ex.roots = append(ex.roots, index)
+	}
+
+	return index
+}
+
+// folder returns the index of the planned folder at name, planning it and
+// its missing ancestors, since archives may list files without their
+// folders.
+func (ex *extraction) folder(name string) (int, error) {
+	if name == "." {
+		return -1, nil
+	}
+
+	if index, ok := ex.indexes[name]; ok {
+		if ex.plan.resources[index].Type != "folder" {
+			return 0, fmt.Errorf("%w: %s is both a file and a folder", errBadArchive, name)
+		}
+
+		return index, nil
+	}
+
+	parent, err := ex.folder(path.Dir(name))
+	if err != nil {
+		return 0, err
+	}
+
+	return ex.add(parent, name, drive.Resource{Type: "folder"}), nil
+}
+
+func (ex *extraction) file(name string, r io.Reader) error {
+	if _, ok := ex.indexes[name]; ok {
+		return fmt.Errorf("%w: duplicate entry %s", errBadArchive, name)
+	}
+
+	parent, err := ex.folder(path.Dir(name))
+	if err != nil {
+		return err
+	}
+
+	temp, size, blob, err := ex.h.stageObject(&limitedReader{r: entryReader{r}, remaining: ex.remaining})
+	if err != nil {
+		return err
+	}
+
+	ex.remaining -= size
+	ex.plan.blobs = append(ex.plan.blobs, plannedBlob{temp: temp, blob: blob})
+
+	body := drive.Resource{
+		Type:     "file",
+		Location: storage.BlobKey(blob.Hash),
+		Size:     size,
+		ModTime:  time.Now().UTC(),
+		Hash:     blob.Hash,
+		Version:  1,
+	}
+
+	body.Versions = []drive.Version{{
+		Number:   1,
+		Location: body.Location,
+		Size:     size,
+		Hash:     blob.Hash,
+		AuthorId: ex.user.Id,
+		ModTime:  body.ModTime,
+	}}
+
+	ex.add(parent, name, body)
+	return nil
+}
+
+// entry plans one archive entry. Links, devices and other special entries
+// are skipped and reported.
+func (ex *extraction) entry(name string, mode fs.FileMode, r io.Reader) error {
+	ex.entries++
+	if ex.entries > ex.h.maxEntries {
+		return errTooManyEntries
+	}
+
+	clean, err := cleanEntryName(name)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case clean == ".":
+		return nil
+	case mode.IsDir():
+		_, err := ex.folder(clean)
+		return err
+	case mode.IsRegular():
+		return ex.file(clean, r)
+	}
+
+	ex.skipped = append(ex.skipped, clean)
+	return nil
+}
+
+func (ex *extraction) readZip(archive *os.File, size int64) error {
+	zr, err := zip.NewReader(archive, size)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errBadArchive, err)
+	}
+
+	if len(zr.File) > ex.h.maxEntries {
+		return errTooManyEntries
+	}
+
+	for _, f := range zr.File {
+		if !f.Mode().IsRegular() {
+			if err := ex.entry(f.Name, f.Mode(), nil); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		content, err := f.Open()
+		if err != nil {
+			return fmt.Errorf("%w: %v", errBadArchive, err)
+		}
+
+		err = ex.entry(f.Name, f.Mode(), content)
+		content.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (ex *extraction) readTar(r io.Reader) error {
+	// Headers and padding take at most a kilobyte per entry, so a stream
+	// longer than that is rejected before it is fully decompressed.
+	stream := &limitedReader{r: r, remaining: ex.h.maxExtracted + int64(ex.h.maxEntries)<<10}
+	tr := tar.NewReader(stream)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+
+		if errors.Is(err, errTooLarge) {
+			return err
+		}
+
+		if err != nil {
+			return fmt.Errorf("%w: %v", errBadArchive, err)
+		}
+
+		mode := fs.ModeIrregular
+		switch header.Typeflag {
+		case tar.TypeDir:
+			mode = fs.ModeDir
+		case tar.TypeReg:
+			mode = 0
+		case tar.TypeXGlobalHeader:
+			continue
+		}
+
+		if err := ex.entry(header.Name, mode, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// read detects the format of the spooled archive from its first bytes and
+// plans its entries.
+func (ex *extraction) read(archive *os.File, size int64) error {
+	head := make([]byte, 512)
+	n, _ := archive.ReadAt(head, 0)
+	head = head[:n]
+
+	switch {
+	case bytes.HasPrefix(head, []byte("PK\x03\x04")) || bytes.HasPrefix(head, []byte("PK\x05\x06")):
+		return ex.readZip(archive, size)
+	case bytes.HasPrefix(head, []byte{0x1f, 0x8b}):
+		gz, err := gzip.NewReader(io.NewSectionReader(archive, 0, size))
+		if err != nil {
+			return fmt.Errorf("%w: %v", errBadArchive, err)
+		}
+
+		defer gz.Close()
+		return ex.readTar(gz)
+	case len(head) >= 262 && string(head[257:262]) == "ustar":
+		return ex.readTar(io.NewSectionReader(archive, 0, size))
+	}
+
+	return fmt.Errorf("%w: expected zip, tar or tar.gz", errBadArchive)
+}
+
+func (ex *extraction) summary() extractSummary {
+	summary := extractSummary{
+		Parent:    ex.parent.Id,
+		Resources: []extractedResource{},
+		Skipped:   ex.skipped,
+	}
+
+	for i, resource := range ex.plan.resources {
+		if resource.Type == "folder" {
+			summary.Folders++
+		} else {
+			summary.Files++
+			summary.Size += resource.Size
+		}
+
+		summary.Resources = append(summary.Resources, extractedResource{
+			Id:   resource.Id,
+			Path: ex.paths[i],
+			Type: resource.Type,
+			Size: resource.Size,
+		})
+	}
+
+	sort.Slice(summary.Resources, func(i, j int) bool {
+		return summary.Resources[i].Path < summary.Resources[j].Path
+	})
+
+	return summary
+}
+
+// spoolArchive copies the uploaded archive to a local temporary file, since
+// zip archives can only be read with random access.
+func (h Handler) spoolArchive(r io.Reader) (*os.File, int64, error) {
+	spool, err := os.CreateTemp("", "drive-extract-*")
+	if err != nil {
+		return nil, 0, err
+	}
+
+	os.Remove(spool.Name())
+
+	size, err := io.Copy(spool, h.limitReader(r))
+	if err != nil {
+		spool.Close()
+		return nil, 0, err
+	}
+
+	return spool, size, nil
+}
+
+// extractArchive unpacks the uploaded zip, tar or tar.gz archive into
+// parent, creating the whole tree in a single transaction.
+func (h Handler) extractArchive(w http.ResponseWriter, r io.Reader, parent drive.Resource, user drive.User) {
+	spool, size, err := h.spoolArchive(r)
+	if errors.Is(err, errTooLarge) {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		io.WriteString(w, "Error: File exceeds maximum size")
+		return
+	}
+
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(w, "Error: Writing file")
+		return
+	}
+
+	defer spool.Close()
+
+	ex := h.newExtraction(parent, user)
+	err = ex.read(spool, size)
+	if err != nil {
+		ex.plan.discard(h)
+
+		switch {
+		case errors.Is(err, errTooLarge):
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			io.WriteString(w, "Error: Archive content exceeds maximum size")
+		case errors.Is(err, errTooManyEntries):
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			io.WriteString(w, "Error: Archive has too many entries")
+		case errors.Is(err, errBadArchive):
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, "Error: "+err.Error())
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+			io.WriteString(w, "Error: Writing file")
+		}
+
+		return
+	}
+
+	for _, index := range ex.roots {
+		if h.nameTaken(parent.Id, user.Id, ex.plan.resources[index].Name, "") {
+			ex.plan.discard(h)
+			w.WriteHeader(http.StatusConflict)
+			io.WriteString(w, "Error: Name already exists in folder: "+ex.plan.resources[index].Name)
+			return
+		}
+	}
+
+	err = h.db.Transaction(func(tx database.ResourceStore) error {
+		if err := ex.plan.save(h, tx); err != nil {
+			return err
+		}
+
+		if parent.Id == "" {
+			return nil
+		}
+
+		for _, index := range ex.roots {
+			if err := tx.AddResourceChildren(parent, ex.plan.resources[index].Id); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	ex.plan.discard(h)
+	if err != nil {
+		if nameConflict(w, err) {
+			return
+		}
+
+		w.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(w, "Error: Failed resource creation")
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(ex.summary()); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(w, "Error: bad json coding")
+		return
+	}
+}
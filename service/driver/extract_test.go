@@ -0,0 +1,79 @@
+package driver
+
+import (
+	"archive/zip"
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// zipArchiveOf builds a zip holding files, named by their paths.
+func zipArchiveOf(t *testing.T, files map[string]string) string {
+	t.Helper()
+
+	var body bytes.Buffer
+	archive := zip.NewWriter(&body)
+	for name, content := range files {
+		entry, err := archive.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		entry.Write([]byte(content))
+	}
+
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return body.String()
+}
+
+func (ts *testServer) extract(user string, parent string, archive string) *httptest.ResponseRecorder {
+	return ts.serve(ts.uploadRequest(user, "/drive/upload/"+parent+"?extract=true", "upload.zip", archive))
+}
+
+func TestExtract(t *testing.T) {
+	ts := newTestServer(t)
+	docs := ts.mkdir("bob", "", "docs")
+
+	w := ts.extract("bob", docs.Id, zipArchiveOf(t, map[string]string{
+		"reports/a.txt":    "hello",
+		"reports/q1/b.txt": "world",
+		"c.txt":            "top",
+	}))
+
+	expectStatus(t, w, http.StatusOK)
+	summary := decode[extractSummary](t, w)
+	if summary.Folders != 2 || summary.Files != 3 {
+		t.Fatalf("summary = %+v", summary)
+	}
+
+	w = ts.do("bob", "GET", "/drive/p/docs/reports/q1/b.txt", "")
+	expectStatus(t, w, http.StatusOK)
+	expectBody(t, w, "world")
+
+	expectStatus(t, ts.extract("bob", docs.Id, zipArchiveOf(t, map[string]string{"c.txt": "again"})), http.StatusConflict)
+	expectStatus(t, ts.extract("bob", docs.Id, "not an archive"), http.StatusBadRequest)
+	expectStatus(t, ts.extract("carol", docs.Id, zipArchiveOf(t, map[string]string{"d.txt": "x"})), http.StatusUnauthorized)
+}
+
+func TestExtractLimits(t *testing.T) {
+	t.Setenv("EXTRACT_MAX_ENTRIES", "2")
+	t.Setenv("EXTRACT_MAX_SIZE", "8")
+
+	ts := newTestServer(t)
+	docs := ts.mkdir("bob", "", "docs")
+
+	w := ts.extract("bob", docs.Id, zipArchiveOf(t, map[string]string{"a": "1", "b": "2", "c": "3"}))
+	expectStatus(t, w, http.StatusRequestEntityTooLarge)
+
+	w = ts.extract("bob", docs.Id, zipArchiveOf(t, map[string]string{"big.txt": strings.Repeat("x", 9)}))
+	expectStatus(t, w, http.StatusRequestEntityTooLarge)
+
+	if content := ts.resource(docs.Id).Content; len(content) != 0 {
+		t.Fatalf("a refused archive left %v", content)
+	}
+}
@@ -0,0 +1,112 @@
+package driver
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/c4me-caro/drive"
+	"github.com/c4me-caro/drive/cmd/auth"
+	"github.com/c4me-caro/drive/database"
+	"github.com/gorilla/mux"
+)
+
+type inheritRequest struct {
+	Inherit bool `json:"inherit"`
+}
+
+func (h Handler) registerInheritRoutes(router *mux.Router) {
+	router.HandleFunc("/inheritance/{id}", h.handleInheritance).Methods("POST")
+}
+
+// setAncestors recomputes the Ancestors of the resource root, placed in
+// parent, and of the resources of tree below it.
+func setAncestors(tree []drive.Resource, root string, parent drive.Resource) {
+	index := make(map[string]int, len(tree))
+	for i, item := range tree {
+		index[item.Id] = i
+	}
+
+	start, ok := index[root]
+	if !ok {
+		return
+	}
+
+	tree[start].Ancestors = auth.Ancestors(tree[start], parent)
+	queue := []int{start}
+	seen := map[string]struct{}{root: {}}
+	for len(queue) > 0 {
+		folder := tree[queue[0]]
+		queue = queue[1:]
+
+		for _, id := range folder.Content {
+			i, ok := index[id]
+			if _, done := seen[id]; !ok || done {
+				continue
+			}
+
+			seen[id] = struct{}{}
+			tree[i].Ancestors = auth.Ancestors(tree[i], folder)
+			queue = append(queue, i)
+		}
+	}
+}
+
+// handleInheritance lets the owner stop a resource from inheriting the
+// grants of the folders above it, or make it inherit them again.
+func (h Handler) handleInheritance(w http.ResponseWriter, r *http.Request) {
+	user, err := h.validateAuthentication(r, "update")
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		io.WriteString(w, "Error: User not found or does not has valid permissions: " + err.Error())
+		return
+	}
+
+	resource, err := h.checkResource(mux.Vars(r)["id"], user, "update")
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		io.WriteString(w, "Error: Resource not found or user has no valid permissions: " + err.Error())
+		return
+	}
+
+	if resource.OwnerId != user.Id {
+		w.WriteHeader(http.StatusForbidden)
+		io.WriteString(w, "Error: Only the owner can change inheritance")
+		return
+	}
+
+	var body inheritRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		io.WriteString(w, "Error: Invalid inheritance")
+		return
+	}
+
+	parent, _ := h.parentOf(resource)
+	resource.NoInherit = !body.Inherit
+
+	tree := h.collectTree(resource)
+	setAncestors(tree, resource.Id, parent)
+
+	err = h.db.Transaction(func(tx database.ResourceStore) error {
+		for _, item := range tree {
+			if err := tx.UpdateResource(item); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(w, "Error: Failed resource update")
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(tree[0]); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(w, "Error: bad json coding")
+		return
+	}
+}
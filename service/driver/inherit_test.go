@@ -0,0 +1,44 @@
+package driver
+
+import (
+	"net/http"
+	"slices"
+	"testing"
+
+	"github.com/c4me-caro/drive"
+)
+
+func TestInheritance(t *testing.T) {
+	ts := newTestServer(t)
+	team := ts.mkdir("alice", "", "team")
+	reports := ts.mkdir("alice", team.Id, "reports")
+	file := ts.file("alice", reports.Id, "a.txt", "hello")
+	expectStatus(t, ts.do("alice", "POST", "/drive/shares/"+team.Id, `{"user":"u2","role":"viewer"}`), http.StatusOK)
+	expectStatus(t, ts.do("bob", "GET", "/drive/f/"+file.Id, ""), http.StatusOK)
+
+	w := ts.do("alice", "POST", "/drive/inheritance/"+reports.Id, `{"inherit":false}`)
+	expectStatus(t, w, http.StatusOK)
+	if got := decode[drive.Resource](t, w); !got.NoInherit || len(got.Ancestors) != 0 {
+		t.Fatalf("unexpected folder: %+v", got)
+	}
+
+	if ancestors := ts.resource(file.Id).Ancestors; !slices.Equal(ancestors, []string{reports.Id}) {
+		t.Fatalf("ancestors = %v, want [%s]", ancestors, reports.Id)
+	}
+
+	expectStatus(t, ts.do("bob", "GET", "/drive/f/"+file.Id, ""), http.StatusUnauthorized)
+
+	expectStatus(t, ts.do("alice", "POST", "/drive/inheritance/"+reports.Id, `{"inherit":true}`), http.StatusOK)
+	expectStatus(t, ts.do("bob", "GET", "/drive/f/"+file.Id, ""), http.StatusOK)
+}
+
+func TestInheritanceFailures(t *testing.T) {
+	ts := newTestServer(t)
+	team := ts.mkdir("alice", "", "team")
+	expectStatus(t, ts.do("alice", "POST", "/drive/shares/"+team.Id, `{"user":"u2","role":"editor"}`), http.StatusOK)
+
+	expectStatus(t, ts.do("alice", "POST", "/drive/inheritance/"+team.Id, `not json`), http.StatusBadRequest)
+	expectStatus(t, ts.do("bob", "POST", "/drive/inheritance/"+team.Id, `{"inherit":false}`), http.StatusForbidden)
+	expectStatus(t, ts.do("carol", "POST", "/drive/inheritance/"+team.Id, `{"inherit":false}`), http.StatusUnauthorized)
+	expectStatus(t, ts.do("alice", "POST", "/drive/inheritance/missing", `{"inherit":false}`), http.StatusUnauthorized)
+}
@@ -0,0 +1,361 @@
+package driver
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/c4me-caro/drive"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	linkRead   = "read"
+	linkUpload = "upload"
+)
+
+type linkRequest struct {
+	Resource     string    `json:"resource"`
+	Mode         string    `json:"mode"`
+	Password     string    `json:"password"`
+	ExpiresAt    time.Time `json:"expiresAt"`
+	MaxDownloads int       `json:"maxDownloads"`
+}
+
+// linkView is a share link as shown to its owner. The token is only known
+// when the link is created, since just its hash is stored.
+type linkView struct {
+	drive.ShareLink
+	Protected bool   `json:"protected"`
+	Token     string `json:"token,omitempty"`
+}
+
+func viewLink(link drive.ShareLink, token string) linkView {
+	view := linkView{ShareLink: link, Protected: link.Password != "", Token: token}
+	view.TokenHash = ""
+	view.Password = ""
+	return view
+}
+
+type sharedEntry struct {
+	Name    string    `json:"name"`
+	Type    string    `json:"type"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+}
+
+type sharedFolder struct {
+	sharedEntry
+	Content []sharedEntry `json:"content"`
+}
+
+func entryOf(resource drive.Resource) sharedEntry {
+	return sharedEntry{
+		Name:    resource.Name,
+		Type:    resource.Type,
+		Size:    resource.Size,
+		ModTime: resource.ModTime,
+	}
+}
+
+func (h Handler) registerLinkRoutes(router *mux.Router) {
+	router.HandleFunc("/links", h.handleNewLink).Methods("POST")
+	router.HandleFunc("/links", h.handleLinks).Methods("GET")
+	router.HandleFunc("/links/{link}", h.handleRevokeLink).Methods("DELETE")
+}
+
+// RegisterShareRoutes serves share links. router must not require a JWT,
+// since link holders have no account.
+func (h Handler) RegisterShareRoutes(router *mux.Router) {
+	router.HandleFunc("/s/{token}", h.handleShare).Methods("GET", "HEAD", "POST")
+	router.HandleFunc("/s/{token}/{path:.*}", h.handleShare).Methods("GET", "HEAD", "POST")
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func newLinkToken() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+func (h Handler) handleNewLink(w http.ResponseWriter, r *http.Request) {
+	user, err := h.validateAuthentication(r, "read")
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		io.WriteString(w, "Error: User not found or does not has valid permissions: " + err.Error())
+		return
+	}
+
+	var body linkRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.MaxDownloads < 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		io.WriteString(w, "Error: Invalid share link")
+		return
+	}
+
+	resource, err := h.checkResource(body.Resource, user, "read")
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		io.WriteString(w, "Error: Resource not found or user has no valid permissions: " + err.Error())
+		return
+	}
+
+	if resource.OwnerId != user.Id {
+		w.WriteHeader(http.StatusForbidden)
+		io.WriteString(w, "Error: Only the owner can share the resource")
+		return
+	}
+
+	if body.Mode == "" {
+		body.Mode = linkRead
+	}
+
+	if body.Mode != linkRead && body.Mode != linkUpload {
+		w.WriteHeader(http.StatusBadRequest)
+		io.WriteString(w, "Error: Unknown share link mode: " + body.Mode)
+		return
+	}
+
+	if body.Mode == linkUpload && resource.Type != "folder" {
+		w.WriteHeader(http.StatusConflict)
+		io.WriteString(w, "Error: Uploads can only be shared on folders")
+		return
+	}
+
+	if !body.ExpiresAt.IsZero() && body.ExpiresAt.Before(time.Now()) {
+		w.WriteHeader(http.StatusBadRequest)
+		io.WriteString(w, "Error: Expiry is in the past")
+		return
+	}
+
+	token, err := newLinkToken()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(w, "Error: token generation failed")
+		return
+	}
+
+	link := drive.ShareLink{
+		Id:           uuid.New().String(),
+		TokenHash:    hashToken(token),
+		ResourceId:   resource.Id,
+		OwnerId:      user.Id,
+		Mode:         body.Mode,
+		ExpiresAt:    body.ExpiresAt.UTC(),
+		MaxDownloads: body.MaxDownloads,
+		CreatedAt:    time.Now().UTC(),
+	}
+
+	if body.Password != "" {
+		hashed, err := bcrypt.GenerateFromPassword([]byte(body.Password), bcrypt.DefaultCost)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, "Error: Invalid password")
+			return
+		}
+
+		link.Password = string(hashed)
+	}
+
+	if err := h.db.CreateShareLink(link); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(w, "Error: Failed share link creation")
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(viewLink(link, token)); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(w, "Error: bad json coding")
+		return
+	}
+}
+
+func (h Handler) handleLinks(w http.ResponseWriter, r *http.Request) {
+	user, err := h.validateAuthentication(r, "read")
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		io.WriteString(w, "Error: User not found or does not has valid permissions: " + err.Error())
+		return
+	}
+
+	links, err := h.db.ListShareLinks(user.Id)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(w, "Error: Failed listing share links")
+		return
+	}
+
+	resource := r.URL.Query().Get("resource")
+	views := []linkView{}
+	for _, link := range links {
+		if resource == "" || link.ResourceId == resource {
+			views = append(views, viewLink(link, ""))
+		}
+	}
+
+	if err := json.NewEncoder(w).Encode(views); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(w, "Error: bad json coding")
+		return
+	}
+}
+
+func (h Handler) handleRevokeLink(w http.ResponseWriter, r *http.Request) {
+	user, err := h.validateAuthentication(r, "read")
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		io.WriteString(w, "Error: User not found or does not has valid permissions: " + err.Error())
+		return
+	}
+
+	links, err := h.db.ListShareLinks(user.Id)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(w, "Error: Failed listing share links")
+		return
+	}
+
+	id := mux.Vars(r)["link"]
+	for _, link := range links {
+		if link.Id != id {
+			continue
+		}
+
+		if err := h.db.DeleteShareLink(id); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			io.WriteString(w, "Error: Failed share link deletion")
+			return
+		}
+
+		io.WriteString(w, "Share link revoked")
+		return
+	}
+
+	w.WriteHeader(http.StatusNotFound)
+	io.WriteString(w, "Error: Share link not found")
+}
+
+// openShare checks the token, expiry and password of the request and
+// returns the link with the shared resource.
+func (h Handler) openShare(w http.ResponseWriter, r *http.Request) (drive.ShareLink, drive.Resource, bool) {
+	link, err := h.db.GetShareLink(hashToken(mux.Vars(r)["token"]))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		io.WriteString(w, "Error: Share link not found")
+		return drive.ShareLink{}, drive.Resource{}, false
+	}
+
+	if !link.ExpiresAt.IsZero() && time.Now().After(link.ExpiresAt) {
+		w.WriteHeader(http.StatusGone)
+		io.WriteString(w, "Error: Share link has expired")
+		return drive.ShareLink{}, drive.Resource{}, false
+	}
+
+	if link.Password != "" {
+		_, password, _ := r.BasicAuth()
+		if password == "" {
+			password = r.Header.Get("Share-Password")
+		}
+
+		if bcrypt.CompareHashAndPassword([]byte(link.Password), []byte(password)) != nil {
+			w.Header().Set("WWW-Authenticate", `Basic realm="share"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			io.WriteString(w, "Error: Share link password is not valid")
+			return drive.ShareLink{}, drive.Resource{}, false
+		}
+	}
+
+	resource, err := h.db.GetResource(link.ResourceId)
+	if err != nil || resource.Trashed {
+		w.WriteHeader(http.StatusNotFound)
+		io.WriteString(w, "Error: Shared resource not found")
+		return drive.ShareLink{}, drive.Resource{}, false
+	}
+
+	return link, resource, true
+}
+
+func (h Handler) handleShare(w http.ResponseWriter, r *http.Request) {
+	link, resource, ok := h.openShare(w, r)
+	if !ok {
+		return
+	}
+
+	if path := mux.Vars(r)["path"]; path != "" {
+		var err error
+		resource, _, err = h.walkPath(resource, true, path, nil)
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, "Error: " + err.Error())
+			return
+		}
+	}
+
+	switch {
+	case r.Method == http.MethodPost && link.Mode != linkUpload:
+		w.WriteHeader(http.StatusForbidden)
+		io.WriteString(w, "Error: Share link does not allow uploads")
+	case r.Method == http.MethodPost && resource.Type != "folder":
+		w.WriteHeader(http.StatusConflict)
+		io.WriteString(w, "Error: Resource is not a folder")
+	case r.Method == http.MethodPost:
+		h.storeUpload(w, r, resource, drive.User{Id: link.OwnerId})
+	case resource.Type == "folder":
+		h.listShared(w, resource)
+	default:
+		h.serveShared(w, r, link, resource)
+	}
+}
+
+func (h Handler) serveShared(w http.ResponseWriter, r *http.Request, link drive.ShareLink, resource drive.Resource) {
+	// Every GET counts, ranges included, and is counted before anything is
+	// served, so the limit holds however a download is split up.
+	exhausted := link.MaxDownloads > 0 && link.Downloads >= link.MaxDownloads
+	if r.Method == http.MethodGet {
+		exhausted = h.db.UseShareLink(link.Id) != nil
+	}
+
+	if exhausted {
+		w.WriteHeader(http.StatusGone)
+		io.WriteString(w, "Error: Share link download limit reached")
+		return
+	}
+
+	h.serveFile(w, r, resource, drive.Version{
+		Location: resource.Location,
+		Hash:     resource.Hash,
+		ModTime:  resource.ModTime,
+	})
+}
+
+func (h Handler) listShared(w http.ResponseWriter, folder drive.Resource) {
+	listing := sharedFolder{sharedEntry: entryOf(folder), Content: []sharedEntry{}}
+	for _, id := range folder.Content {
+		child, err := h.db.GetResource(id)
+		if err != nil || child.Trashed {
+			continue
+		}
+
+		listing.Content = append(listing.Content, entryOf(child))
+	}
+
+	if err := json.NewEncoder(w).Encode(listing); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(w, "Error: bad json coding")
+		return
+	}
+}
@@ -0,0 +1,200 @@
+package driver
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/c4me-caro/drive"
+)
+
+// newLink shares resource of user with the options in body and returns
+// the link.
+func (ts *testServer) newLink(user string, body string) linkView {
+	ts.t.Helper()
+
+	w := ts.do(user, "POST", "/drive/links", body)
+	expectStatus(ts.t, w, http.StatusOK)
+	return decode[linkView](ts.t, w)
+}
+
+func TestNewLink(t *testing.T) {
+	ts := newTestServer(t)
+	docs := ts.mkdir("bob", "", "docs")
+	file := ts.file("bob", docs.Id, "a.txt", "hello")
+	theirs := ts.mkdir("alice", "", "private")
+
+	link := ts.newLink("bob", `{"resource":"`+file.Id+`"}`)
+	if link.Token == "" || link.Mode != linkRead || link.ResourceId != file.Id || link.TokenHash != "" {
+		t.Fatalf("unexpected link: %+v", link)
+	}
+
+	past := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+	expectStatus(t, ts.do("bob", "POST", "/drive/links", `not json`), http.StatusBadRequest)
+	expectStatus(t, ts.do("bob", "POST", "/drive/links", `{"resource":"`+file.Id+`","maxDownloads":-1}`), http.StatusBadRequest)
+	expectStatus(t, ts.do("bob", "POST", "/drive/links", `{"resource":"`+file.Id+`","mode":"write"}`), http.StatusBadRequest)
+	expectStatus(t, ts.do("bob", "POST", "/drive/links", `{"resource":"`+file.Id+`","expiresAt":"`+past+`"}`), http.StatusBadRequest)
+	expectStatus(t, ts.do("bob", "POST", "/drive/links", `{"resource":"`+file.Id+`","mode":"upload"}`), http.StatusConflict)
+	expectStatus(t, ts.do("bob", "POST", "/drive/links", `{"resource":"`+theirs.Id+`"}`), http.StatusUnauthorized)
+	expectStatus(t, ts.do("alice", "POST", "/drive/links", `{"resource":"`+file.Id+`"}`), http.StatusForbidden)
+}
+
+func TestLinks(t *testing.T) {
+	ts := newTestServer(t)
+	docs := ts.mkdir("bob", "", "docs")
+	file := ts.file("bob", docs.Id, "a.txt", "hello")
+	ts.newLink("bob", `{"resource":"`+docs.Id+`"}`)
+	link := ts.newLink("bob", `{"resource":"`+file.Id+`"}`)
+
+	w := ts.do("bob", "GET", "/drive/links", "")
+	expectStatus(t, w, http.StatusOK)
+	if links := decode[[]linkView](t, w); len(links) != 2 {
+		t.Fatalf("links = %+v, want 2", links)
+	}
+
+	w = ts.do("bob", "GET", "/drive/links?resource="+file.Id, "")
+	expectStatus(t, w, http.StatusOK)
+	links := decode[[]linkView](t, w)
+	if len(links) != 1 || links[0].Id != link.Id || links[0].Token != "" {
+		t.Fatalf("links = %+v, want the file link without its token", links)
+	}
+
+	w = ts.do("alice", "GET", "/drive/links", "")
+	expectStatus(t, w, http.StatusOK)
+	if links := decode[[]linkView](t, w); len(links) != 0 {
+		t.Fatalf("links of alice = %+v, want none", links)
+	}
+}
+
+func TestRevokeLink(t *testing.T) {
+	ts := newTestServer(t)
+	docs := ts.mkdir("bob", "", "docs")
+	file := ts.file("bob", docs.Id, "a.txt", "hello")
+	link := ts.newLink("bob", `{"resource":"`+file.Id+`"}`)
+
+	expectStatus(t, ts.do("alice", "DELETE", "/drive/links/"+link.Id, ""), http.StatusNotFound)
+	expectStatus(t, ts.do("bob", "DELETE", "/drive/links/"+link.Id, ""), http.StatusOK)
+	expectStatus(t, ts.do("", "GET", "/s/"+link.Token, ""), http.StatusNotFound)
+	expectStatus(t, ts.do("bob", "DELETE", "/drive/links/"+link.Id, ""), http.StatusNotFound)
+}
+
+func TestShareFile(t *testing.T) {
+	ts := newTestServer(t)
+	docs := ts.mkdir("bob", "", "docs")
+	file := ts.file("bob", docs.Id, "a.txt", "hello")
+	link := ts.newLink("bob", `{"resource":"`+file.Id+`","maxDownloads":2}`)
+
+	// HEAD serves no content, so it does not count.
+	w := ts.do("", "HEAD", "/s/"+link.Token, "")
+	expectStatus(t, w, http.StatusOK)
+	if length := w.Header().Get("Content-Length"); length != "5" {
+		t.Fatalf("Content-Length = %s, want 5", length)
+	}
+
+	w = ts.do("", "GET", "/s/"+link.Token, "")
+	expectStatus(t, w, http.StatusOK)
+	expectBody(t, w, "hello")
+
+	// Ranges count like any other download.
+	r := ts.request("", "GET", "/s/"+link.Token, nil)
+	r.Header.Set("Range", "bytes=1-")
+	w = ts.serve(r)
+	expectStatus(t, w, http.StatusPartialContent)
+	expectBody(t, w, "ello")
+
+	expectStatus(t, ts.do("", "GET", "/s/"+link.Token, ""), http.StatusGone)
+	expectStatus(t, ts.do("", "GET", "/s/unknown", ""), http.StatusNotFound)
+	expectStatus(t, ts.do("", "POST", "/s/"+link.Token, ""), http.StatusForbidden)
+
+	expectStatus(t, ts.do("bob", "GET", "/drive/r/"+file.Id, ""), http.StatusOK)
+	other := ts.newLink("bob", `{"resource":"`+docs.Id+`"}`)
+	expectStatus(t, ts.do("", "GET", "/s/"+other.Token+"/a.txt", ""), http.StatusNotFound)
+}
+
+func TestShareFolder(t *testing.T) {
+	ts := newTestServer(t)
+	docs := ts.mkdir("bob", "", "docs")
+	reports := ts.mkdir("bob", docs.Id, "reports")
+	ts.file("bob", reports.Id, "a.txt", "hello")
+	link := ts.newLink("bob", `{"resource":"`+docs.Id+`"}`)
+
+	w := ts.do("", "GET", "/s/"+link.Token, "")
+	expectStatus(t, w, http.StatusOK)
+	if listing := decode[sharedFolder](t, w); listing.Name != "docs" || len(listing.Content) != 1 || listing.Content[0].Name != "reports" {
+		t.Fatalf("listing = %+v", listing)
+	}
+
+	w = ts.do("", "GET", "/s/"+link.Token+"/reports/a.txt", "")
+	expectStatus(t, w, http.StatusOK)
+	expectBody(t, w, "hello")
+
+	expectStatus(t, ts.do("", "GET", "/s/"+link.Token+"/missing", ""), http.StatusNotFound)
+	expectStatus(t, ts.serve(ts.uploadRequest("", "/s/"+link.Token, "b.txt", "hi")), http.StatusForbidden)
+}
+
+func TestShareUpload(t *testing.T) {
+	ts := newTestServer(t)
+	docs := ts.mkdir("bob", "", "docs")
+	ts.file("bob", docs.Id, "a.txt", "hello")
+	link := ts.newLink("bob", `{"resource":"`+docs.Id+`","mode":"upload"}`)
+
+	expectStatus(t, ts.serve(ts.uploadRequest("", "/s/"+link.Token, "b.txt", "hi")), http.StatusOK)
+	if child, err := ts.db.GetChild(docs.Id, "u2", "b.txt"); err != nil || child.OwnerId != "u2" {
+		t.Fatalf("uploaded file = %+v, %v, want it owned by bob", child, err)
+	}
+
+	expectStatus(t, ts.serve(ts.uploadRequest("", "/s/"+link.Token, "a.txt", "again")), http.StatusConflict)
+	expectStatus(t, ts.serve(ts.uploadRequest("", "/s/"+link.Token+"/a.txt", "c.txt", "hi")), http.StatusConflict)
+}
+
+func TestSharePassword(t *testing.T) {
+	ts := newTestServer(t)
+	docs := ts.mkdir("bob", "", "docs")
+	file := ts.file("bob", docs.Id, "a.txt", "hello")
+	link := ts.newLink("bob", `{"resource":"`+file.Id+`","password":"secret"}`)
+	if !link.Protected {
+		t.Fatal("link is not marked as protected")
+	}
+
+	w := ts.do("", "GET", "/s/"+link.Token, "")
+	expectStatus(t, w, http.StatusUnauthorized)
+	if w.Header().Get("WWW-Authenticate") == "" {
+		t.Fatal("no WWW-Authenticate challenge")
+	}
+
+	r := ts.request("", "GET", "/s/"+link.Token, nil)
+	r.Header.Set("Share-Password", "wrong")
+	expectStatus(t, ts.serve(r), http.StatusUnauthorized)
+
+	r = ts.request("", "GET", "/s/"+link.Token, nil)
+	r.SetBasicAuth("", "secret")
+	w = ts.serve(r)
+	expectStatus(t, w, http.StatusOK)
+	expectBody(t, w, "hello")
+}
+
+func TestShareExpiry(t *testing.T) {
+	ts := newTestServer(t)
+	docs := ts.mkdir("bob", "", "docs")
+	file := ts.file("bob", docs.Id, "a.txt", "hello")
+
+	expired := drive.ShareLink{
+		Id:         "expired",
+		TokenHash:  hashToken("expired-token"),
+		ResourceId: file.Id,
+		OwnerId:    "u2",
+		Mode:       linkRead,
+		ExpiresAt:  time.Now().Add(-time.Minute),
+		CreatedAt:  time.Now().Add(-time.Hour),
+	}
+
+	if err := ts.db.CreateShareLink(expired); err != nil {
+		t.Fatal(err)
+	}
+
+	expectStatus(t, ts.do("", "GET", "/s/expired-token", ""), http.StatusGone)
+
+	later := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	link := ts.newLink("bob", `{"resource":"`+file.Id+`","expiresAt":"`+later+`"}`)
+	expectStatus(t, ts.do("", "GET", "/s/"+link.Token, ""), http.StatusOK)
+}
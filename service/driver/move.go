@@ -0,0 +1,491 @@
+package driver
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/c4me-caro/drive"
+	"github.com/c4me-caro/drive/cmd/auth"
+	"github.com/c4me-caro/drive/database"
+	"github.com/c4me-caro/drive/storage"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+type moveRequest struct {
+	Parent string `json:"parent"`
+	Name   string `json:"name"`
+}
+
+func (h Handler) registerMoveRoutes(router *mux.Router) {
+	router.HandleFunc("/move/{id}", h.handleMove).Methods("POST")
+	router.HandleFunc("/rename/{id}", h.handleRename).Methods("POST")
+	router.HandleFunc("/copy/{id}", h.handleCopy).Methods("POST")
+}
+
+// parentOf finds the folder holding resource, falling back to a content
+// lookup for resources created before the parent was recorded.
+func (h Handler) parentOf(resource drive.Resource) (drive.Resource, bool) {
+	if resource.Parent != "" {
+		parent, err := h.db.GetResource(resource.Parent)
+		if err == nil {
+			return parent, true
+		}
+	}
+
+	parent, err := h.db.GetParent(resource.Id)
+	if err != nil {
+		return drive.Resource{}, false
+	}
+
+	return parent, true
+}
+
+// isDescendant reports whether folder lies inside ancestor, walking up the
+// parents of folder.
+func (h Handler) isDescendant(folder drive.Resource, ancestor string) bool {
+	seen := make(map[string]struct{})
+	for current, ok := folder, true; ok; current, ok = h.parentOf(current) {
+		if current.Id == ancestor {
+			return true
+		}
+
+		if _, loop := seen[current.Id]; loop {
+			return true
+		}
+
+		seen[current.Id] = struct{}{}
+	}
+
+	return false
+}
+
+// validName rejects names that would break path addressing.
+func validName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.Contains(name, "/")
+}
+
+// decodeMove reads the body of a move, rename or copy, answering 400 when
+// it is missing or malformed.
+func decodeMove(w http.ResponseWriter, r *http.Request) (moveRequest, bool) {
+	var body moveRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		io.WriteString(w, "Error: Invalid request body: " + err.Error())
+		return moveRequest{}, false
+	}
+
+	return body, true
+}
+
+// destination resolves the target folder of a move or copy. An empty id
+// means the top level of the drive.
+func (h Handler) destination(id string, user drive.User) (drive.Resource, error) {
+	if id == "" {
+		return drive.Resource{}, nil
+	}
+
+	parent, err := h.checkResource(id, user, "update")
+	if err != nil {
+		return drive.Resource{}, err
+	}
+
+	if parent.Type != "folder" {
+		return drive.Resource{}, fmt.Errorf("destination is not a folder: %s", id)
+	}
+
+	return parent, nil
+}
+
+// plannedBlob is a blob referenced by a planned file. New content, or a
+// copy of a file stored before deduplication, has its bytes staged at temp.
+type plannedBlob struct {
+	temp string
+	blob drive.Blob
+}
+
+// treePlan collects the resources of a copied or extracted tree and the
+// blobs they reference, so the whole tree can be saved in a single
+// transaction.
+type treePlan struct {
+	resources []drive.Resource
+	blobs     []plannedBlob
+}
+
+func (plan *treePlan) discard(h Handler) {
+	for _, item := range plan.blobs {
+		if item.temp != "" {
+			h.discardObjects(item.temp)
+		}
+	}
+}
+
+// save stores or references the blobs of plan and creates its resources,
+// recording on each file the key its blob is encrypted with.
+func (plan *treePlan) save(h Handler, tx database.ResourceStore) error {
+	keyIds := make(map[string]string)
+	for _, item := range plan.blobs {
+		var stored drive.Blob
+		var err error
+		if item.temp != "" {
+			stored, err = h.storeBlob(tx, item.temp, item.blob)
+		} else {
+			stored, err = tx.AcquireBlob(item.blob)
+		}
+
+		if err != nil {
+			return err
+		}
+
+		keyIds[stored.Hash] = stored.KeyId
+	}
+
+	for i := range plan.resources {
+		item := &plan.resources[i]
+		if item.Type == "file" {
+			item.KeyId = keyIds[item.Hash]
+			item.Versions[0].KeyId = item.KeyId
+		}
+
+		if err := tx.CreateResource(*item); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (h Handler) copyTree(source drive.Resource, parent drive.Resource, name string, user drive.User, plan *treePlan) (drive.Resource, error) {
+	var body drive.Resource
+
+	body.Id = uuid.New().String()
+	body.Name = name
+	body.OwnerId = user.Id
+	body.SharedId = []string{}
+	body.Type = source.Type
+	body.Content = []string{}
+	body.Parent = parent.Id
+	body.Retention = source.Retention
+	body.NoInherit = source.NoInherit
+	body.Ancestors = auth.Ancestors(body, parent)
+
+	if source.Type == "folder" {
+		body.Location = parent.Name
+	} else {
+		copied := plannedBlob{}
+		if hash, ok := storage.BlobHash(source.Location); ok {
+			copied.blob.Hash = hash
+			body.Size = source.Size
+		} else {
+			object, err := h.store.Get(source.Location)
+			if err != nil {
+				return drive.Resource{}, err
+			}
+
+			copied.temp, body.Size, copied.blob, err = h.stageObject(object)
+			object.Close()
+			if err != nil {
+				return drive.Resource{}, err
+			}
+		}
+
+		plan.blobs = append(plan.blobs, copied)
+		body.Location = storage.BlobKey(copied.blob.Hash)
+		body.Hash = copied.blob.Hash
+		body.ModTime = time.Now().UTC()
+		body.Version = 1
+		body.Versions = []drive.Version{{
+			Number:   1,
+			Location: body.Location,
+			Size:     body.Size,
+			Hash:     body.Hash,
+			AuthorId: user.Id,
+			ModTime:  body.ModTime,
+		}}
+	}
+
+	for _, id := range source.Content {
+		child, err := h.db.GetResource(id)
+		if err != nil || child.Trashed {
+			continue
+		}
+
+		// Like archives, copies leave out what the user cannot read.
+		if auth.FindPermission(user, "read", child) == "" {
+			continue
+		}
+
+		copied, err := h.copyTree(child, body, child.Name, user, plan)
+		if err != nil {
+			return drive.Resource{}, err
+		}
+
+		body.Content = append(body.Content, copied.Id)
+	}
+
+	plan.resources = append(plan.resources, body)
+	return body, nil
+}
+
+func (h Handler) handleMove(w http.ResponseWriter, r *http.Request) {
+	user, err := h.validateAuthentication(r, "update")
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		io.WriteString(w, "Error: User not found or does not has valid permissions: " + err.Error())
+		return
+	}
+
+	resource, err := h.checkResource(mux.Vars(r)["id"], user, "update")
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		io.WriteString(w, "Error: Resource not found or user has no valid permissions: " + err.Error())
+		return
+	}
+
+	body, ok := decodeMove(w, r)
+	if !ok {
+		return
+	}
+
+	target, err := h.destination(body.Parent, user)
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		io.WriteString(w, "Error: Resource not found or user has no valid permissions: " + err.Error())
+		return
+	}
+
+	if target.Id != "" && resource.Type == "folder" && h.isDescendant(target, resource.Id) {
+		w.WriteHeader(http.StatusConflict)
+		io.WriteString(w, "Error: Folder cannot be moved inside itself")
+		return
+	}
+
+	if h.nameTaken(target.Id, resource.OwnerId, resource.Name, resource.Id) {
+		w.WriteHeader(http.StatusConflict)
+		io.WriteString(w, "Error: Name already exists in folder")
+		return
+	}
+
+	source, hasSource := h.parentOf(resource)
+	if hasSource && source.Id != "0" {
+		if _, err := h.checkResource(source.Id, user, "update"); err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			io.WriteString(w, "Error: Resource not found or user has no valid permissions: " + err.Error())
+			return
+		}
+
+	}
+
+	resource.Parent = target.Id
+	if resource.Type == "folder" {
+		resource.Location = target.Name
+	}
+
+	tree := h.collectTree(resource)
+	setAncestors(tree, resource.Id, target)
+	resource = tree[0]
+
+	err = h.db.Transaction(func(tx database.ResourceStore) error {
+		if hasSource && source.Id != "0" {
+			if err := tx.RemoveResourceChildren(source, resource.Id); err != nil {
+				return err
+			}
+		}
+
+		if target.Id != "" {
+			if err := tx.AddResourceChildren(target, resource.Id); err != nil {
+				return err
+			}
+		}
+
+		for _, item := range tree {
+			if err := tx.UpdateResource(item); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		if nameConflict(w, err) {
+			return
+		}
+
+		w.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(w, "Error: Failed resource update")
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(resource); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(w, "Error: bad json coding")
+		return
+	}
+}
+
+func (h Handler) handleRename(w http.ResponseWriter, r *http.Request) {
+	user, err := h.validateAuthentication(r, "update")
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		io.WriteString(w, "Error: User not found or does not has valid permissions: " + err.Error())
+		return
+	}
+
+	resource, err := h.checkResource(mux.Vars(r)["id"], user, "update")
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		io.WriteString(w, "Error: Resource not found or user has no valid permissions: " + err.Error())
+		return
+	}
+
+	body, ok := decodeMove(w, r)
+	if !ok {
+		return
+	}
+
+	if !validName(body.Name) {
+		w.WriteHeader(http.StatusBadRequest)
+		io.WriteString(w, "Error: Invalid name: " + body.Name)
+		return
+	}
+
+	if h.nameTaken(resource.Parent, resource.OwnerId, body.Name, resource.Id) {
+		w.WriteHeader(http.StatusConflict)
+		io.WriteString(w, "Error: Name already exists in folder")
+		return
+	}
+
+	resource.Name = body.Name
+	children := []drive.Resource{}
+	if resource.Type == "folder" {
+		for _, id := range resource.Content {
+			child, err := h.db.GetResource(id)
+			if err != nil || child.Type != "folder" {
+				continue
+			}
+
+			child.Location = resource.Name
+			children = append(children, child)
+		}
+	}
+
+	err = h.db.Transaction(func(tx database.ResourceStore) error {
+		if err := tx.UpdateResource(resource); err != nil {
+			return err
+		}
+
+		for _, child := range children {
+			if err := tx.UpdateResource(child); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		if nameConflict(w, err) {
+			return
+		}
+
+		w.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(w, "Error: Failed resource update")
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(resource); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(w, "Error: bad json coding")
+		return
+	}
+}
+
+func (h Handler) handleCopy(w http.ResponseWriter, r *http.Request) {
+	user, err := h.validateAuthentication(r, "create")
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		io.WriteString(w, "Error: User not found or does not has valid permissions: " + err.Error())
+		return
+	}
+
+	resource, err := h.checkResource(mux.Vars(r)["id"], user, "read")
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		io.WriteString(w, "Error: Resource not found or user has no valid permissions: " + err.Error())
+		return
+	}
+
+	body, ok := decodeMove(w, r)
+	if !ok {
+		return
+	}
+
+	if body.Name == "" {
+		body.Name = resource.Name
+	}
+
+	if !validName(body.Name) {
+		w.WriteHeader(http.StatusBadRequest)
+		io.WriteString(w, "Error: Invalid name: " + body.Name)
+		return
+	}
+
+	target, err := h.destination(body.Parent, user)
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		io.WriteString(w, "Error: Resource not found or user has no valid permissions: " + err.Error())
+		return
+	}
+
+	if target.Id != "" && resource.Type == "folder" && h.isDescendant(target, resource.Id) {
+		w.WriteHeader(http.StatusConflict)
+		io.WriteString(w, "Error: Folder cannot be copied inside itself")
+		return
+	}
+
+	if h.nameTaken(target.Id, user.Id, body.Name, "") {
+		w.WriteHeader(http.StatusConflict)
+		io.WriteString(w, "Error: Name already exists in folder")
+		return
+	}
+
+	plan := &treePlan{}
+	copied, err := h.copyTree(resource, target, body.Name, user, plan)
+	if err == nil {
+		err = h.db.Transaction(func(tx database.ResourceStore) error {
+			if err := plan.save(h, tx); err != nil {
+				return err
+			}
+
+			copied = plan.resources[len(plan.resources)-1]
+
+			if target.Id != "" {
+				return tx.AddResourceChildren(target, copied.Id)
+			}
+
+			return nil
+		})
+	}
+
+	plan.discard(h)
+	if err != nil {
+		if nameConflict(w, err) {
+			return
+		}
+
+		w.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(w, "Error: Failed resource copy")
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(copied); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(w, "Error: bad json coding")
+		return
+	}
+}
@@ -0,0 +1,130 @@
+package driver
+
+import (
+	"net/http"
+	"slices"
+	"testing"
+
+	"github.com/c4me-caro/drive"
+)
+
+func TestMove(t *testing.T) {
+	ts := newTestServer(t)
+	docs := ts.mkdir("bob", "", "docs")
+	archive := ts.mkdir("bob", "", "archive")
+	reports := ts.mkdir("bob", docs.Id, "reports")
+	file := ts.file("bob", reports.Id, "a.txt", "hello")
+
+	w := ts.do("bob", "POST", "/drive/move/"+reports.Id, `{"parent":"`+archive.Id+`"}`)
+	expectStatus(t, w, http.StatusOK)
+	if got := decode[drive.Resource](t, w); got.Parent != archive.Id {
+		t.Fatalf("parent = %s, want %s", got.Parent, archive.Id)
+	}
+
+	if slices.Contains(ts.resource(docs.Id).Content, reports.Id) || !slices.Contains(ts.resource(archive.Id).Content, reports.Id) {
+		t.Fatal("folder contents were not updated")
+	}
+
+	if ancestors := ts.resource(file.Id).Ancestors; !slices.Equal(ancestors, []string{archive.Id, reports.Id}) {
+		t.Fatalf("ancestors = %v", ancestors)
+	}
+
+	// Back to the top level.
+	expectStatus(t, ts.do("bob", "POST", "/drive/move/"+reports.Id, `{"parent":""}`), http.StatusOK)
+	if parent := ts.resource(reports.Id).Parent; parent != "" {
+		t.Fatalf("parent = %s, want the top level", parent)
+	}
+}
+
+func TestMoveFailures(t *testing.T) {
+	ts := newTestServer(t)
+	docs := ts.mkdir("bob", "", "docs")
+	reports := ts.mkdir("bob", docs.Id, "reports")
+	file := ts.file("bob", docs.Id, "a.txt", "hello")
+	ts.mkdir("bob", "", "a.txt")
+	theirs := ts.mkdir("alice", "", "private")
+
+	expectStatus(t, ts.do("bob", "POST", "/drive/move/"+docs.Id, ""), http.StatusBadRequest)
+	expectStatus(t, ts.do("bob", "POST", "/drive/move/"+docs.Id, "{"), http.StatusBadRequest)
+	expectStatus(t, ts.do("bob", "POST", "/drive/move/"+docs.Id, `{"parent":"`+reports.Id+`"}`), http.StatusConflict)
+	expectStatus(t, ts.do("bob", "POST", "/drive/move/"+file.Id, `{"parent":""}`), http.StatusConflict)
+	expectStatus(t, ts.do("bob", "POST", "/drive/move/"+file.Id, `{"parent":"`+file.Id+`"}`), http.StatusUnauthorized)
+	expectStatus(t, ts.do("bob", "POST", "/drive/move/"+file.Id, `{"parent":"`+theirs.Id+`"}`), http.StatusUnauthorized)
+	expectStatus(t, ts.do("bob", "POST", "/drive/move/"+theirs.Id, `{"parent":"`+docs.Id+`"}`), http.StatusUnauthorized)
+	expectStatus(t, ts.do("carol", "POST", "/drive/move/"+file.Id, `{"parent":"`+reports.Id+`"}`), http.StatusUnauthorized)
+}
+
+func TestRename(t *testing.T) {
+	ts := newTestServer(t)
+	docs := ts.mkdir("bob", "", "docs")
+	reports := ts.mkdir("bob", docs.Id, "reports")
+	ts.file("bob", docs.Id, "a.txt", "hello")
+
+	w := ts.do("bob", "POST", "/drive/rename/"+docs.Id, `{"name":"papers"}`)
+	expectStatus(t, w, http.StatusOK)
+	if got := decode[drive.Resource](t, w); got.Name != "papers" {
+		t.Fatalf("name = %s, want papers", got.Name)
+	}
+
+	if location := ts.resource(reports.Id).Location; location != "papers" {
+		t.Fatalf("location of the child folder = %s, want papers", location)
+	}
+
+	for _, name := range []string{"", ".", "..", "a/b"} {
+		expectStatus(t, ts.do("bob", "POST", "/drive/rename/"+reports.Id, `{"name":"`+name+`"}`), http.StatusBadRequest)
+	}
+
+	expectStatus(t, ts.do("bob", "POST", "/drive/rename/"+reports.Id, ""), http.StatusBadRequest)
+	expectStatus(t, ts.do("bob", "POST", "/drive/rename/"+reports.Id, `{"name":"a.txt"}`), http.StatusConflict)
+	expectStatus(t, ts.do("carol", "POST", "/drive/rename/"+reports.Id, `{"name":"x"}`), http.StatusUnauthorized)
+}
+
+func TestCopy(t *testing.T) {
+	ts := newTestServer(t)
+	docs := ts.mkdir("bob", "", "docs")
+	reports := ts.mkdir("bob", docs.Id, "reports")
+	file := ts.file("bob", reports.Id, "a.txt", "hello")
+
+	w := ts.do("bob", "POST", "/drive/copy/"+reports.Id, `{"parent":"","name":"copied"}`)
+	expectStatus(t, w, http.StatusOK)
+
+	copied := decode[drive.Resource](t, w)
+	if copied.Id == reports.Id || copied.Name != "copied" || copied.Parent != "" || len(copied.Content) != 1 {
+		t.Fatalf("unexpected copy: %+v", copied)
+	}
+
+	child := ts.resource(copied.Content[0])
+	if child.Id == file.Id || child.Location != file.Location {
+		t.Fatalf("copied file = %+v, want a new file sharing the blob", child)
+	}
+
+	expectBody(t, ts.do("bob", "GET", "/drive/f/"+child.Id, ""), "hello")
+
+	expectStatus(t, ts.do("bob", "POST", "/drive/copy/"+reports.Id, ""), http.StatusBadRequest)
+	expectStatus(t, ts.do("bob", "POST", "/drive/copy/"+reports.Id, `{"name":".."}`), http.StatusBadRequest)
+	expectStatus(t, ts.do("bob", "POST", "/drive/copy/"+docs.Id, `{"parent":"`+reports.Id+`"}`), http.StatusConflict)
+	expectStatus(t, ts.do("bob", "POST", "/drive/copy/"+reports.Id, `{"parent":"`+docs.Id+`"}`), http.StatusConflict)
+	expectStatus(t, ts.do("carol", "POST", "/drive/copy/"+reports.Id, `{"parent":""}`), http.StatusUnauthorized)
+}
+
+func TestCopySkipsUnreadable(t *testing.T) {
+	ts := newTestServer(t)
+	team := ts.mkdir("alice", "", "team")
+	expectStatus(t, ts.do("alice", "POST", "/drive/shares/"+team.Id, `{"user":"u2","role":"viewer"}`), http.StatusOK)
+
+	ts.file("alice", team.Id, "open.txt", "open")
+	secret := ts.file("alice", team.Id, "secret.txt", "secret")
+	expectStatus(t, ts.do("alice", "POST", "/drive/inheritance/"+secret.Id, `{"inherit":false}`), http.StatusOK)
+
+	w := ts.do("bob", "POST", "/drive/copy/"+team.Id, `{"parent":""}`)
+	expectStatus(t, w, http.StatusOK)
+
+	copied := decode[drive.Resource](t, w)
+	if copied.OwnerId != "u2" || len(copied.Content) != 1 {
+		t.Fatalf("unexpected copy: %+v", copied)
+	}
+
+	if name := ts.resource(copied.Content[0]).Name; name != "open.txt" {
+		t.Fatalf("copied %s, want only open.txt", name)
+	}
+}
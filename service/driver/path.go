@@ -0,0 +1,127 @@
+package driver
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/c4me-caro/drive"
+	"github.com/c4me-caro/drive/cmd/auth"
+	"github.com/c4me-caro/drive/database"
+	"github.com/gorilla/mux"
+)
+
+func (h Handler) registerPathRoutes(router *mux.Router) {
+	router.HandleFunc("/p/{path:.*}", h.handlePath).Methods("GET", "HEAD", "POST", "DELETE")
+}
+
+// nameTaken reports whether another live resource called name already sits
+// in the parent folder. An empty parent is the top level of owner.
+func (h Handler) nameTaken(parent string, owner string, name string, self string) bool {
+	existing, err := h.db.GetChild(parent, owner, name)
+	return err == nil && existing.Id != self
+}
+
+// nameConflict answers 409 when a write failed on the unique names of the
+// store, which catch what nameTaken misses between concurrent requests.
+func nameConflict(w http.ResponseWriter, err error) bool {
+	if !errors.Is(err, database.ErrNameTaken) {
+		return false
+	}
+
+	w.WriteHeader(http.StatusConflict)
+	io.WriteString(w, "Error: Name already exists in folder")
+	return true
+}
+
+// resolvePath walks a slash separated path from the top level of user down
+// through each folder's Content and returns the resource it names. Folders
+// user cannot read are not entered, so paths never reveal what is inside.
+func (h Handler) resolvePath(path string, user drive.User) (drive.Resource, error) {
+	enter := func(folder drive.Resource) bool {
+		return auth.FindPermission(user, "read", folder) != ""
+	}
+
+	current, found, err := h.walkPath(drive.Resource{OwnerId: user.Id}, false, path, enter)
+	if err != nil {
+		return drive.Resource{}, err
+	}
+
+	if !found {
+		return drive.Resource{}, fmt.Errorf("no path specified")
+	}
+
+	return current, nil
+}
+
+// walkPath resolves path below current. found tells whether current is a
+// real folder rather than the top level of current.OwnerId, and is returned
+// set once the path named anything. enter, when given, must allow every
+// folder walked through.
+func (h Handler) walkPath(current drive.Resource, found bool, path string, enter func(drive.Resource) bool) (drive.Resource, bool, error) {
+	for _, name := range strings.Split(path, "/") {
+		if name == "" || name == "." {
+			continue
+		}
+
+		if found && current.Type != "folder" {
+			return drive.Resource{}, false, fmt.Errorf("not a folder: %s", current.Name)
+		}
+
+		if found && enter != nil && !enter(current) {
+			return drive.Resource{}, false, fmt.Errorf("path not found: %s", path)
+		}
+
+		child, err := h.db.GetChild(current.Id, current.OwnerId, name)
+		if err != nil {
+			return drive.Resource{}, false, fmt.Errorf("path not found: %s", path)
+		}
+
+		if found && !slices.Contains(current.Content, child.Id) {
+			return drive.Resource{}, false, fmt.Errorf("path not found: %s", path)
+		}
+
+		current = child
+		found = true
+	}
+
+	return current, found, nil
+}
+
+// handlePath resolves the path and hands the request over to the id based
+// handler for the resource type, so both route styles share their checks.
+func (h Handler) handlePath(w http.ResponseWriter, r *http.Request) {
+	user, err := h.validateAuthentication(r, "read")
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		io.WriteString(w, "Error: User not found or does not has valid permissions: " + err.Error())
+		return
+	}
+
+	resource, err := h.resolvePath(mux.Vars(r)["path"], user)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		io.WriteString(w, "Error: " + err.Error())
+		return
+	}
+
+	folder := resource.Type == "folder"
+	switch {
+	case r.Method == http.MethodPost && folder:
+		h.handleNewFile(w, mux.SetURLVars(r, map[string]string{"parent": resource.Id}))
+	case r.Method == http.MethodDelete && folder:
+		h.handleDeleteFolder(w, mux.SetURLVars(r, map[string]string{"folder": resource.Id}))
+	case r.Method == http.MethodDelete:
+		h.handleDeleteFile(w, mux.SetURLVars(r, map[string]string{"file": resource.Id}))
+	case r.Method == http.MethodPost:
+		w.WriteHeader(http.StatusConflict)
+		io.WriteString(w, "Error: Resource is not a folder")
+	case folder:
+		h.handleFolder(w, mux.SetURLVars(r, map[string]string{"folder": resource.Id}))
+	default:
+		h.handleFile(w, mux.SetURLVars(r, map[string]string{"file": resource.Id}))
+	}
+}
@@ -0,0 +1,69 @@
+package driver
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/c4me-caro/drive"
+)
+
+func TestPath(t *testing.T) {
+	ts := newTestServer(t)
+	docs := ts.mkdir("bob", "", "docs")
+	reports := ts.mkdir("bob", docs.Id, "reports")
+	ts.file("bob", reports.Id, "a.txt", "hello")
+
+	w := ts.do("bob", "GET", "/drive/p/docs/reports/a.txt", "")
+	expectStatus(t, w, http.StatusOK)
+	expectBody(t, w, "hello")
+
+	w = ts.do("bob", "GET", "/drive/p/docs/reports", "")
+	expectStatus(t, w, http.StatusOK)
+	if got := decode[drive.Resource](t, w); got.Id != reports.Id {
+		t.Fatalf("resolved %s, want %s", got.Id, reports.Id)
+	}
+
+	expectStatus(t, ts.do("bob", "HEAD", "/drive/p/docs/reports/a.txt", ""), http.StatusOK)
+	expectStatus(t, ts.do("bob", "GET", "/drive/p/docs/missing", ""), http.StatusNotFound)
+	expectStatus(t, ts.do("bob", "GET", "/drive/p/docs/reports/a.txt/x", ""), http.StatusNotFound)
+	expectStatus(t, ts.do("", "GET", "/drive/p/docs", ""), http.StatusUnauthorized)
+}
+
+func TestPathWrites(t *testing.T) {
+	ts := newTestServer(t)
+	docs := ts.mkdir("bob", "", "docs")
+
+	w := ts.serve(ts.uploadRequest("bob", "/drive/p/docs", "a.txt", "hello"))
+	expectStatus(t, w, http.StatusOK)
+	file := decode[drive.Resource](t, w)
+	if file.Parent != docs.Id {
+		t.Fatalf("parent = %s, want %s", file.Parent, docs.Id)
+	}
+
+	expectStatus(t, ts.serve(ts.uploadRequest("bob", "/drive/p/docs", "a.txt", "again")), http.StatusConflict)
+	expectStatus(t, ts.serve(ts.uploadRequest("bob", "/drive/p/docs/a.txt", "b.txt", "hi")), http.StatusConflict)
+
+	expectStatus(t, ts.do("bob", "DELETE", "/drive/p/docs/a.txt", ""), http.StatusOK)
+	expectStatus(t, ts.do("bob", "DELETE", "/drive/p/docs", ""), http.StatusOK)
+	if !ts.resource(docs.Id).Trashed {
+		t.Fatal("folder was not moved to the trash")
+	}
+}
+
+func TestPathOfOthers(t *testing.T) {
+	ts := newTestServer(t)
+	theirs := ts.mkdir("alice", "", "docs")
+	ts.file("alice", theirs.Id, "a.txt", "alice")
+
+	// Top levels are kept apart, so bob never reaches the docs of alice,
+	// not even to learn the name is used.
+	expectStatus(t, ts.do("bob", "GET", "/drive/p/docs", ""), http.StatusNotFound)
+	expectStatus(t, ts.do("bob", "GET", "/drive/p/docs/a.txt", ""), http.StatusNotFound)
+
+	mine := ts.mkdir("bob", "", "docs")
+	w := ts.do("bob", "GET", "/drive/p/docs", "")
+	expectStatus(t, w, http.StatusOK)
+	if got := decode[drive.Resource](t, w); got.Id != mine.Id {
+		t.Fatalf("resolved %s, want %s", got.Id, mine.Id)
+	}
+}
@@ -0,0 +1,115 @@
+package driver
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/c4me-caro/drive/cmd/auth"
+	"github.com/gorilla/mux"
+)
+
+type tagsRequest struct {
+	Tags []string `json:"tags"`
+}
+
+func (h Handler) registerPolicyRoutes(router *mux.Router) {
+	router.HandleFunc("/explain/{id}", h.handleExplain).Methods("GET")
+	router.HandleFunc("/tags/{id}", h.handleTags).Methods("POST")
+}
+
+// handleExplain reports which rule allows or denies an action, read by
+// default, on a resource. Admins may ask for any user and resource, other
+// users only for themselves on what they can read.
+func (h Handler) handleExplain(w http.ResponseWriter, r *http.Request) {
+	user, err := h.validateAuthentication(r, "read")
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		io.WriteString(w, "Error: User not found or does not has valid permissions: " + err.Error())
+		return
+	}
+
+	query := r.URL.Query()
+	target := user
+	if id := query.Get("user"); id != "" && id != user.Id {
+		if user.Role != "admin" {
+			w.WriteHeader(http.StatusForbidden)
+			io.WriteString(w, "Error: Only admins can explain the access of other users")
+			return
+		}
+
+		target, err = h.db.GetUserById(id)
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, "Error: User not found: " + id)
+			return
+		}
+	}
+
+	resource, err := h.db.GetResource(mux.Vars(r)["id"])
+	if err != nil || (user.Role != "admin" && auth.FindPermission(user, "read", resource) == "") {
+		w.WriteHeader(http.StatusNotFound)
+		io.WriteString(w, "Error: Resource not found")
+		return
+	}
+
+	action := query.Get("action")
+	if action == "" {
+		action = "read"
+	}
+
+	if err := json.NewEncoder(w).Encode(auth.Explain(target, action, resource)); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(w, "Error: bad json coding")
+		return
+	}
+}
+
+// handleTags replaces the tags of a resource, which policy rules can
+// select on.
+func (h Handler) handleTags(w http.ResponseWriter, r *http.Request) {
+	user, err := h.validateAuthentication(r, "update")
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		io.WriteString(w, "Error: User not found or does not has valid permissions: " + err.Error())
+		return
+	}
+
+	resource, err := h.checkResource(mux.Vars(r)["id"], user, "update")
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		io.WriteString(w, "Error: Resource not found or user has no valid permissions: " + err.Error())
+		return
+	}
+
+	var body tagsRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		io.WriteString(w, "Error: Invalid tags")
+		return
+	}
+
+	tags := []string{}
+	for _, tag := range body.Tags {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+
+	slices.Sort(tags)
+	resource.Tags = slices.Compact(tags)
+
+	if err := h.db.UpdateResource(resource); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(w, "Error: Failed resource update")
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(resource); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(w, "Error: bad json coding")
+		return
+	}
+}
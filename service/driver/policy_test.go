@@ -0,0 +1,47 @@
+package driver
+
+import (
+	"net/http"
+	"slices"
+	"testing"
+
+	"github.com/c4me-caro/drive"
+	"github.com/c4me-caro/drive/cmd/policy"
+)
+
+func TestExplain(t *testing.T) {
+	ts := newTestServer(t)
+	docs := ts.mkdir("bob", "", "docs")
+	private := ts.mkdir("alice", "", "private")
+
+	w := ts.do("bob", "GET", "/drive/explain/"+docs.Id, "")
+	expectStatus(t, w, http.StatusOK)
+	if decision := decode[policy.Decision](t, w); !decision.Allowed || decision.Rule != "permission all:own-all" {
+		t.Fatalf("unexpected decision: %+v", decision)
+	}
+
+	w = ts.do("alice", "GET", "/drive/explain/"+private.Id+"?user=u2&action=delete", "")
+	expectStatus(t, w, http.StatusOK)
+	if decision := decode[policy.Decision](t, w); decision.Allowed {
+		t.Fatalf("unexpected decision: %+v", decision)
+	}
+
+	expectStatus(t, ts.do("bob", "GET", "/drive/explain/"+docs.Id+"?user=u1", ""), http.StatusForbidden)
+	expectStatus(t, ts.do("alice", "GET", "/drive/explain/"+docs.Id+"?user=ghost", ""), http.StatusNotFound)
+	expectStatus(t, ts.do("bob", "GET", "/drive/explain/"+private.Id, ""), http.StatusNotFound)
+	expectStatus(t, ts.do("bob", "GET", "/drive/explain/missing", ""), http.StatusNotFound)
+}
+
+func TestTags(t *testing.T) {
+	ts := newTestServer(t)
+	docs := ts.mkdir("bob", "", "docs")
+
+	w := ts.do("bob", "POST", "/drive/tags/"+docs.Id, `{"tags":["b"," a ","b",""]}`)
+	expectStatus(t, w, http.StatusOK)
+	if tags := decode[drive.Resource](t, w).Tags; !slices.Equal(tags, []string{"a", "b"}) {
+		t.Fatalf("tags = %v, want [a b]", tags)
+	}
+
+	expectStatus(t, ts.do("bob", "POST", "/drive/tags/"+docs.Id, `not json`), http.StatusBadRequest)
+	expectStatus(t, ts.do("carol", "POST", "/drive/tags/"+docs.Id, `{"tags":["a"]}`), http.StatusUnauthorized)
+}
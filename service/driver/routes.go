@@ -2,27 +2,43 @@ package driver
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
-	"os"
+	"time"
 
 	"github.com/c4me-caro/drive"
 	"github.com/c4me-caro/drive/cmd/auth"
 	"github.com/c4me-caro/drive/database"
+	"github.com/c4me-caro/drive/storage"
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 	"github.com/joho/godotenv"
 )
 
 type Handler struct {
-	db *database.DriveWorker
+	db        database.Store
+	store     storage.Backend
+	keys         *storage.Keyring
+	maxSize      int64
+	maxEntries   int
+	maxExtracted int64
+	retention    drive.Retention
 }
 
-func NewHandler(db *database.DriveWorker) *Handler {
+// NewHandler serves the drive from db and store. Files are encrypted at
+// rest when keys is not nil.
+func NewHandler(db database.Store, store storage.Backend, keys *storage.Keyring) *Handler {
 	godotenv.Load()
 	return &Handler{
-		db: db,
+		db:           db,
+		store:        store,
+		keys:         keys,
+		maxSize:      maxObjectSize(),
+		maxEntries:   maxArchiveEntries(),
+		maxExtracted: maxExtractedSize(),
+		retention:    globalRetention(),
 	}
 }
 
@@ -32,6 +48,10 @@ func (h Handler) checkResource(resname string, user drive.User, operation string
 		return drive.Resource{}, err
 	}
 
+	if resource.Trashed {
+		return drive.Resource{}, fmt.Errorf("resource not found: %s", resname)
+	}
+
 	permissions := auth.FindPermission(user, operation, resource)
 	if permissions == "" {
 		return drive.Resource{}, fmt.Errorf("user permission property not found")
@@ -65,12 +85,22 @@ func (h Handler) validateAuthentication(r *http.Request, operation string) (driv
 }
 
 func (h Handler) RegisterRoutes(router *mux.Router) {
-	router.HandleFunc("/f/{file}", h.handleFile).Methods("GET")
+	router.HandleFunc("/f/{file}", h.handleFile).Methods("GET", "HEAD")
 	router.HandleFunc("/d/{folder}", h.handleFolder).Methods("GET")
 	router.HandleFunc("/r/{file}", h.handleDeleteFile).Methods("GET")
 	router.HandleFunc("/rd/{folder}", h.handleDeleteFolder).Methods("GET")
 	router.HandleFunc("/create/{folder}", h.handleNewFolder).Methods("POST")
 	router.HandleFunc("/upload/{parent}", h.handleNewFile).Methods("POST")
+	h.registerUploadRoutes(router)
+	h.registerVersionRoutes(router)
+	h.registerTrashRoutes(router)
+	h.registerMoveRoutes(router)
+	h.registerPathRoutes(router)
+	h.registerArchiveRoutes(router)
+	h.registerLinkRoutes(router)
+	h.registerShareRoutes(router)
+	h.registerInheritRoutes(router)
+	h.registerPolicyRoutes(router)
 }
 
 func (h Handler) handleFile(w http.ResponseWriter, r *http.Request) {
@@ -95,18 +125,50 @@ func (h Handler) handleFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	filePath := resource.Location
-	fileBytes, err := os.ReadFile(filePath)
+	version := drive.Version{
+		Location: resource.Location,
+		Hash:     resource.Hash,
+		ModTime:  resource.ModTime,
+	}
+
+	if number := r.URL.Query().Get("version"); number != "" {
+		version, err = findVersion(resource, number)
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, "Error: " + err.Error())
+			return
+		}
+	}
+
+	h.serveFile(w, r, resource, version)
+}
+
+// serveFile answers with the content of version, supporting Range and
+// conditional requests.
+func (h Handler) serveFile(w http.ResponseWriter, r *http.Request, resource drive.Resource, version drive.Version) {
+	object, err := h.openObject(version.Location)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		io.WriteString(w, "Error: file not found")
 		return
 	}
 
+	defer object.Close()
+
+	modTime := version.ModTime
+	if modTime.IsZero() {
+		if info, err := h.store.Stat(version.Location); err == nil {
+			modTime = info.ModTime
+		}
+	}
+
+	if version.Hash != "" {
+		w.Header().Set("ETag", "\""+version.Hash+"\"")
+	}
+
 	w.Header().Set("Content-Disposition", "attachment; filename="+resource.Name)
 	w.Header().Set("Content-Type", "application/octet-stream")
-	w.Header().Set("Content-Length", fmt.Sprintf("%d", len(fileBytes)))
-	w.Write(fileBytes)
+	http.ServeContent(w, r, resource.Name, modTime, object)
 }
 
 func (h Handler) handleFolder(w http.ResponseWriter, r *http.Request) {
@@ -172,14 +234,15 @@ func (h Handler) handleDeleteFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = h.db.DeleteResource(resource)
+	_, err = h.trashResource(resource, user)
 	if err != nil {
+
 		w.WriteHeader(http.StatusInternalServerError)
 		io.WriteString(w, "Error: resource cannot be deleted")
 		return
 	}
 
-	io.WriteString(w, "File removed")
+	io.WriteString(w, "File moved to trash")
 }
 
 func (h Handler) handleDeleteFolder(w http.ResponseWriter, r *http.Request) {
@@ -216,34 +279,23 @@ func (h Handler) handleDeleteFolder(w http.ResponseWriter, r *http.Request) {
 	if childrens != 0 && recursive == "false" {
 		w.WriteHeader(http.StatusConflict)
 		io.WriteString(w, "Error: Folder not empty")
+		return
 	}
 
-	deletionCounter := 0
-	if recursive == "true" {
-		for _, rsx := range resource.Content {
-
-			res, err := h.checkResource(rsx, user, "delete")
-			if err != nil {
-				deletionCounter++
-				continue
-			}
-
-			err = h.db.DeleteResource(res)
-			if err != nil {
-				deletionCounter++
-				continue
-			}
+	deletionCounter, err := h.trashResource(resource, user)
+	if err != nil {
+		if errors.Is(err, errDeleteDenied) {
+			w.WriteHeader(http.StatusForbidden)
+			io.WriteString(w, "Error: resource cannot be deleted: " + err.Error())
+			return
 		}
-	}
 
-	err = h.db.DeleteResource(resource)
-	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
-		io.WriteString(w, fmt.Sprintf("Error: resource cannot be deleted. Deleted children: %d", deletionCounter))
+		io.WriteString(w, "Error: resource cannot be deleted")
 		return
 	}
 
-	io.WriteString(w, fmt.Sprintf("Folder removed. Deleted children: %d", deletionCounter))
+	io.WriteString(w, fmt.Sprintf("Folder moved to trash. Deleted children: %d", deletionCounter))
 }
 
 func (h Handler) handleNewFolder(w http.ResponseWriter, r *http.Request) {
@@ -267,8 +319,20 @@ func (h Handler) handleNewFolder(w http.ResponseWriter, r *http.Request) {
 	newUUID := uuid.New().String()
 	container := ""
 
+	if h.nameTaken(parent.Id, user.Id, folder, "") {
+		w.WriteHeader(http.StatusConflict)
+		io.WriteString(w, "Error: Name already exists in folder")
+		return
+	}
+
 	if parent.Name != "" {
 		err = h.db.CheckResource(parent)
+		if err == nil {
+			// Only id and name come from the client; permissions are
+			// checked against the stored folder.
+			parent, err = h.db.GetResource(parent.Id)
+		}
+
 		if err != nil {
 			w.WriteHeader(http.StatusUnauthorized)
 			io.WriteString(w, "Error: Resource not found or user has no valid permissions: " + err.Error())
@@ -278,14 +342,7 @@ func (h Handler) handleNewFolder(w http.ResponseWriter, r *http.Request) {
 		permissions := auth.FindPermission(user, "update", parent)
 		if permissions == "" {
 			w.WriteHeader(http.StatusUnauthorized)
-			io.WriteString(w, "Error: User has no valid permissions: " + err.Error())
-			return
-		}
-
-		err = h.db.AddResourceChildren(parent, newUUID)
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			io.WriteString(w, "Error: Failed parent update")
+			io.WriteString(w, "Error: User has no valid permissions")
 			return
 		}
 
@@ -301,11 +358,29 @@ func (h Handler) handleNewFolder(w http.ResponseWriter, r *http.Request) {
 	body.Location = container
 	body.Type = "folder"
 	body.Content = []string{}
+	body.Parent = parent.Id
+	body.Ancestors = auth.Ancestors(body, parent)
+
+	err = h.db.Transaction(func(tx database.ResourceStore) error {
+		if err := tx.CreateResource(body); err != nil {
+			return err
+		}
+
+		if parent.Name != "" {
+			return tx.AddResourceChildren(parent, newUUID)
+		}
+
+		return nil
+	})
 
-	err = h.db.CreateResource(body)
 	if err != nil {
+		if nameConflict(w, err) {
+			return
+		}
+
 		w.WriteHeader(http.StatusInternalServerError)
 		io.WriteString(w, "Error: Failed resource creation")
+		return
 	}
 
 	if err := json.NewEncoder(w).Encode(body); err != nil {
@@ -323,41 +398,59 @@ func (h Handler) handleNewFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	newUUID := uuid.New().String()
+	var container drive.Resource
 	parent := mux.Vars(r)["parent"]
 	if parent != "" {
-		resource, err := h.checkResource(parent, user, "update")
+		container, err = h.checkResource(parent, user, "update")
 		if err != nil {
 			w.WriteHeader(http.StatusUnauthorized)
 			io.WriteString(w, "Error: Resource not found or user has no valid permissions: " + err.Error())
 			return
 		}
+	}
 
-		err = h.db.AddResourceChildren(resource, newUUID)
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			io.WriteString(w, "Error: Failed parent update")
-			return
-		}
+	h.storeUpload(w, r, container, user)
+}
+
+// storeUpload creates a file from the multipart body inside container, or
+// unpacks it there when extraction is asked for.
+func (h Handler) storeUpload(w http.ResponseWriter, r *http.Request, container drive.Resource, user drive.User) {
+	newUUID := uuid.New().String()
+	parentId := container.Id
+
+	if r.ContentLength > h.maxSize+(1<<20) {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		io.WriteString(w, "Error: File exceeds maximum size")
+		return
 	}
 
-	r.ParseMultipartForm(10 << 20)
-	file, handler, err := r.FormFile("file")
+	file, err := nextFilePart(r)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		io.WriteString(w, "Error: File not found")
 		return
 	}
 
-	fileBytes, err := io.ReadAll(file)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		io.WriteString(w, "Error: Reading file")
+	defer file.Close()
+
+	if r.URL.Query().Get("extract") == "true" {
+		h.extractArchive(w, file, container, user)
+		return
+	}
+
+	if h.nameTaken(parentId, user.Id, file.FileName(), "") {
+		w.WriteHeader(http.StatusConflict)
+		io.WriteString(w, "Error: Name already exists in folder")
+		return
+	}
+
+	temp, size, blob, err := h.stageObject(file)
+	if errors.Is(err, errTooLarge) {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		io.WriteString(w, "Error: File exceeds maximum size")
 		return
 	}
 
-	fileName := fmt.Sprintf("%s_%s", newUUID, handler.Filename)
-	err = os.WriteFile(os.Getenv("FILES_ROOT")+"/"+fileName, fileBytes, 0644)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		io.WriteString(w, "Error: Writing file")
@@ -367,17 +460,55 @@ func (h Handler) handleNewFile(w http.ResponseWriter, r *http.Request) {
 	var body drive.Resource
 
 	body.Id = newUUID
-	body.Name = fileName
+	body.Name = file.FileName()
 	body.OwnerId = user.Id
 	body.SharedId = []string{}
-	body.Location = os.Getenv("FILES_ROOT") + "/" + fileName
+	body.Location = storage.BlobKey(blob.Hash)
 	body.Type = "file"
 	body.Content = []string{}
+	body.Size = size
+	body.ModTime = time.Now().UTC()
+	body.Hash = blob.Hash
+	body.Parent = parentId
+	body.Ancestors = auth.Ancestors(body, container)
+	body.Version = 1
+	body.Versions = []drive.Version{{
+		Number:   1,
+		Location: body.Location,
+		Size:     size,
+		Hash:     blob.Hash,
+		AuthorId: user.Id,
+		ModTime:  body.ModTime,
+	}}
+
+	err = h.db.Transaction(func(tx database.ResourceStore) error {
+		stored, err := h.storeBlob(tx, temp, blob)
+		if err != nil {
+			return err
+		}
 
-	err = h.db.CreateResource(body)
+		body.KeyId = stored.KeyId
+		body.Versions[0].KeyId = stored.KeyId
+		if err := tx.CreateResource(body); err != nil {
+			return err
+		}
+
+		if parentId != "" {
+			return tx.AddResourceChildren(container, newUUID)
+		}
+
+		return nil
+	})
+
+	h.discardObjects(temp)
 	if err != nil {
+		if nameConflict(w, err) {
+			return
+		}
+
 		w.WriteHeader(http.StatusInternalServerError)
 		io.WriteString(w, "Error: Failed resource creation")
+		return
 	}
 
 	if err := json.NewEncoder(w).Encode(body); err != nil {
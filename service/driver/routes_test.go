@@ -0,0 +1,309 @@
+package driver
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/c4me-caro/drive"
+	"github.com/c4me-caro/drive/cmd/auth"
+	"github.com/c4me-caro/drive/database"
+	"github.com/c4me-caro/drive/storage"
+	"github.com/gorilla/mux"
+)
+
+type testServer struct {
+	t      *testing.T
+	db     *database.MemoryStore
+	store  *storage.MemoryBackend
+	router *mux.Router
+	tokens map[string]string
+}
+
+// newTestServer serves the drive the way the API does, behind the
+// authorization middleware, from memory. alice is an admin, bob may do
+// anything with what he owns and carol may only read the drive. The drive
+// is an ordinary folder here, so those permissions decide what each of
+// them may do in it.
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+
+	db := database.NewMemoryStore()
+	if err := db.CreateResource(drive.Resource{Id: "sys", Name: "drive", Type: "folder", SharedId: []string{}, Content: []string{}}); err != nil {
+		t.Fatal(err)
+	}
+
+	users := []drive.User{
+		{Id: "u1", Name: "alice", Role: "admin", Password: "pw", Permissions: []string{"all:all"}},
+		{Id: "u2", Name: "bob", Password: "pw", Permissions: []string{"all:drive", "all:own-all"}},
+		{Id: "u3", Name: "carol", Password: "pw", Permissions: []string{"read:drive"}},
+	}
+
+	tokens := make(map[string]string)
+	for _, user := range users {
+		if err := db.CreateUser(user); err != nil {
+			t.Fatal(err)
+		}
+
+		issued, err := auth.CreateJWT(user.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		tokens[user.Name] = issued.AccessToken
+	}
+
+	auth.UseGrants(db)
+	if err := auth.UseRevocations(db); err != nil {
+		t.Fatal(err)
+	}
+
+	store := storage.NewMemoryBackend()
+	handler := NewHandler(db, store, nil)
+
+	router := mux.NewRouter().StrictSlash(true)
+	handler.RegisterRoutes(router.PathPrefix("/drive").Subrouter())
+	handler.RegisterShareRoutes(router)
+	router.Use(auth.HandleAuthorization)
+
+	return &testServer{t: t, db: db, store: store, router: router, tokens: tokens}
+}
+
+// request builds a request made by user, who is left anonymous when empty.
+func (ts *testServer) request(user string, method string, path string, body io.Reader) *http.Request {
+	r := httptest.NewRequest(method, path, body)
+	if user != "" {
+		r.Header.Set("Authorization", ts.tokens[user])
+	}
+
+	return r
+}
+
+func (ts *testServer) serve(r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	ts.router.ServeHTTP(w, r)
+	return w
+}
+
+func (ts *testServer) do(user string, method string, path string, body string) *httptest.ResponseRecorder {
+	return ts.serve(ts.request(user, method, path, strings.NewReader(body)))
+}
+
+// uploadRequest builds a multipart upload of content as name to path.
+func (ts *testServer) uploadRequest(user string, path string, name string, content string) *http.Request {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("file", name)
+	if err != nil {
+		ts.t.Fatal(err)
+	}
+
+	io.WriteString(part, content)
+	form.Close()
+
+	r := ts.request(user, "POST", path, &body)
+	r.Header.Set("Content-Type", form.FormDataContentType())
+	return r
+}
+
+func (ts *testServer) upload(user string, parent string, name string, content string) *httptest.ResponseRecorder {
+	return ts.serve(ts.uploadRequest(user, "/drive/upload/"+parent, name, content))
+}
+
+// mkdir creates a folder of user in parent, or at the top level of user
+// when parent is empty.
+func (ts *testServer) mkdir(user string, parent string, name string) drive.Resource {
+	ts.t.Helper()
+
+	body := "{}"
+	if parent != "" {
+		folder, err := ts.db.GetResource(parent)
+		if err != nil {
+			ts.t.Fatal(err)
+		}
+
+		body = `{"id":"` + folder.Id + `","name":"` + folder.Name + `"}`
+	}
+
+	w := ts.do(user, "POST", "/drive/create/"+name, body)
+	expectStatus(ts.t, w, http.StatusOK)
+	return decode[drive.Resource](ts.t, w)
+}
+
+// file uploads a file of user into parent.
+func (ts *testServer) file(user string, parent string, name string, content string) drive.Resource {
+	ts.t.Helper()
+
+	w := ts.upload(user, parent, name, content)
+	expectStatus(ts.t, w, http.StatusOK)
+	return decode[drive.Resource](ts.t, w)
+}
+
+func (ts *testServer) resource(id string) drive.Resource {
+	ts.t.Helper()
+
+	resource, err := ts.db.GetResource(id)
+	if err != nil {
+		ts.t.Fatal(err)
+	}
+
+	return resource
+}
+
+func expectStatus(t *testing.T, w *httptest.ResponseRecorder, code int) {
+	t.Helper()
+
+	if w.Code != code {
+		t.Fatalf("status = %d, want %d: %s", w.Code, code, w.Body)
+	}
+}
+
+func expectBody(t *testing.T, w *httptest.ResponseRecorder, body string) {
+	t.Helper()
+
+	if w.Body.String() != body {
+		t.Fatalf("body = %q, want %q", w.Body, body)
+	}
+}
+
+func decode[T any](t *testing.T, w *httptest.ResponseRecorder) T {
+	t.Helper()
+
+	var value T
+	if err := json.NewDecoder(w.Body).Decode(&value); err != nil {
+		t.Fatalf("decoding %q: %v", w.Body, err)
+	}
+
+	return value
+}
+
+func TestAuthorizationRequired(t *testing.T) {
+	ts := newTestServer(t)
+	folder := ts.mkdir("bob", "", "docs")
+
+	expectStatus(t, ts.do("", "GET", "/drive/d/"+folder.Id, ""), http.StatusUnauthorized)
+
+	r := ts.request("", "GET", "/drive/d/"+folder.Id, nil)
+	r.Header.Set("Authorization", "not a token")
+	expectStatus(t, ts.serve(r), http.StatusUnauthorized)
+
+	// Revoked tokens are turned away by the middleware.
+	if err := auth.RevokeUserSessions("u2"); err != nil {
+		t.Fatal(err)
+	}
+
+	w := ts.do("bob", "GET", "/drive/d/"+folder.Id, "")
+	expectStatus(t, w, http.StatusUnauthorized)
+	expectBody(t, w, "Error: Token has been revoked")
+}
+
+func TestFolder(t *testing.T) {
+	ts := newTestServer(t)
+	folder := ts.mkdir("bob", "", "docs")
+	file := ts.file("bob", folder.Id, "a.txt", "hello")
+
+	w := ts.do("bob", "GET", "/drive/d/"+folder.Id, "")
+	expectStatus(t, w, http.StatusOK)
+	if got := decode[drive.Resource](t, w); len(got.Content) != 1 || got.Content[0] != file.Id {
+		t.Fatalf("content = %v, want [%s]", got.Content, file.Id)
+	}
+
+	expectStatus(t, ts.do("bob", "GET", "/drive/d/"+file.Id, ""), http.StatusConflict)
+	expectStatus(t, ts.do("bob", "GET", "/drive/d/missing", ""), http.StatusUnauthorized)
+	expectStatus(t, ts.do("carol", "GET", "/drive/d/"+folder.Id, ""), http.StatusUnauthorized)
+	expectStatus(t, ts.do("alice", "GET", "/drive/d/"+folder.Id, ""), http.StatusOK)
+}
+
+func TestFile(t *testing.T) {
+	ts := newTestServer(t)
+	folder := ts.mkdir("bob", "", "docs")
+	file := ts.file("bob", folder.Id, "a.txt", "hello world")
+
+	w := ts.do("bob", "GET", "/drive/f/"+file.Id, "")
+	expectStatus(t, w, http.StatusOK)
+	expectBody(t, w, "hello world")
+
+	etag := w.Header().Get("ETag")
+	if etag != `"`+file.Hash+`"` {
+		t.Fatalf("ETag = %s, want the hash %s", etag, file.Hash)
+	}
+
+	r := ts.request("bob", "GET", "/drive/f/"+file.Id, nil)
+	r.Header.Set("Range", "bytes=0-4")
+	w = ts.serve(r)
+	expectStatus(t, w, http.StatusPartialContent)
+	expectBody(t, w, "hello")
+
+	r = ts.request("bob", "GET", "/drive/f/"+file.Id, nil)
+	r.Header.Set("If-None-Match", etag)
+	expectStatus(t, ts.serve(r), http.StatusNotModified)
+
+	w = ts.do("bob", "HEAD", "/drive/f/"+file.Id, "")
+	expectStatus(t, w, http.StatusOK)
+	if length := w.Header().Get("Content-Length"); length != "11" {
+		t.Fatalf("Content-Length = %s, want 11", length)
+	}
+
+	expectStatus(t, ts.do("bob", "GET", "/drive/f/"+file.Id+"?version=9", ""), http.StatusNotFound)
+	expectStatus(t, ts.do("bob", "GET", "/drive/f/missing", ""), http.StatusUnauthorized)
+	expectStatus(t, ts.do("carol", "GET", "/drive/f/"+file.Id, ""), http.StatusUnauthorized)
+}
+
+func TestNewFolder(t *testing.T) {
+	ts := newTestServer(t)
+	folder := ts.mkdir("bob", "", "docs")
+	child := ts.mkdir("bob", folder.Id, "reports")
+
+	if child.Parent != folder.Id || child.OwnerId != "u2" {
+		t.Fatalf("unexpected folder: %+v", child)
+	}
+
+	expectStatus(t, ts.do("bob", "POST", "/drive/create/docs", "{}"), http.StatusConflict)
+	expectStatus(t, ts.do("bob", "POST", "/drive/create/reports", `{"id":"`+folder.Id+`","name":"docs"}`), http.StatusConflict)
+
+	// Every user has a top level of their own.
+	ts.mkdir("alice", "", "docs")
+
+	expectStatus(t, ts.do("bob", "POST", "/drive/create/x", `{"id":"`+folder.Id+`","name":"other"}`), http.StatusUnauthorized)
+	expectStatus(t, ts.do("carol", "POST", "/drive/create/x", "{}"), http.StatusUnauthorized)
+
+	theirs := ts.mkdir("alice", "", "private")
+	expectStatus(t, ts.do("bob", "POST", "/drive/create/x", `{"id":"`+theirs.Id+`","name":"private"}`), http.StatusUnauthorized)
+}
+
+func TestNewFile(t *testing.T) {
+	t.Setenv("MAX_OBJECT_SIZE", "16")
+
+	ts := newTestServer(t)
+	folder := ts.mkdir("bob", "", "docs")
+	file := ts.file("bob", folder.Id, "a.txt", "hello")
+
+	if file.Parent != folder.Id || file.Size != 5 || file.Version != 1 {
+		t.Fatalf("unexpected file: %+v", file)
+	}
+
+	if got := ts.resource(folder.Id).Content; len(got) != 1 || got[0] != file.Id {
+		t.Fatalf("content = %v, want [%s]", got, file.Id)
+	}
+
+	expectStatus(t, ts.upload("bob", folder.Id, "a.txt", "again"), http.StatusConflict)
+	expectStatus(t, ts.upload("bob", folder.Id, "big.bin", strings.Repeat("x", 17)), http.StatusRequestEntityTooLarge)
+	expectStatus(t, ts.upload("carol", folder.Id, "b.txt", "hi"), http.StatusUnauthorized)
+	expectStatus(t, ts.upload("bob", "missing", "b.txt", "hi"), http.StatusUnauthorized)
+
+	// Identical content is stored once.
+	copy := ts.file("bob", folder.Id, "b.txt", "hello")
+	if copy.Location != file.Location {
+		t.Fatalf("location = %s, want the shared blob %s", copy.Location, file.Location)
+	}
+
+	blob, err := ts.db.GetBlob(file.Hash)
+	if err != nil || blob.Refs != 2 {
+		t.Fatalf("blob = %+v, %v, want 2 references", blob, err)
+	}
+}
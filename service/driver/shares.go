@@ -0,0 +1,228 @@
+package driver
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/c4me-caro/drive"
+	"github.com/c4me-caro/drive/cmd/auth"
+	"github.com/gorilla/mux"
+)
+
+// grantRequest names either a user or a group.
+type grantRequest struct {
+	User  string `json:"user"`
+	Group string `json:"group"`
+	Role  string `json:"role"`
+}
+
+type accessList struct {
+	ResourceId string        `json:"resourceId"`
+	OwnerId    string        `json:"ownerId"`
+	Grants     []drive.Grant `json:"grants"`
+	Inherited  []drive.Grant `json:"inherited"`
+}
+
+func (h Handler) registerShareRoutes(router *mux.Router) {
+	router.HandleFunc("/shares/{id}", h.handleShares).Methods("GET")
+	router.HandleFunc("/shares/{id}", h.handleGrant).Methods("POST")
+	router.HandleFunc("/shares/{id}/{user}", h.handleRevokeGrant).Methods("DELETE")
+	router.HandleFunc("/shares/{id}/groups/{group}", h.handleRevokeGroupGrant).Methods("DELETE")
+}
+
+func (h Handler) handleShares(w http.ResponseWriter, r *http.Request) {
+	user, err := h.validateAuthentication(r, "read")
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		io.WriteString(w, "Error: User not found or does not has valid permissions: " + err.Error())
+		return
+	}
+
+	resource, err := h.checkResource(mux.Vars(r)["id"], user, "read")
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		io.WriteString(w, "Error: Resource not found or user has no valid permissions: " + err.Error())
+		return
+	}
+
+	grants, err := h.db.ListGrants(resource.Id)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(w, "Error: Failed listing grants")
+		return
+	}
+
+	inherited := []drive.Grant{}
+	if len(resource.Ancestors) > 0 {
+		inherited, err = h.db.ListGrants(resource.Ancestors...)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			io.WriteString(w, "Error: Failed listing grants")
+			return
+		}
+	}
+
+	list := accessList{
+		ResourceId: resource.Id,
+		OwnerId:    resource.OwnerId,
+		Grants:     grants,
+		Inherited:  inherited,
+	}
+
+	if err := json.NewEncoder(w).Encode(list); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(w, "Error: bad json coding")
+		return
+	}
+}
+
+// handleGrant gives a user or a group a role on the resource, replacing
+// the role it had.
+func (h Handler) handleGrant(w http.ResponseWriter, r *http.Request) {
+	user, err := h.validateAuthentication(r, "read")
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		io.WriteString(w, "Error: User not found or does not has valid permissions: " + err.Error())
+		return
+	}
+
+	resource, err := h.checkResource(mux.Vars(r)["id"], user, "read")
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		io.WriteString(w, "Error: Resource not found or user has no valid permissions: " + err.Error())
+		return
+	}
+
+	if resource.OwnerId != user.Id {
+		w.WriteHeader(http.StatusForbidden)
+		io.WriteString(w, "Error: Only the owner can share the resource")
+		return
+	}
+
+	var body grantRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || !auth.ValidRole(body.Role) {
+		w.WriteHeader(http.StatusBadRequest)
+		io.WriteString(w, "Error: Invalid grant, role must be viewer, commenter or editor")
+		return
+	}
+
+	if (body.User == "") == (body.Group == "") {
+		w.WriteHeader(http.StatusBadRequest)
+		io.WriteString(w, "Error: Invalid grant, name either a user or a group")
+		return
+	}
+
+	if body.User != "" && body.User == resource.OwnerId {
+		w.WriteHeader(http.StatusConflict)
+		io.WriteString(w, "Error: The owner already has full access")
+		return
+	}
+
+	if body.User != "" {
+		if _, err := h.db.GetUserById(body.User); err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, "Error: User not found: " + body.User)
+			return
+		}
+	}
+
+	if body.Group != "" {
+		if _, err := h.db.GetGroup(body.Group); err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, "Error: Group not found: " + body.Group)
+			return
+		}
+	}
+
+	grant := drive.Grant{
+		ResourceId: resource.Id,
+		UserId:     body.User,
+		GroupId:    body.Group,
+		Role:       body.Role,
+		GrantedBy:  user.Id,
+		GrantedAt:  time.Now().UTC(),
+	}
+
+	if err := h.db.SaveGrant(grant); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(w, "Error: Failed grant creation")
+		return
+	}
+
+	auth.InvalidateGrants(resource.Id)
+
+	if err := json.NewEncoder(w).Encode(grant); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(w, "Error: bad json coding")
+		return
+	}
+}
+
+// handleRevokeGrant removes the access of a user. Besides the owner, users
+// may drop their own access.
+func (h Handler) handleRevokeGrant(w http.ResponseWriter, r *http.Request) {
+	user, err := h.validateAuthentication(r, "read")
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		io.WriteString(w, "Error: User not found or does not has valid permissions: " + err.Error())
+		return
+	}
+
+	resource, err := h.checkResource(mux.Vars(r)["id"], user, "read")
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		io.WriteString(w, "Error: Resource not found or user has no valid permissions: " + err.Error())
+		return
+	}
+
+	grantee := mux.Vars(r)["user"]
+	if resource.OwnerId != user.Id && grantee != user.Id {
+		w.WriteHeader(http.StatusForbidden)
+		io.WriteString(w, "Error: Only the owner can revoke access")
+		return
+	}
+
+	if err := h.db.DeleteGrant(resource.Id, grantee, ""); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(w, "Error: Failed grant deletion")
+		return
+	}
+
+	auth.InvalidateGrants(resource.Id)
+
+	io.WriteString(w, "Access revoked")
+}
+
+func (h Handler) handleRevokeGroupGrant(w http.ResponseWriter, r *http.Request) {
+	user, err := h.validateAuthentication(r, "read")
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		io.WriteString(w, "Error: User not found or does not has valid permissions: " + err.Error())
+		return
+	}
+
+	resource, err := h.checkResource(mux.Vars(r)["id"], user, "read")
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		io.WriteString(w, "Error: Resource not found or user has no valid permissions: " + err.Error())
+		return
+	}
+
+	if resource.OwnerId != user.Id {
+		w.WriteHeader(http.StatusForbidden)
+		io.WriteString(w, "Error: Only the owner can revoke access")
+		return
+	}
+
+	if err := h.db.DeleteGrant(resource.Id, "", mux.Vars(r)["group"]); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(w, "Error: Failed grant deletion")
+		return
+	}
+
+	auth.InvalidateGrants(resource.Id)
+
+	io.WriteString(w, "Access revoked")
+}
@@ -0,0 +1,98 @@
+package driver
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/c4me-caro/drive"
+)
+
+func TestGrant(t *testing.T) {
+	ts := newTestServer(t)
+	team := ts.mkdir("alice", "", "team")
+	file := ts.file("alice", team.Id, "a.txt", "hello")
+
+	expectStatus(t, ts.do("bob", "GET", "/drive/f/"+file.Id, ""), http.StatusUnauthorized)
+
+	w := ts.do("alice", "POST", "/drive/shares/"+team.Id, `{"user":"u2","role":"viewer"}`)
+	expectStatus(t, w, http.StatusOK)
+	if grant := decode[drive.Grant](t, w); grant.UserId != "u2" || grant.Role != "viewer" || grant.GrantedBy != "u1" {
+		t.Fatalf("unexpected grant: %+v", grant)
+	}
+
+	// Grants on a folder reach what is inside.
+	expectBody(t, ts.do("bob", "GET", "/drive/f/"+file.Id, ""), "hello")
+	expectStatus(t, ts.do("bob", "POST", "/drive/rename/"+file.Id, `{"name":"b.txt"}`), http.StatusUnauthorized)
+
+	expectStatus(t, ts.do("alice", "POST", "/drive/shares/"+team.Id, `{"user":"u2","role":"editor"}`), http.StatusOK)
+	expectStatus(t, ts.do("bob", "POST", "/drive/rename/"+file.Id, `{"name":"b.txt"}`), http.StatusOK)
+}
+
+func TestGrantFailures(t *testing.T) {
+	ts := newTestServer(t)
+	team := ts.mkdir("alice", "", "team")
+	expectStatus(t, ts.do("alice", "POST", "/drive/shares/"+team.Id, `{"user":"u2","role":"viewer"}`), http.StatusOK)
+
+	expectStatus(t, ts.do("alice", "POST", "/drive/shares/"+team.Id, `{"user":"u2","role":"owner"}`), http.StatusBadRequest)
+	expectStatus(t, ts.do("alice", "POST", "/drive/shares/"+team.Id, `{"role":"viewer"}`), http.StatusBadRequest)
+	expectStatus(t, ts.do("alice", "POST", "/drive/shares/"+team.Id, `{"user":"u2","group":"g","role":"viewer"}`), http.StatusBadRequest)
+	expectStatus(t, ts.do("alice", "POST", "/drive/shares/"+team.Id, `{"user":"u1","role":"viewer"}`), http.StatusConflict)
+	expectStatus(t, ts.do("alice", "POST", "/drive/shares/"+team.Id, `{"user":"ghost","role":"viewer"}`), http.StatusNotFound)
+	expectStatus(t, ts.do("alice", "POST", "/drive/shares/"+team.Id, `{"group":"ghost","role":"viewer"}`), http.StatusNotFound)
+	expectStatus(t, ts.do("bob", "POST", "/drive/shares/"+team.Id, `{"user":"u3","role":"viewer"}`), http.StatusForbidden)
+	expectStatus(t, ts.do("carol", "POST", "/drive/shares/"+team.Id, `{"user":"u3","role":"viewer"}`), http.StatusUnauthorized)
+}
+
+func TestGroupGrant(t *testing.T) {
+	ts := newTestServer(t)
+	team := ts.mkdir("alice", "", "team")
+	group := drive.Group{Id: "g1", Name: "editors", OwnerId: "u1", Members: []string{"u1", "u2"}, Permissions: []string{}}
+	if err := ts.db.CreateGroup(group); err != nil {
+		t.Fatal(err)
+	}
+
+	expectStatus(t, ts.do("alice", "POST", "/drive/shares/"+team.Id, `{"group":"g1","role":"viewer"}`), http.StatusOK)
+	expectStatus(t, ts.do("bob", "GET", "/drive/d/"+team.Id, ""), http.StatusOK)
+
+	expectStatus(t, ts.do("bob", "DELETE", "/drive/shares/"+team.Id+"/groups/g1", ""), http.StatusForbidden)
+	expectStatus(t, ts.do("alice", "DELETE", "/drive/shares/"+team.Id+"/groups/g1", ""), http.StatusOK)
+	expectStatus(t, ts.do("bob", "GET", "/drive/d/"+team.Id, ""), http.StatusUnauthorized)
+}
+
+func TestShares(t *testing.T) {
+	ts := newTestServer(t)
+	team := ts.mkdir("alice", "", "team")
+	reports := ts.mkdir("alice", team.Id, "reports")
+	expectStatus(t, ts.do("alice", "POST", "/drive/shares/"+team.Id, `{"user":"u2","role":"viewer"}`), http.StatusOK)
+	expectStatus(t, ts.do("alice", "POST", "/drive/shares/"+reports.Id, `{"user":"u3","role":"viewer"}`), http.StatusOK)
+
+	w := ts.do("alice", "GET", "/drive/shares/"+reports.Id, "")
+	expectStatus(t, w, http.StatusOK)
+
+	list := decode[accessList](t, w)
+	if list.OwnerId != "u1" || len(list.Grants) != 1 || list.Grants[0].UserId != "u3" || len(list.Inherited) != 1 || list.Inherited[0].UserId != "u2" {
+		t.Fatalf("unexpected access list: %+v", list)
+	}
+
+	expectStatus(t, ts.do("bob", "GET", "/drive/shares/"+reports.Id, ""), http.StatusOK)
+
+	private := ts.mkdir("alice", "", "private")
+	expectStatus(t, ts.do("bob", "GET", "/drive/shares/"+private.Id, ""), http.StatusUnauthorized)
+}
+
+func TestRevokeGrant(t *testing.T) {
+	ts := newTestServer(t)
+	team := ts.mkdir("alice", "", "team")
+	expectStatus(t, ts.do("alice", "POST", "/drive/shares/"+team.Id, `{"user":"u2","role":"viewer"}`), http.StatusOK)
+	expectStatus(t, ts.do("alice", "POST", "/drive/shares/"+team.Id, `{"user":"u3","role":"viewer"}`), http.StatusOK)
+
+	// Users may only drop their own access.
+	expectStatus(t, ts.do("bob", "DELETE", "/drive/shares/"+team.Id+"/u3", ""), http.StatusForbidden)
+	expectStatus(t, ts.do("bob", "DELETE", "/drive/shares/"+team.Id+"/u2", ""), http.StatusOK)
+	expectStatus(t, ts.do("bob", "GET", "/drive/d/"+team.Id, ""), http.StatusUnauthorized)
+
+	expectStatus(t, ts.do("alice", "DELETE", "/drive/shares/"+team.Id+"/u3", ""), http.StatusOK)
+	if grants, _ := ts.db.ListGrants(team.Id); len(grants) != 0 {
+		t.Fatalf("grants = %+v, want none", grants)
+	}
+}
@@ -0,0 +1,202 @@
+package driver
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/c4me-caro/drive"
+	"github.com/c4me-caro/drive/storage"
+	"github.com/google/uuid"
+)
+
+const defaultMaxObjectSize = 5 << 30
+
+const tempPrefix = "tmp/"
+
+var errTooLarge = fmt.Errorf("object exceeds maximum size")
+
+type limitedReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+// Read fails with errTooLarge as soon as the source yields more than the
+// allowed amount. The overflowing bytes are never handed to the caller, so
+// backends either drop the object or keep exactly the allowed prefix.
+func (lr *limitedReader) Read(p []byte) (int, error) {
+	if lr.remaining < 0 {
+		return 0, errTooLarge
+	}
+
+	if int64(len(p)) > lr.remaining+1 {
+		p = p[:lr.remaining+1]
+	}
+
+	n, err := lr.r.Read(p)
+	if int64(n) > lr.remaining {
+		n = int(lr.remaining)
+		lr.remaining = -1
+		return n, errTooLarge
+	}
+
+	lr.remaining -= int64(n)
+	return n, err
+}
+
+func maxObjectSize() int64 {
+	size, err := strconv.ParseInt(os.Getenv("MAX_OBJECT_SIZE"), 10, 64)
+	if err != nil || size <= 0 {
+		return defaultMaxObjectSize
+	}
+
+	return size
+}
+
+func (h Handler) limitReader(r io.Reader) io.Reader {
+	return &limitedReader{r: r, remaining: h.maxSize}
+}
+
+type byteCounter int64
+
+func (bc *byteCounter) Write(p []byte) (int, error) {
+	*bc += byteCounter(len(p))
+	return len(p), nil
+}
+
+// stageObject streams r into a temporary key, enforcing the maximum object
+// size and encrypting it under a new data key when a master key is set. It
+// returns the temporary key, the plaintext size and the blob describing the
+// content; commitObject moves it to its final key once metadata is saved.
+func (h Handler) stageObject(r io.Reader) (string, int64, drive.Blob, error) {
+	temp := tempPrefix + uuid.New().String()
+	hash := sha256.New()
+	var size byteCounter
+	var content io.Reader = io.TeeReader(h.limitReader(r), io.MultiWriter(hash, &size))
+
+	blob := drive.Blob{}
+	if h.keys != nil {
+		dataKey, err := storage.NewDataKey()
+		if err != nil {
+			return "", 0, drive.Blob{}, err
+		}
+
+		blob.KeyId, blob.DataKey, err = h.keys.Wrap(dataKey)
+		if err != nil {
+			return "", 0, drive.Blob{}, err
+		}
+
+		content, err = storage.Encrypt(content, dataKey)
+		if err != nil {
+			return "", 0, drive.Blob{}, err
+		}
+	}
+
+	_, err := h.store.Put(temp, content)
+	if err != nil {
+		h.store.Delete(temp)
+		return "", int64(size), drive.Blob{}, err
+	}
+
+	blob.Hash = hex.EncodeToString(hash.Sum(nil))
+	return temp, int64(size), blob, nil
+}
+
+// openObject reads the content stored at location, decrypting it when its
+// blob was stored encrypted.
+func (h Handler) openObject(location string) (io.ReadSeekCloser, error) {
+	object, err := h.store.Get(location)
+	if err != nil {
+		return nil, err
+	}
+
+	hash, ok := storage.BlobHash(location)
+	if !ok {
+		return object, nil
+	}
+
+	blob, err := h.db.GetBlob(hash)
+	if err != nil {
+		object.Close()
+		return nil, err
+	}
+
+	if blob.KeyId == "" {
+		return object, nil
+	}
+
+	if h.keys == nil {
+		object.Close()
+		return nil, fmt.Errorf("no master key configured for encrypted blob: %s", hash)
+	}
+
+	dataKey, err := h.keys.Unwrap(blob.KeyId, blob.DataKey)
+	if err != nil {
+		object.Close()
+		return nil, err
+	}
+
+	info, err := h.store.Stat(location)
+	if err != nil {
+		object.Close()
+		return nil, err
+	}
+
+	decrypted, err := storage.NewDecryptingReader(object, dataKey, info.Size)
+	if err != nil {
+		object.Close()
+		return nil, err
+	}
+
+	return decrypted, nil
+}
+
+// commitObject is safe to repeat, since database transactions may retry
+// the step that calls it.
+func (h Handler) commitObject(temp string, key string) error {
+	err := h.store.Rename(temp, key)
+	if err != nil {
+		if _, serr := h.store.Stat(temp); serr != nil {
+			if _, kerr := h.store.Stat(key); kerr == nil {
+				return nil
+			}
+		}
+	}
+
+	return err
+}
+
+// discardObjects removes bytes nobody points to anymore, such as staged
+// content of a duplicate or failed upload. Missing keys are ignored.
+func (h Handler) discardObjects(keys ...string) {
+	for _, key := range keys {
+		h.store.Delete(key)
+	}
+}
+
+// nextFilePart walks the multipart body until it finds the "file" field,
+// leaving the part unread so it can be streamed into the backend.
+func nextFilePart(r *http.Request) (*multipart.Part, error) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			return nil, err
+		}
+
+		if part.FormName() == "file" && part.FileName() != "" {
+			return part, nil
+		}
+
+		part.Close()
+	}
+}
@@ -0,0 +1,332 @@
+package driver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/c4me-caro/drive"
+	"github.com/c4me-caro/drive/cmd/auth"
+	"github.com/c4me-caro/drive/database"
+	"github.com/gorilla/mux"
+)
+
+const defaultTrashDays = 30
+
+// errDeleteDenied is returned when a folder holds something its trasher
+// may not delete.
+var errDeleteDenied = errors.New("no delete permission")
+
+func (h Handler) registerTrashRoutes(router *mux.Router) {
+	router.HandleFunc("/trash", h.handleTrash).Methods("GET")
+	router.HandleFunc("/trash", h.handleEmptyTrash).Methods("DELETE")
+	router.HandleFunc("/trash/{id}/restore", h.handleRestoreTrash).Methods("POST")
+	router.HandleFunc("/trash/{id}", h.handlePurgeTrash).Methods("DELETE")
+}
+
+func trashMaxAge() time.Duration {
+	days, err := strconv.Atoi(os.Getenv("TRASH_DAYS"))
+	if err != nil || days <= 0 {
+		days = defaultTrashDays
+	}
+
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// collectTree returns resource followed by every descendant reachable
+// through its Content. Dangling children are skipped.
+func (h Handler) collectTree(resource drive.Resource) []drive.Resource {
+	tree := []drive.Resource{resource}
+	seen := map[string]struct{}{resource.Id: {}}
+
+	for i := 0; i < len(tree); i++ {
+		for _, id := range tree[i].Content {
+			if _, ok := seen[id]; ok {
+				continue
+			}
+
+			child, err := h.db.GetResource(id)
+			if err != nil {
+				continue
+			}
+
+			seen[id] = struct{}{}
+			tree = append(tree, child)
+		}
+	}
+
+	return tree
+}
+
+// trashResource moves resource and its subtree into the trash of user. The
+// resource keeps its Parent so it can be restored in place later. Nothing
+// is trashed unless user may delete every resource of the subtree, since
+// the trash lets its owner purge them.
+func (h Handler) trashResource(resource drive.Resource, user drive.User) (int, error) {
+	now := time.Now().UTC()
+	tree := h.collectTree(resource)
+	parent, linked := h.parentOf(resource)
+
+	for _, item := range tree[1:] {
+		if auth.FindPermission(user, "delete", item) == "" {
+			return 0, fmt.Errorf("%w: %s", errDeleteDenied, item.Name)
+		}
+	}
+
+	err := h.db.Transaction(func(tx database.ResourceStore) error {
+		for _, item := range tree {
+			item.Trashed = true
+			item.TrashRoot = resource.Id
+			item.TrashedBy = user.Id
+			item.TrashedAt = now
+
+			if err := tx.UpdateResource(item); err != nil {
+				return err
+			}
+		}
+
+		if linked {
+			return tx.RemoveResourceChildren(parent, resource.Id)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	return len(tree) - 1, nil
+}
+
+func (h Handler) restoreResource(root drive.Resource) error {
+	tree, err := h.db.ListTrashTree(root.Id)
+	if err != nil {
+		return err
+	}
+
+	parent := drive.Resource{}
+	if root.Parent != "" {
+		parent, err = h.db.GetResource(root.Parent)
+		if err != nil || parent.Trashed {
+			parent = drive.Resource{}
+		}
+	}
+
+	if h.nameTaken(parent.Id, root.OwnerId, root.Name, root.Id) {
+		return fmt.Errorf("%w: %s", database.ErrNameTaken, root.Name)
+	}
+
+	for i := range tree {
+		if tree[i].Id == root.Id && parent.Id == "" {
+			tree[i].Parent = ""
+			if tree[i].Type == "folder" {
+				tree[i].Location = ""
+			}
+		}
+	}
+
+	setAncestors(tree, root.Id, parent)
+
+	return h.db.Transaction(func(tx database.ResourceStore) error {
+		for _, item := range tree {
+			item.Trashed = false
+			item.TrashRoot = ""
+			item.TrashedBy = ""
+			item.TrashedAt = time.Time{}
+
+			if err := tx.UpdateResource(item); err != nil {
+				return err
+			}
+		}
+
+		if parent.Id != "" {
+			return tx.AddResourceChildren(parent, root.Id)
+		}
+
+		return nil
+	})
+}
+
+// purgeResource permanently deletes a trashed subtree along with the bytes
+// of every version of its files no other resource points to. Bytes are only
+// removed once the metadata is gone, so a failed purge never leaves
+// resources without content.
+func (h Handler) purgeResource(root drive.Resource) error {
+	tree, err := h.db.ListTrashTree(root.Id)
+	if err != nil {
+		return err
+	}
+
+	var released []string
+	err = h.db.Transaction(func(tx database.ResourceStore) error {
+		released = []string{}
+		for _, item := range tree {
+			// A resource already purged, by a concurrent purge or an
+			// earlier attempt of this transaction, has had its blobs
+			// released.
+			err := tx.DeleteResource(item)
+			if errors.Is(err, database.ErrResourceNotFound) {
+				continue
+			}
+
+			if err != nil {
+				return err
+			}
+
+			unused, err := releaseBlobs(tx, blobLocations(versionsOf(item)))
+			if err != nil {
+				return err
+			}
+
+			released = append(released, unused...)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	h.discardObjects(released...)
+	return nil
+}
+
+// StartTrashExpiry purges trash older than TRASH_DAYS in the background,
+// checking once per interval.
+func (h Handler) StartTrashExpiry(interval time.Duration) {
+	maxAge := trashMaxAge()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for ; ; <-ticker.C {
+			expired, err := h.db.ListExpiredTrash(time.Now().Add(-maxAge))
+			if err != nil {
+				fmt.Println("trash expiry:", err)
+				continue
+			}
+
+			for _, resource := range expired {
+				if err := h.purgeResource(resource); err != nil {
+					fmt.Println("trash expiry:", err)
+				}
+			}
+		}
+	}()
+}
+
+func (h Handler) getTrashed(w http.ResponseWriter, r *http.Request, user drive.User) (drive.Resource, bool) {
+	resource, err := h.db.GetResource(mux.Vars(r)["id"])
+	if err != nil || !resource.Trashed || resource.TrashRoot != resource.Id || resource.TrashedBy != user.Id {
+		w.WriteHeader(http.StatusNotFound)
+		io.WriteString(w, "Error: Resource not found in trash")
+		return drive.Resource{}, false
+	}
+
+	return resource, true
+}
+
+func (h Handler) handleTrash(w http.ResponseWriter, r *http.Request) {
+	user, err := h.validateAuthentication(r, "read")
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		io.WriteString(w, "Error: User not found or does not has valid permissions: " + err.Error())
+		return
+	}
+
+	trash, err := h.db.ListTrash(user.Id)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(w, "Error: Failed trash listing")
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(trash); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(w, "Error: bad json coding")
+		return
+	}
+}
+
+func (h Handler) handleRestoreTrash(w http.ResponseWriter, r *http.Request) {
+	user, err := h.validateAuthentication(r, "delete")
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		io.WriteString(w, "Error: User not found or does not has valid permissions: " + err.Error())
+		return
+	}
+
+	resource, ok := h.getTrashed(w, r, user)
+	if !ok {
+		return
+	}
+
+	err = h.restoreResource(resource)
+	if err != nil {
+		if nameConflict(w, err) {
+			return
+		}
+
+		w.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(w, "Error: resource cannot be restored: " + err.Error())
+		return
+	}
+
+	io.WriteString(w, "Resource restored")
+}
+
+func (h Handler) handlePurgeTrash(w http.ResponseWriter, r *http.Request) {
+	user, err := h.validateAuthentication(r, "delete")
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		io.WriteString(w, "Error: User not found or does not has valid permissions: " + err.Error())
+		return
+	}
+
+	resource, ok := h.getTrashed(w, r, user)
+	if !ok {
+		return
+	}
+
+	err = h.purgeResource(resource)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(w, "Error: resource cannot be deleted")
+		return
+	}
+
+	io.WriteString(w, "Resource deleted")
+}
+
+func (h Handler) handleEmptyTrash(w http.ResponseWriter, r *http.Request) {
+	user, err := h.validateAuthentication(r, "delete")
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		io.WriteString(w, "Error: User not found or does not has valid permissions: " + err.Error())
+		return
+	}
+
+	trash, err := h.db.ListTrash(user.Id)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(w, "Error: Failed trash listing")
+		return
+	}
+
+	failed := 0
+	for _, resource := range trash {
+		if err := h.purgeResource(resource); err != nil {
+			failed++
+		}
+	}
+
+	io.WriteString(w, fmt.Sprintf("Trash emptied. Failed deletions: %d", failed))
+}
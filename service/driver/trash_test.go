@@ -0,0 +1,147 @@
+package driver
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/c4me-caro/drive"
+)
+
+func TestDeleteFile(t *testing.T) {
+	ts := newTestServer(t)
+	folder := ts.mkdir("bob", "", "docs")
+	file := ts.file("bob", folder.Id, "a.txt", "hello")
+
+	expectStatus(t, ts.do("carol", "GET", "/drive/r/"+file.Id, ""), http.StatusUnauthorized)
+	expectStatus(t, ts.do("bob", "GET", "/drive/r/"+folder.Id, ""), http.StatusConflict)
+	expectStatus(t, ts.do("bob", "GET", "/drive/r/"+file.Id, ""), http.StatusOK)
+
+	if !ts.resource(file.Id).Trashed {
+		t.Fatal("file was not moved to the trash")
+	}
+
+	expectStatus(t, ts.do("bob", "GET", "/drive/f/"+file.Id, ""), http.StatusUnauthorized)
+	expectStatus(t, ts.do("bob", "GET", "/drive/r/"+file.Id, ""), http.StatusUnauthorized)
+}
+
+func TestDeleteFolder(t *testing.T) {
+	ts := newTestServer(t)
+	folder := ts.mkdir("bob", "", "docs")
+	child := ts.mkdir("bob", folder.Id, "reports")
+	file := ts.file("bob", child.Id, "a.txt", "hello")
+
+	expectStatus(t, ts.do("bob", "GET", "/drive/rd/"+folder.Id+"?recursive=false", ""), http.StatusConflict)
+	expectStatus(t, ts.do("bob", "GET", "/drive/rd/"+file.Id, ""), http.StatusConflict)
+	expectStatus(t, ts.do("carol", "GET", "/drive/rd/"+folder.Id, ""), http.StatusUnauthorized)
+
+	w := ts.do("bob", "GET", "/drive/rd/"+folder.Id, "")
+	expectStatus(t, w, http.StatusOK)
+	expectBody(t, w, "Folder moved to trash. Deleted children: 2")
+
+	for _, id := range []string{folder.Id, child.Id, file.Id} {
+		if !ts.resource(id).Trashed {
+			t.Fatalf("%s was not moved to the trash", id)
+		}
+	}
+}
+
+func TestDeleteFolderOfOthers(t *testing.T) {
+	ts := newTestServer(t)
+	folder := ts.mkdir("alice", "", "team")
+	expectStatus(t, ts.do("alice", "POST", "/drive/shares/"+folder.Id, `{"user":"u2","role":"editor"}`), http.StatusOK)
+
+	// bob edits the folder, but a file of alice that does not inherit his
+	// grant keeps him from trashing the whole tree.
+	ts.file("bob", folder.Id, "mine.txt", "bob")
+	theirs := ts.file("alice", folder.Id, "theirs.txt", "alice")
+	expectStatus(t, ts.do("alice", "POST", "/drive/inheritance/"+theirs.Id, `{"inherit":false}`), http.StatusOK)
+
+	expectStatus(t, ts.do("bob", "GET", "/drive/rd/"+folder.Id, ""), http.StatusForbidden)
+	if ts.resource(theirs.Id).Trashed || ts.resource(folder.Id).Trashed {
+		t.Fatal("a refused delete trashed resources")
+	}
+}
+
+func TestTrash(t *testing.T) {
+	ts := newTestServer(t)
+	folder := ts.mkdir("bob", "", "docs")
+	ts.file("bob", folder.Id, "a.txt", "hello")
+	expectStatus(t, ts.do("bob", "GET", "/drive/rd/"+folder.Id, ""), http.StatusOK)
+
+	w := ts.do("bob", "GET", "/drive/trash", "")
+	expectStatus(t, w, http.StatusOK)
+	if trash := decode[[]drive.Resource](t, w); len(trash) != 1 || trash[0].Id != folder.Id {
+		t.Fatalf("trash = %+v, want only the folder", trash)
+	}
+
+	w = ts.do("alice", "GET", "/drive/trash", "")
+	expectStatus(t, w, http.StatusOK)
+	if trash := decode[[]drive.Resource](t, w); len(trash) != 0 {
+		t.Fatalf("trash of alice = %+v, want it empty", trash)
+	}
+}
+
+func TestRestoreTrash(t *testing.T) {
+	ts := newTestServer(t)
+	folder := ts.mkdir("bob", "", "docs")
+	file := ts.file("bob", folder.Id, "a.txt", "hello")
+	expectStatus(t, ts.do("bob", "GET", "/drive/rd/"+folder.Id, ""), http.StatusOK)
+
+	expectStatus(t, ts.do("alice", "POST", "/drive/trash/"+folder.Id+"/restore", ""), http.StatusNotFound)
+	expectStatus(t, ts.do("bob", "POST", "/drive/trash/"+file.Id+"/restore", ""), http.StatusNotFound)
+	expectStatus(t, ts.do("carol", "POST", "/drive/trash/"+folder.Id+"/restore", ""), http.StatusUnauthorized)
+
+	// A new folder took the name in the meantime.
+	other := ts.mkdir("bob", "", "docs")
+	expectStatus(t, ts.do("bob", "POST", "/drive/trash/"+folder.Id+"/restore", ""), http.StatusConflict)
+	expectStatus(t, ts.do("bob", "GET", "/drive/rd/"+other.Id, ""), http.StatusOK)
+
+	expectStatus(t, ts.do("bob", "POST", "/drive/trash/"+folder.Id+"/restore", ""), http.StatusOK)
+	expectBody(t, ts.do("bob", "GET", "/drive/f/"+file.Id, ""), "hello")
+}
+
+func TestPurgeTrash(t *testing.T) {
+	ts := newTestServer(t)
+	folder := ts.mkdir("bob", "", "docs")
+	file := ts.file("bob", folder.Id, "a.txt", "hello")
+	kept := ts.mkdir("bob", "", "keep")
+	same := ts.file("bob", kept.Id, "b.txt", "hello")
+	expectStatus(t, ts.do("bob", "GET", "/drive/rd/"+folder.Id, ""), http.StatusOK)
+
+	expectStatus(t, ts.do("alice", "DELETE", "/drive/trash/"+folder.Id, ""), http.StatusNotFound)
+	expectStatus(t, ts.do("carol", "DELETE", "/drive/trash/"+folder.Id, ""), http.StatusUnauthorized)
+	expectStatus(t, ts.do("bob", "DELETE", "/drive/trash/"+folder.Id, ""), http.StatusOK)
+
+	for _, id := range []string{folder.Id, file.Id} {
+		if _, err := ts.db.GetResource(id); err == nil {
+			t.Fatalf("%s was not purged", id)
+		}
+	}
+
+	// The blob is still referenced by the other file.
+	expectBody(t, ts.do("bob", "GET", "/drive/f/"+same.Id, ""), "hello")
+	expectStatus(t, ts.do("bob", "DELETE", "/drive/trash/"+folder.Id, ""), http.StatusNotFound)
+}
+
+func TestEmptyTrash(t *testing.T) {
+	ts := newTestServer(t)
+	first := ts.mkdir("bob", "", "docs")
+	file := ts.file("bob", first.Id, "a.txt", "hello")
+	second := ts.mkdir("bob", "", "old")
+	expectStatus(t, ts.do("bob", "GET", "/drive/rd/"+first.Id, ""), http.StatusOK)
+	expectStatus(t, ts.do("bob", "GET", "/drive/rd/"+second.Id, ""), http.StatusOK)
+
+	expectStatus(t, ts.do("carol", "DELETE", "/drive/trash", ""), http.StatusUnauthorized)
+
+	w := ts.do("bob", "DELETE", "/drive/trash", "")
+	expectStatus(t, w, http.StatusOK)
+	expectBody(t, w, "Trash emptied. Failed deletions: 0")
+
+	if trash, _ := ts.db.ListTrash("u2"); len(trash) != 0 {
+		t.Fatalf("trash = %+v, want it empty", trash)
+	}
+
+	if _, err := ts.store.Stat(file.Location); err == nil {
+		t.Fatal("the blob of the purged file was kept")
+	}
+}
@@ -0,0 +1,519 @@
+package driver
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/c4me-caro/drive"
+	"github.com/c4me-caro/drive/cmd/auth"
+	"github.com/c4me-caro/drive/database"
+	"github.com/c4me-caro/drive/storage"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+const tusVersion = "1.0.0"
+
+const uploadPrefix = "uploads/"
+
+const defaultUploadHours = 24
+
+var errParentGone = errors.New("parent folder no longer exists")
+
+// uploadLocks holds a mutex per upload id so chunks of one upload are
+// appended one at a time.
+var uploadLocks sync.Map
+
+func lockUpload(id string) func() {
+	value, _ := uploadLocks.LoadOrStore(id, &sync.Mutex{})
+	mutex := value.(*sync.Mutex)
+	mutex.Lock()
+	return mutex.Unlock
+}
+
+func (h Handler) registerUploadRoutes(router *mux.Router) {
+	router.HandleFunc("/uploads", h.handleUploadOptions).Methods("OPTIONS")
+	router.HandleFunc("/uploads", h.handleCreateUpload).Methods("POST")
+	router.HandleFunc("/uploads/{upload}", h.handleUploadOffset).Methods("HEAD")
+	router.HandleFunc("/uploads/{upload}", h.handlePatchUpload).Methods("PATCH")
+	router.HandleFunc("/uploads/{upload}", h.handleDeleteUpload).Methods("DELETE")
+}
+
+// parseUploadMetadata decodes the tus Upload-Metadata header, a comma
+// separated list of "key base64value" pairs.
+func parseUploadMetadata(header string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	if header == "" {
+		return metadata, nil
+	}
+
+	for _, pair := range strings.Split(header, ",") {
+		fields := strings.Fields(pair)
+		if len(fields) == 0 || len(fields) > 2 {
+			return nil, fmt.Errorf("malformed upload metadata: %s", pair)
+		}
+
+		value := ""
+		if len(fields) == 2 {
+			decoded, err := base64.StdEncoding.DecodeString(fields[1])
+			if err != nil {
+				return nil, err
+			}
+
+			value = string(decoded)
+		}
+
+		metadata[fields[0]] = value
+	}
+
+	return metadata, nil
+}
+
+func uploadMaxAge() time.Duration {
+	hours, err := strconv.Atoi(os.Getenv("UPLOAD_EXPIRY_HOURS"))
+	if err != nil || hours <= 0 {
+		hours = defaultUploadHours
+	}
+
+	return time.Duration(hours) * time.Hour
+}
+
+// StartUploadExpiry removes upload sessions older than UPLOAD_EXPIRY_HOURS
+// in the background, together with the chunks they staged. Chunks are only
+// encrypted once the upload completes, so they must not linger.
+func (h Handler) StartUploadExpiry(interval time.Duration) {
+	maxAge := uploadMaxAge()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for ; ; <-ticker.C {
+			uploads, err := h.db.ListUploads()
+			if err != nil {
+				fmt.Println("upload expiry:", err)
+				continue
+			}
+
+			cutoff := time.Now().Add(-maxAge)
+			for _, upload := range uploads {
+				if !upload.CreatedAt.Before(cutoff) {
+					continue
+				}
+
+				unlock := lockUpload(upload.Id)
+				err := h.db.DeleteUpload(upload.Id)
+				unlock()
+				if err != nil {
+					fmt.Println("upload expiry:", err)
+					continue
+				}
+
+				uploadLocks.Delete(upload.Id)
+				h.discardObjects(upload.Location)
+			}
+		}
+	}()
+}
+
+func (h Handler) checkTusVersion(w http.ResponseWriter, r *http.Request) bool {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	if r.Header.Get("Tus-Resumable") != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		w.WriteHeader(http.StatusPreconditionFailed)
+		io.WriteString(w, "Error: Unsupported tus version")
+		return false
+	}
+
+	return true
+}
+
+func (h Handler) getOwnUpload(w http.ResponseWriter, r *http.Request, user drive.User) (drive.Upload, bool) {
+	upload, err := h.db.GetUpload(mux.Vars(r)["upload"])
+	if err != nil || upload.OwnerId != user.Id {
+		w.WriteHeader(http.StatusNotFound)
+		io.WriteString(w, "Error: Upload not found")
+		return drive.Upload{}, false
+	}
+
+	return upload, true
+}
+
+// lockOwnUpload finds the upload of user and locks it. Only uploads that
+// exist get a lock, and the upload is read again once locked, since a
+// chunk or a delete may have changed it while waiting.
+func (h Handler) lockOwnUpload(w http.ResponseWriter, r *http.Request, user drive.User) (drive.Upload, func(), bool) {
+	if _, ok := h.getOwnUpload(w, r, user); !ok {
+		return drive.Upload{}, nil, false
+	}
+
+	id := mux.Vars(r)["upload"]
+	unlock := lockUpload(id)
+
+	upload, ok := h.getOwnUpload(w, r, user)
+	if !ok {
+		uploadLocks.Delete(id)
+		unlock()
+		return drive.Upload{}, nil, false
+	}
+
+	return upload, unlock, true
+}
+
+func (h Handler) handleUploadOptions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.Header().Set("Tus-Version", tusVersion)
+	w.Header().Set("Tus-Extension", "creation,termination")
+	w.Header().Set("Tus-Max-Size", strconv.FormatInt(h.maxSize, 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h Handler) handleCreateUpload(w http.ResponseWriter, r *http.Request) {
+	if !h.checkTusVersion(w, r) {
+		return
+	}
+
+	user, err := h.validateAuthentication(r, "create")
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		io.WriteString(w, "Error: User not found or does not has valid permissions: " + err.Error())
+		return
+	}
+
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		io.WriteString(w, "Error: Invalid Upload-Length")
+		return
+	}
+
+	if length > h.maxSize {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		io.WriteString(w, "Error: File exceeds maximum size")
+		return
+	}
+
+	metadata, err := parseUploadMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		io.WriteString(w, "Error: Invalid Upload-Metadata: " + err.Error())
+		return
+	}
+
+	filename := path.Base("/" + metadata["filename"])
+	if filename == "/" {
+		w.WriteHeader(http.StatusBadRequest)
+		io.WriteString(w, "Error: No filename specified")
+		return
+	}
+
+	parentId := ""
+	if metadata["parent"] != "" {
+		parent, err := h.checkResource(metadata["parent"], user, "update")
+		if err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			io.WriteString(w, "Error: Resource not found or user has no valid permissions: " + err.Error())
+			return
+		}
+
+		if parent.Type != "folder" {
+			w.WriteHeader(http.StatusConflict)
+			io.WriteString(w, "Error: Parent is not a folder")
+			return
+		}
+
+		parentId = parent.Id
+	}
+
+	if h.nameTaken(parentId, user.Id, filename, "") {
+		w.WriteHeader(http.StatusConflict)
+		io.WriteString(w, "Error: Name already exists in folder")
+		return
+	}
+
+	newUUID := uuid.New().String()
+	upload := drive.Upload{
+		Id:        newUUID,
+		Name:      filename,
+		OwnerId:   user.Id,
+		ParentId:  parentId,
+		Location:  uploadPrefix + newUUID,
+		Length:    length,
+		Offset:    0,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	_, err = h.store.Put(upload.Location, strings.NewReader(""))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(w, "Error: Writing file")
+		return
+	}
+
+	err = h.db.CreateUpload(upload)
+	if err != nil {
+		h.store.Delete(upload.Location)
+		w.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(w, "Error: Failed upload creation")
+		return
+	}
+
+	if length == 0 {
+		if _, err := h.finishUpload(upload); err != nil {
+			if nameConflict(w, err) || parentGone(w, err) {
+				return
+			}
+
+			w.WriteHeader(http.StatusInternalServerError)
+			io.WriteString(w, "Error: Failed resource creation")
+			return
+		}
+	}
+
+	w.Header().Set("Location", strings.TrimSuffix(r.URL.Path, "/")+"/"+newUUID)
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (h Handler) handleUploadOffset(w http.ResponseWriter, r *http.Request) {
+	if !h.checkTusVersion(w, r) {
+		return
+	}
+
+	user, err := h.validateAuthentication(r, "create")
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		io.WriteString(w, "Error: User not found or does not has valid permissions: " + err.Error())
+		return
+	}
+
+	upload, ok := h.getOwnUpload(w, r, user)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h Handler) handlePatchUpload(w http.ResponseWriter, r *http.Request) {
+	if !h.checkTusVersion(w, r) {
+		return
+	}
+
+	user, err := h.validateAuthentication(r, "create")
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		io.WriteString(w, "Error: User not found or does not has valid permissions: " + err.Error())
+		return
+	}
+
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		io.WriteString(w, "Error: Content-Type must be application/offset+octet-stream")
+		return
+	}
+
+	// The offset is read under the lock, so a second PATCH at the same
+	// offset waits and then fails the check instead of appending twice.
+	upload, unlock, ok := h.lockOwnUpload(w, r, user)
+	if !ok {
+		return
+	}
+
+	defer unlock()
+
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset != upload.Offset {
+		w.WriteHeader(http.StatusConflict)
+		io.WriteString(w, "Error: Upload-Offset does not match")
+		return
+	}
+
+	if r.ContentLength > upload.Length-upload.Offset {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		io.WriteString(w, "Error: Chunk exceeds upload length")
+		return
+	}
+
+	chunk := &limitedReader{r: r.Body, remaining: upload.Length - upload.Offset}
+	written, err := h.store.Append(upload.Location, chunk)
+	upload.Offset += written
+
+	if uerr := h.db.UpdateUploadOffset(upload.Id, upload.Offset); uerr != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(w, "Error: Failed upload update")
+		return
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	if upload.Offset == upload.Length {
+		resource, ferr := h.finishUpload(upload)
+		if ferr != nil {
+			if nameConflict(w, ferr) || parentGone(w, ferr) {
+				return
+			}
+
+			w.WriteHeader(http.StatusInternalServerError)
+			io.WriteString(w, "Error: Failed resource creation")
+			return
+		}
+
+		w.Header().Set("Upload-Resource", resource.Id)
+	}
+
+	if errors.Is(err, errTooLarge) {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		io.WriteString(w, "Error: Chunk exceeds upload length")
+		return
+	}
+
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(w, "Error: Writing file")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h Handler) handleDeleteUpload(w http.ResponseWriter, r *http.Request) {
+	if !h.checkTusVersion(w, r) {
+		return
+	}
+
+	user, err := h.validateAuthentication(r, "create")
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		io.WriteString(w, "Error: User not found or does not has valid permissions: " + err.Error())
+		return
+	}
+
+	upload, unlock, ok := h.lockOwnUpload(w, r, user)
+	if !ok {
+		return
+	}
+
+	defer unlock()
+
+	err = h.db.DeleteUpload(upload.Id)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(w, "Error: Upload cannot be deleted")
+		return
+	}
+
+	uploadLocks.Delete(upload.Id)
+	h.store.Delete(upload.Location)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// parentGone answers 409 when the folder an upload was started in is no
+// longer there to receive it.
+func parentGone(w http.ResponseWriter, err error) bool {
+	if !errors.Is(err, errParentGone) {
+		return false
+	}
+
+	w.WriteHeader(http.StatusConflict)
+	io.WriteString(w, "Error: Parent folder no longer exists")
+	return true
+}
+
+// finishUpload turns a completed upload session into a file resource and
+// links it to the parent folder chosen when the session was created. The
+// uploaded bytes are staged again as the blob of their hash, encrypted when
+// a master key is set, and the upload itself is removed.
+func (h Handler) finishUpload(upload drive.Upload) (drive.Resource, error) {
+	object, err := h.store.Get(upload.Location)
+	if err != nil {
+		return drive.Resource{}, err
+	}
+
+	temp, _, blob, err := h.stageObject(object)
+	object.Close()
+	if err != nil {
+		return drive.Resource{}, err
+	}
+
+	var body drive.Resource
+
+	body.Id = upload.Id
+	body.Name = upload.Name
+	body.OwnerId = upload.OwnerId
+	body.SharedId = []string{}
+	body.Type = "file"
+	body.Content = []string{}
+	body.Size = upload.Length
+	body.ModTime = time.Now().UTC()
+	body.Hash = blob.Hash
+	body.Location = storage.BlobKey(body.Hash)
+	body.Parent = upload.ParentId
+	body.Version = 1
+	body.Versions = []drive.Version{{
+		Number:   1,
+		Location: body.Location,
+		Size:     body.Size,
+		Hash:     body.Hash,
+		AuthorId: upload.OwnerId,
+		ModTime:  body.ModTime,
+	}}
+
+	// The folder may have been trashed, deleted or given a file of the
+	// same name while the upload was running.
+	var parent drive.Resource
+	if upload.ParentId != "" {
+		parent, err = h.db.GetResource(upload.ParentId)
+		if err != nil || parent.Trashed {
+			h.discardObjects(temp)
+			return drive.Resource{}, errParentGone
+		}
+	}
+
+	if h.nameTaken(upload.ParentId, upload.OwnerId, upload.Name, "") {
+		h.discardObjects(temp)
+		return drive.Resource{}, database.ErrNameTaken
+	}
+
+	body.Ancestors = auth.Ancestors(body, parent)
+
+	err = h.db.Transaction(func(tx database.ResourceStore) error {
+		stored, err := h.storeBlob(tx, temp, blob)
+		if err != nil {
+			return err
+		}
+
+		body.KeyId = stored.KeyId
+		body.Versions[0].KeyId = stored.KeyId
+		if err := tx.CreateResource(body); err != nil {
+			return err
+		}
+
+		if parent.Id != "" {
+			if err := tx.AddResourceChildren(parent, body.Id); err != nil {
+				return err
+			}
+		}
+
+		return tx.DeleteUpload(upload.Id)
+	})
+
+	h.discardObjects(temp)
+	if err != nil {
+		return drive.Resource{}, err
+	}
+
+	uploadLocks.Delete(upload.Id)
+	h.discardObjects(upload.Location)
+	return body, nil
+}
@@ -0,0 +1,291 @@
+package driver
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/c4me-caro/drive"
+)
+
+func uploadMetadata(pairs ...string) string {
+	fields := []string{}
+	for i := 0; i+1 < len(pairs); i += 2 {
+		fields = append(fields, pairs[i]+" "+base64.StdEncoding.EncodeToString([]byte(pairs[i+1])))
+	}
+
+	return strings.Join(fields, ",")
+}
+
+func (ts *testServer) tus(user string, method string, path string, body string, header map[string]string) *httptest.ResponseRecorder {
+	r := ts.request(user, method, path, strings.NewReader(body))
+	r.Header.Set("Tus-Resumable", tusVersion)
+	for name, value := range header {
+		r.Header.Set(name, value)
+	}
+
+	return ts.serve(r)
+}
+
+// createUpload starts an upload of length bytes and returns its location.
+func (ts *testServer) createUpload(user string, length int, metadata string) string {
+	ts.t.Helper()
+
+	w := ts.tus(user, "POST", "/drive/uploads", "", map[string]string{
+		"Upload-Length":   strconv.Itoa(length),
+		"Upload-Metadata": metadata,
+	})
+
+	expectStatus(ts.t, w, http.StatusCreated)
+	return w.Header().Get("Location")
+}
+
+func (ts *testServer) patchUpload(user string, location string, offset int, chunk string) *httptest.ResponseRecorder {
+	return ts.tus(user, "PATCH", location, chunk, map[string]string{
+		"Upload-Offset": strconv.Itoa(offset),
+		"Content-Type":  "application/offset+octet-stream",
+	})
+}
+
+func TestUploadOptions(t *testing.T) {
+	ts := newTestServer(t)
+
+	w := ts.do("bob", "OPTIONS", "/drive/uploads", "")
+	expectStatus(t, w, http.StatusNoContent)
+	if version := w.Header().Get("Tus-Version"); version != tusVersion {
+		t.Fatalf("Tus-Version = %s, want %s", version, tusVersion)
+	}
+}
+
+func TestResumableUpload(t *testing.T) {
+	ts := newTestServer(t)
+	folder := ts.mkdir("bob", "", "docs")
+	location := ts.createUpload("bob", 10, uploadMetadata("filename", "big.bin", "parent", folder.Id))
+
+	upload, err := ts.db.GetUpload(location[strings.LastIndex(location, "/")+1:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(upload.Location, uploadPrefix) || strings.Contains(upload.Location, "big.bin") {
+		t.Fatalf("upload stored at %s", upload.Location)
+	}
+
+	expectStatus(t, ts.patchUpload("bob", location, 0, "01234"), http.StatusNoContent)
+
+	w := ts.tus("bob", "HEAD", location, "", nil)
+	expectStatus(t, w, http.StatusOK)
+	if offset := w.Header().Get("Upload-Offset"); offset != "5" {
+		t.Fatalf("Upload-Offset = %s, want 5", offset)
+	}
+
+	expectStatus(t, ts.patchUpload("bob", location, 0, "01234"), http.StatusConflict)
+	expectStatus(t, ts.patchUpload("bob", location, 5, "5678901234"), http.StatusRequestEntityTooLarge)
+	expectStatus(t, ts.patchUpload("alice", location, 5, "56789"), http.StatusNotFound)
+
+	w = ts.patchUpload("bob", location, 5, "56789")
+	expectStatus(t, w, http.StatusNoContent)
+
+	id := w.Header().Get("Upload-Resource")
+	w = ts.do("bob", "GET", "/drive/f/"+id, "")
+	expectStatus(t, w, http.StatusOK)
+	expectBody(t, w, "0123456789")
+
+	if got := ts.resource(folder.Id).Content; len(got) != 1 || got[0] != id {
+		t.Fatalf("content = %v, want [%s]", got, id)
+	}
+
+	if _, err := ts.store.Stat(upload.Location); err == nil {
+		t.Fatal("staged chunks were kept after the upload finished")
+	}
+
+	expectStatus(t, ts.tus("bob", "HEAD", location, "", nil), http.StatusNotFound)
+}
+
+func TestCreateUpload(t *testing.T) {
+	t.Setenv("MAX_OBJECT_SIZE", "16")
+
+	ts := newTestServer(t)
+	folder := ts.mkdir("bob", "", "docs")
+	ts.file("bob", folder.Id, "a.txt", "hello")
+	theirs := ts.mkdir("alice", "", "private")
+
+	create := func(user string, length string, metadata string) *httptest.ResponseRecorder {
+		return ts.tus(user, "POST", "/drive/uploads", "", map[string]string{
+			"Upload-Length":   length,
+			"Upload-Metadata": metadata,
+		})
+	}
+
+	expectStatus(t, ts.do("bob", "POST", "/drive/uploads", ""), http.StatusPreconditionFailed)
+	expectStatus(t, create("carol", "4", uploadMetadata("filename", "b.txt")), http.StatusUnauthorized)
+	expectStatus(t, create("bob", "-1", uploadMetadata("filename", "b.txt")), http.StatusBadRequest)
+	expectStatus(t, create("bob", "17", uploadMetadata("filename", "b.txt")), http.StatusRequestEntityTooLarge)
+	expectStatus(t, create("bob", "4", "filename !!"), http.StatusBadRequest)
+	expectStatus(t, create("bob", "4", ""), http.StatusBadRequest)
+	expectStatus(t, create("bob", "4", uploadMetadata("filename", "a.txt", "parent", folder.Id)), http.StatusConflict)
+	expectStatus(t, create("bob", "4", uploadMetadata("filename", "b.txt", "parent", theirs.Id)), http.StatusUnauthorized)
+
+	file := ts.resource("a.txt")
+	expectStatus(t, create("bob", "4", uploadMetadata("filename", "b.txt", "parent", file.Id)), http.StatusConflict)
+
+	// An empty upload is finished as soon as it is created.
+	w := create("bob", "0", uploadMetadata("filename", "empty.txt", "parent", folder.Id))
+	expectStatus(t, w, http.StatusCreated)
+	if _, err := ts.db.GetChild(folder.Id, "u2", "empty.txt"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPatchUpload(t *testing.T) {
+	ts := newTestServer(t)
+	location := ts.createUpload("bob", 4, uploadMetadata("filename", "a.txt"))
+
+	w := ts.tus("bob", "PATCH", location, "abcd", map[string]string{"Upload-Offset": "0"})
+	expectStatus(t, w, http.StatusUnsupportedMediaType)
+
+	expectStatus(t, ts.patchUpload("carol", location, 0, "abcd"), http.StatusUnauthorized)
+	expectStatus(t, ts.patchUpload("bob", "/drive/uploads/missing", 0, "abcd"), http.StatusNotFound)
+	expectStatus(t, ts.patchUpload("bob", location, 0, "abcd"), http.StatusNoContent)
+
+	// The name was taken while the upload ran.
+	location = ts.createUpload("bob", 4, uploadMetadata("filename", "b.txt"))
+	ts.mkdir("bob", "", "b.txt")
+	expectStatus(t, ts.patchUpload("bob", location, 0, "abcd"), http.StatusConflict)
+}
+
+func TestUploadLocks(t *testing.T) {
+	ts := newTestServer(t)
+	location := ts.createUpload("bob", 4, uploadMetadata("filename", "a.txt"))
+	id := strings.TrimPrefix(location, "/drive/uploads/")
+
+	// Requests for uploads that are not there, or not theirs, leave no
+	// lock behind.
+	expectStatus(t, ts.patchUpload("bob", "/drive/uploads/random", 0, "ab"), http.StatusNotFound)
+	expectStatus(t, ts.tus("bob", "DELETE", "/drive/uploads/random", "", nil), http.StatusNotFound)
+	expectStatus(t, ts.patchUpload("alice", location, 0, "ab"), http.StatusNotFound)
+	for _, id := range []string{"random", id} {
+		if _, ok := uploadLocks.Load(id); ok {
+			t.Fatalf("lock of %s was kept", id)
+		}
+	}
+
+	expectStatus(t, ts.patchUpload("bob", location, 0, "ab"), http.StatusNoContent)
+	expectStatus(t, ts.tus("bob", "DELETE", location, "", nil), http.StatusNoContent)
+	if _, ok := uploadLocks.Load(id); ok {
+		t.Fatal("lock of a deleted upload was kept")
+	}
+}
+
+func TestConcurrentPatches(t *testing.T) {
+	ts := newTestServer(t)
+	location := ts.createUpload("bob", 8, uploadMetadata("filename", "race.bin"))
+
+	var wg sync.WaitGroup
+	codes := make([]int, 8)
+	for i := range codes {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			codes[i] = ts.patchUpload("bob", location, 0, "abcd").Code
+		}(i)
+	}
+
+	wg.Wait()
+
+	accepted := 0
+	for _, code := range codes {
+		if code == http.StatusNoContent {
+			accepted++
+		}
+	}
+
+	if accepted != 1 {
+		t.Fatalf("%d chunks were appended at the same offset: %v", accepted, codes)
+	}
+
+	w := ts.tus("bob", "HEAD", location, "", nil)
+	if offset := w.Header().Get("Upload-Offset"); offset != "4" {
+		t.Fatalf("Upload-Offset = %s, want 4", offset)
+	}
+}
+
+func TestFinishUploadInTrashedFolder(t *testing.T) {
+	ts := newTestServer(t)
+	folder := ts.mkdir("bob", "", "docs")
+	location := ts.createUpload("bob", 4, uploadMetadata("filename", "a.txt", "parent", folder.Id))
+	expectStatus(t, ts.patchUpload("bob", location, 0, "ab"), http.StatusNoContent)
+
+	expectStatus(t, ts.do("bob", "GET", "/drive/rd/"+folder.Id, ""), http.StatusOK)
+
+	w := ts.patchUpload("bob", location, 2, "cd")
+	expectStatus(t, w, http.StatusConflict)
+	expectBody(t, w, "Error: Parent folder no longer exists")
+
+	// The upload is kept, so it can be finished once the folder is back.
+	expectStatus(t, ts.do("bob", "POST", "/drive/trash/"+folder.Id+"/restore", ""), http.StatusOK)
+	expectStatus(t, ts.patchUpload("bob", location, 4, ""), http.StatusNoContent)
+	if _, err := ts.db.GetChild(folder.Id, "u2", "a.txt"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDeleteUpload(t *testing.T) {
+	ts := newTestServer(t)
+	location := ts.createUpload("bob", 4, uploadMetadata("filename", "a.txt"))
+	expectStatus(t, ts.patchUpload("bob", location, 0, "ab"), http.StatusNoContent)
+
+	expectStatus(t, ts.tus("alice", "DELETE", location, "", nil), http.StatusNotFound)
+	expectStatus(t, ts.tus("carol", "DELETE", location, "", nil), http.StatusUnauthorized)
+	expectStatus(t, ts.tus("bob", "DELETE", location, "", nil), http.StatusNoContent)
+	expectStatus(t, ts.tus("bob", "HEAD", location, "", nil), http.StatusNotFound)
+
+	if uploads, _ := ts.db.ListUploads(); len(uploads) != 0 {
+		t.Fatalf("uploads left: %+v", uploads)
+	}
+}
+
+func TestUploadExpiry(t *testing.T) {
+	ts := newTestServer(t)
+	handler := NewHandler(ts.db, ts.store, nil)
+
+	stale := drive.Upload{Id: "stale", Name: "old.bin", OwnerId: "u2", Location: uploadPrefix + "stale", Length: 4, CreatedAt: time.Now().Add(-48 * time.Hour)}
+	fresh := drive.Upload{Id: "fresh", Name: "new.bin", OwnerId: "u2", Location: uploadPrefix + "fresh", Length: 4, CreatedAt: time.Now()}
+	for _, upload := range []drive.Upload{stale, fresh} {
+		if _, err := ts.store.Put(upload.Location, strings.NewReader("ab")); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := ts.db.CreateUpload(upload); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	handler.StartUploadExpiry(time.Hour)
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		if _, err := ts.db.GetUpload(stale.Id); err != nil {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatal("stale upload was not removed")
+		}
+
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if _, err := ts.db.GetUpload(fresh.Id); err != nil {
+		t.Fatal("fresh upload was removed")
+	}
+
+	if _, err := ts.store.Stat(stale.Location); err == nil {
+		t.Fatal("chunks of the stale upload were kept")
+	}
+}
@@ -0,0 +1,440 @@
+package driver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/c4me-caro/drive"
+	"github.com/c4me-caro/drive/database"
+	"github.com/c4me-caro/drive/storage"
+	"github.com/gorilla/mux"
+)
+
+func (h Handler) registerVersionRoutes(router *mux.Router) {
+	router.HandleFunc("/f/{file}", h.handleNewVersion).Methods("POST")
+	router.HandleFunc("/versions/{file}", h.handleVersions).Methods("GET")
+	router.HandleFunc("/versions/{file}/{version}/restore", h.handleRestoreVersion).Methods("POST")
+	router.HandleFunc("/retention/{folder}", h.handleRetention).Methods("POST")
+}
+
+func globalRetention() drive.Retention {
+	versions, _ := strconv.Atoi(os.Getenv("VERSIONS_KEEP"))
+	days, _ := strconv.Atoi(os.Getenv("VERSIONS_KEEP_DAYS"))
+
+	return drive.Retention{
+		KeepVersions: versions,
+		KeepDays:     days,
+	}
+}
+
+// versionsOf returns the version history of a file. Files created before
+// versioning existed are reported as a single first version.
+func versionsOf(resource drive.Resource) []drive.Version {
+	if len(resource.Versions) != 0 || resource.Type != "file" {
+		return resource.Versions
+	}
+
+	return []drive.Version{{
+		Number:   1,
+		Location: resource.Location,
+		Size:     resource.Size,
+		Hash:     resource.Hash,
+		AuthorId: resource.OwnerId,
+		ModTime:  resource.ModTime,
+	}}
+}
+
+func findVersion(resource drive.Resource, number string) (drive.Version, error) {
+	n, err := strconv.Atoi(number)
+	if err != nil {
+		return drive.Version{}, fmt.Errorf("invalid version: %s", number)
+	}
+
+	for _, version := range versionsOf(resource) {
+		if version.Number == n {
+			return version, nil
+		}
+	}
+
+	return drive.Version{}, fmt.Errorf("version not found: %d", n)
+}
+
+// promoteVersion makes version the current content of resource under a new
+// version number and returns the versions dropped by the retention policy.
+func (h Handler) promoteVersion(resource *drive.Resource, version drive.Version) []drive.Version {
+	versions := versionsOf(*resource)
+	last := 0
+	if len(versions) != 0 {
+		last = versions[len(versions)-1].Number
+	}
+
+	version.Number = last + 1
+	resource.Versions = append(versions, version)
+	resource.Version = version.Number
+	resource.Location = version.Location
+	resource.Size = version.Size
+	resource.Hash = version.Hash
+	resource.ModTime = version.ModTime
+	resource.KeyId = version.KeyId
+
+	return pruneVersions(resource, h.retentionFor(*resource))
+}
+
+func (h Handler) retentionFor(resource drive.Resource) drive.Retention {
+	if resource.Parent != "" {
+		parent, err := h.db.GetResource(resource.Parent)
+		if err == nil && parent.Retention != nil {
+			return *parent.Retention
+		}
+	}
+
+	return h.retention
+}
+
+func pruneVersions(resource *drive.Resource, policy drive.Retention) []drive.Version {
+	cutoff := time.Now().AddDate(0, 0, -policy.KeepDays)
+	kept := []drive.Version{}
+	removed := []drive.Version{}
+
+	for i, version := range resource.Versions {
+		newer := len(resource.Versions) - i
+		tooMany := policy.KeepVersions > 0 && newer > policy.KeepVersions
+		tooOld := policy.KeepDays > 0 && version.ModTime.Before(cutoff)
+
+		if version.Number != resource.Version && (tooMany || tooOld) {
+			removed = append(removed, version)
+			continue
+		}
+
+		kept = append(kept, version)
+	}
+
+	resource.Versions = kept
+	return removed
+}
+
+// StartVersionExpiry prunes versions older than the retention policy of
+// their folder in the background, checking once per interval. Writes only
+// prune the file they change, so this covers files nobody writes anymore.
+func (h Handler) StartVersionExpiry(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for ; ; <-ticker.C {
+			resources, err := h.db.ListResources()
+			if err != nil {
+				fmt.Println("version expiry:", err)
+				continue
+			}
+
+			for _, resource := range resources {
+				if resource.Type != "file" || len(resource.Versions) < 2 {
+					continue
+				}
+
+				if err := h.expireVersions(resource.Id); err != nil {
+					fmt.Println("version expiry:", err)
+				}
+			}
+		}
+	}()
+}
+
+// expireVersions drops the versions of a file its retention policy no
+// longer keeps.
+func (h Handler) expireVersions(id string) error {
+	var released []string
+	err := h.db.Transaction(func(tx database.ResourceStore) error {
+		resource, err := tx.GetResource(id)
+		if err != nil {
+			return err
+		}
+
+		removed := pruneVersions(&resource, h.retentionFor(resource))
+		if len(removed) == 0 {
+			return nil
+		}
+
+		if err := tx.UpdateResource(resource); err != nil {
+			return err
+		}
+
+		released, err = releaseVersions(tx, resource, removed)
+		return err
+	})
+
+	if err != nil {
+		return err
+	}
+
+	h.discardObjects(released...)
+	return nil
+}
+
+// releaseVersions drops the references of pruned versions unless a
+// remaining version, such as a restored copy, still points to them. It
+// returns the keys to delete once tx commits.
+func releaseVersions(tx database.ResourceStore, resource drive.Resource, removed []drive.Version) ([]string, error) {
+	used := make(map[string]struct{})
+	for _, version := range resource.Versions {
+		used[version.Location] = struct{}{}
+	}
+
+	locations := []string{}
+	for _, location := range blobLocations(removed) {
+		if _, ok := used[location]; !ok {
+			locations = append(locations, location)
+		}
+	}
+
+	return releaseBlobs(tx, locations)
+}
+
+func (h Handler) handleNewVersion(w http.ResponseWriter, r *http.Request) {
+	user, err := h.validateAuthentication(r, "update")
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		io.WriteString(w, "Error: User not found or does not has valid permissions: " + err.Error())
+		return
+	}
+
+	resource, err := h.checkResource(mux.Vars(r)["file"], user, "update")
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		io.WriteString(w, "Error: Resource not found or user has no valid permissions: " + err.Error())
+		return
+	}
+
+	if resource.Type != "file" {
+		w.WriteHeader(http.StatusConflict)
+		io.WriteString(w, "Error: Resource is not a file")
+		return
+	}
+
+	file, err := nextFilePart(r)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(w, "Error: File not found")
+		return
+	}
+
+	defer file.Close()
+
+	temp, size, blob, err := h.stageObject(file)
+	if errors.Is(err, errTooLarge) {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		io.WriteString(w, "Error: File exceeds maximum size")
+		return
+	}
+
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(w, "Error: Writing file")
+		return
+	}
+
+	location := storage.BlobKey(blob.Hash)
+
+	var released []string
+	err = h.db.Transaction(func(tx database.ResourceStore) error {
+		// The upload may have taken a while, so the version goes on top of
+		// the file as it is now, keeping renames, moves and shares made
+		// meanwhile.
+		current, err := tx.GetResource(resource.Id)
+		if err != nil {
+			return err
+		}
+
+		keyId, stored := "", false
+		for _, version := range versionsOf(current) {
+			if version.Location == location {
+				keyId, stored = version.KeyId, true
+			}
+		}
+
+		resource = current
+		removed := h.promoteVersion(&resource, drive.Version{
+			Location: location,
+			Size:     size,
+			Hash:     blob.Hash,
+			AuthorId: user.Id,
+			ModTime:  time.Now().UTC(),
+			KeyId:    keyId,
+		})
+
+		if !stored {
+			current, err := h.storeBlob(tx, temp, blob)
+			if err != nil {
+				return err
+			}
+
+			resource.KeyId = current.KeyId
+			resource.Versions[len(resource.Versions)-1].KeyId = current.KeyId
+		}
+
+		if err := tx.UpdateResource(resource); err != nil {
+			return err
+		}
+
+		released, err = releaseVersions(tx, resource, removed)
+		return err
+	})
+
+	h.discardObjects(temp)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(w, "Error: Failed resource update")
+		return
+	}
+
+	h.discardObjects(released...)
+
+	if err := json.NewEncoder(w).Encode(resource); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(w, "Error: bad json coding")
+		return
+	}
+}
+
+func (h Handler) handleVersions(w http.ResponseWriter, r *http.Request) {
+	user, err := h.validateAuthentication(r, "read")
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		io.WriteString(w, "Error: User not found or does not has valid permissions: " + err.Error())
+		return
+	}
+
+	resource, err := h.checkResource(mux.Vars(r)["file"], user, "read")
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		io.WriteString(w, "Error: Resource not found or user has no valid permissions: " + err.Error())
+		return
+	}
+
+	if resource.Type != "file" {
+		w.WriteHeader(http.StatusConflict)
+		io.WriteString(w, "Error: Resource is not a file")
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(versionsOf(resource)); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(w, "Error: bad json coding")
+		return
+	}
+}
+
+func (h Handler) handleRestoreVersion(w http.ResponseWriter, r *http.Request) {
+	user, err := h.validateAuthentication(r, "update")
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		io.WriteString(w, "Error: User not found or does not has valid permissions: " + err.Error())
+		return
+	}
+
+	resource, err := h.checkResource(mux.Vars(r)["file"], user, "update")
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		io.WriteString(w, "Error: Resource not found or user has no valid permissions: " + err.Error())
+		return
+	}
+
+	if _, err := findVersion(resource, mux.Vars(r)["version"]); err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		io.WriteString(w, "Error: " + err.Error())
+		return
+	}
+
+	var released []string
+	err = h.db.Transaction(func(tx database.ResourceStore) error {
+		current, err := tx.GetResource(resource.Id)
+		if err != nil {
+			return err
+		}
+
+		version, err := findVersion(current, mux.Vars(r)["version"])
+		if err != nil {
+			return err
+		}
+
+		resource = current
+		version.AuthorId = user.Id
+		version.ModTime = time.Now().UTC()
+		removed := h.promoteVersion(&resource, version)
+
+		if err := tx.UpdateResource(resource); err != nil {
+			return err
+		}
+
+		released, err = releaseVersions(tx, resource, removed)
+		return err
+	})
+
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(w, "Error: Failed resource update")
+		return
+	}
+
+	h.discardObjects(released...)
+
+	if err := json.NewEncoder(w).Encode(resource); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(w, "Error: bad json coding")
+		return
+	}
+}
+
+func (h Handler) handleRetention(w http.ResponseWriter, r *http.Request) {
+	user, err := h.validateAuthentication(r, "update")
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		io.WriteString(w, "Error: User not found or does not has valid permissions: " + err.Error())
+		return
+	}
+
+	resource, err := h.checkResource(mux.Vars(r)["folder"], user, "update")
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		io.WriteString(w, "Error: Resource not found or user has no valid permissions: " + err.Error())
+		return
+	}
+
+	if resource.Type != "folder" {
+		w.WriteHeader(http.StatusConflict)
+		io.WriteString(w, "Error: Resource is not a folder")
+		return
+	}
+
+	var policy drive.Retention
+	if err := json.NewDecoder(r.Body).Decode(&policy); err != nil || policy.KeepVersions < 0 || policy.KeepDays < 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		io.WriteString(w, "Error: Invalid retention policy")
+		return
+	}
+
+	resource.Retention = &policy
+	if policy.KeepVersions == 0 && policy.KeepDays == 0 {
+		resource.Retention = nil
+	}
+
+	err = h.db.UpdateResource(resource)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(w, "Error: Failed resource update")
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(resource); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(w, "Error: bad json coding")
+		return
+	}
+}
@@ -0,0 +1,173 @@
+package driver
+
+import (
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/c4me-caro/drive"
+)
+
+func TestNewVersion(t *testing.T) {
+	t.Setenv("MAX_OBJECT_SIZE", "16")
+
+	ts := newTestServer(t)
+	folder := ts.mkdir("bob", "", "docs")
+	file := ts.file("bob", folder.Id, "a.txt", "one")
+
+	w := ts.serve(ts.uploadRequest("bob", "/drive/f/"+file.Id, "a.txt", "two"))
+	expectStatus(t, w, http.StatusOK)
+	if got := decode[drive.Resource](t, w); got.Version != 2 || len(got.Versions) != 2 {
+		t.Fatalf("unexpected versions: %+v", got)
+	}
+
+	w = ts.do("bob", "GET", "/drive/f/"+file.Id, "")
+	expectBody(t, w, "two")
+
+	w = ts.do("bob", "GET", "/drive/f/"+file.Id+"?version=1", "")
+	expectStatus(t, w, http.StatusOK)
+	expectBody(t, w, "one")
+
+	expectStatus(t, ts.serve(ts.uploadRequest("bob", "/drive/f/"+file.Id, "a.txt", strings.Repeat("x", 17))), http.StatusRequestEntityTooLarge)
+	expectStatus(t, ts.serve(ts.uploadRequest("bob", "/drive/f/"+folder.Id, "a.txt", "x")), http.StatusConflict)
+	expectStatus(t, ts.serve(ts.uploadRequest("carol", "/drive/f/"+file.Id, "a.txt", "x")), http.StatusUnauthorized)
+}
+
+func TestVersions(t *testing.T) {
+	ts := newTestServer(t)
+	folder := ts.mkdir("bob", "", "docs")
+	file := ts.file("bob", folder.Id, "a.txt", "one")
+	expectStatus(t, ts.serve(ts.uploadRequest("bob", "/drive/f/"+file.Id, "a.txt", "two")), http.StatusOK)
+
+	w := ts.do("bob", "GET", "/drive/versions/"+file.Id, "")
+	expectStatus(t, w, http.StatusOK)
+	if versions := decode[[]drive.Version](t, w); len(versions) != 2 {
+		t.Fatalf("versions = %+v, want 2", versions)
+	}
+
+	expectStatus(t, ts.do("bob", "GET", "/drive/versions/"+folder.Id, ""), http.StatusConflict)
+	expectStatus(t, ts.do("carol", "GET", "/drive/versions/"+file.Id, ""), http.StatusUnauthorized)
+}
+
+func TestRestoreVersion(t *testing.T) {
+	ts := newTestServer(t)
+	folder := ts.mkdir("bob", "", "docs")
+	file := ts.file("bob", folder.Id, "a.txt", "one")
+	expectStatus(t, ts.serve(ts.uploadRequest("bob", "/drive/f/"+file.Id, "a.txt", "two")), http.StatusOK)
+
+	w := ts.do("bob", "POST", "/drive/versions/"+file.Id+"/1/restore", "")
+	expectStatus(t, w, http.StatusOK)
+	if got := decode[drive.Resource](t, w); got.Version != 3 {
+		t.Fatalf("version = %d, want 3", got.Version)
+	}
+
+	expectBody(t, ts.do("bob", "GET", "/drive/f/"+file.Id, ""), "one")
+
+	expectStatus(t, ts.do("bob", "POST", "/drive/versions/"+file.Id+"/9/restore", ""), http.StatusNotFound)
+	expectStatus(t, ts.do("carol", "POST", "/drive/versions/"+file.Id+"/1/restore", ""), http.StatusUnauthorized)
+}
+
+func TestRetention(t *testing.T) {
+	ts := newTestServer(t)
+	folder := ts.mkdir("bob", "", "docs")
+	file := ts.file("bob", folder.Id, "a.txt", "one")
+
+	w := ts.do("bob", "POST", "/drive/retention/"+folder.Id, `{"keepVersions":2}`)
+	expectStatus(t, w, http.StatusOK)
+	if got := decode[drive.Resource](t, w); got.Retention == nil || got.Retention.KeepVersions != 2 {
+		t.Fatalf("retention = %+v", got.Retention)
+	}
+
+	for _, content := range []string{"two", "three"} {
+		expectStatus(t, ts.serve(ts.uploadRequest("bob", "/drive/f/"+file.Id, "a.txt", content)), http.StatusOK)
+	}
+
+	if versions := ts.resource(file.Id).Versions; len(versions) != 2 {
+		t.Fatalf("kept %d versions, want 2", len(versions))
+	}
+
+	expectStatus(t, ts.do("bob", "POST", "/drive/retention/"+folder.Id, `{"keepVersions":-1}`), http.StatusBadRequest)
+	expectStatus(t, ts.do("bob", "POST", "/drive/retention/"+file.Id, `{"keepVersions":1}`), http.StatusConflict)
+	expectStatus(t, ts.do("carol", "POST", "/drive/retention/"+folder.Id, `{"keepVersions":1}`), http.StatusUnauthorized)
+
+	w = ts.do("bob", "POST", "/drive/retention/"+folder.Id, `{}`)
+	expectStatus(t, w, http.StatusOK)
+	if got := decode[drive.Resource](t, w); got.Retention != nil {
+		t.Fatalf("retention = %+v, want none", got.Retention)
+	}
+}
+
+func TestVersionExpiry(t *testing.T) {
+	ts := newTestServer(t)
+	folder := ts.mkdir("bob", "", "docs")
+	file := ts.file("bob", folder.Id, "a.txt", "one")
+	expectStatus(t, ts.serve(ts.uploadRequest("bob", "/drive/f/"+file.Id, "a.txt", "two")), http.StatusOK)
+	expectStatus(t, ts.do("bob", "POST", "/drive/retention/"+folder.Id, `{"keepDays":1}`), http.StatusOK)
+
+	// The first version ages past the policy without the file being
+	// written again.
+	resource := ts.resource(file.Id)
+	resource.Versions[0].ModTime = time.Now().AddDate(0, 0, -2)
+	if err := ts.db.UpdateResource(resource); err != nil {
+		t.Fatal(err)
+	}
+
+	handler := NewHandler(ts.db, ts.store, nil)
+	if err := handler.expireVersions(file.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	versions := ts.resource(file.Id).Versions
+	if len(versions) != 1 || versions[0].Number != 2 {
+		t.Fatalf("versions = %+v, want only the second", versions)
+	}
+
+	if _, err := ts.store.Stat(resource.Versions[0].Location); err == nil {
+		t.Fatal("the blob of the expired version was kept")
+	}
+
+	expectBody(t, ts.do("bob", "GET", "/drive/f/"+file.Id, ""), "two")
+}
+
+func TestNewVersionKeepsConcurrentChanges(t *testing.T) {
+	ts := newTestServer(t)
+	folder := ts.mkdir("bob", "", "docs")
+	file := ts.file("bob", folder.Id, "a.txt", "one")
+
+	body, stream := io.Pipe()
+	form := multipart.NewWriter(stream)
+	r := ts.request("bob", "POST", "/drive/f/"+file.Id, body)
+	r.Header.Set("Content-Type", form.FormDataContentType())
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- ts.serve(r) }()
+
+	part, err := form.CreateFormFile("file", "a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Writes to the pipe return once the handler reads them, so the file
+	// was loaded before it is renamed here.
+	io.WriteString(part, "tw")
+	expectStatus(t, ts.do("bob", "POST", "/drive/rename/"+file.Id, `{"name":"b.txt"}`), http.StatusOK)
+	io.WriteString(part, "o")
+	form.Close()
+	stream.Close()
+
+	w := <-done
+	expectStatus(t, w, http.StatusOK)
+	if got := decode[drive.Resource](t, w); got.Name != "b.txt" || got.Version != 2 {
+		t.Fatalf("unexpected file: %+v", got)
+	}
+
+	if got := ts.resource(file.Id); got.Name != "b.txt" {
+		t.Fatalf("name = %s, the rename was undone", got.Name)
+	}
+
+	expectBody(t, ts.do("bob", "GET", "/drive/f/"+file.Id, ""), "two")
+}
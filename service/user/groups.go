@@ -0,0 +1,247 @@
+package user
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/c4me-caro/drive"
+	"github.com/c4me-caro/drive/cmd/auth"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+type groupRequest struct {
+	Name string `json:"name"`
+}
+
+type memberRequest struct {
+	User string `json:"user"`
+}
+
+func (h Handler) registerGroupRoutes(router *mux.Router) {
+	router.HandleFunc("/groups", h.handleNewGroup).Methods("POST")
+	router.HandleFunc("/groups", h.handleGroups).Methods("GET")
+	router.HandleFunc("/groups/{id}", h.handleGroup).Methods("GET")
+	router.HandleFunc("/groups/{id}", h.handleDeleteGroup).Methods("DELETE")
+	router.HandleFunc("/groups/{id}/members", h.handleAddMember).Methods("POST")
+	router.HandleFunc("/groups/{id}/members/{user}", h.handleRemoveMember).Methods("DELETE")
+}
+
+func (h Handler) currentUser(r *http.Request) (drive.User, error) {
+	userId, err := auth.GetUserIdFromToken(r.Header.Get("Authorization"))
+	if err != nil {
+		return drive.User{}, err
+	}
+
+	return h.db.GetUserById(userId)
+}
+
+// memberGroup returns the group of the request when user is one of its
+// members.
+func (h Handler) memberGroup(w http.ResponseWriter, r *http.Request, user drive.User) (drive.Group, bool) {
+	group, err := h.db.GetGroup(mux.Vars(r)["id"])
+	if err != nil || !slices.Contains(group.Members, user.Id) {
+		w.WriteHeader(http.StatusNotFound)
+		io.WriteString(w, "Error: Group not found")
+		return drive.Group{}, false
+	}
+
+	return group, true
+}
+
+// handleNewGroup creates a group owned by the caller, who is its first
+// member. Permissions of groups are managed like those of users, outside
+// the API.
+func (h Handler) handleNewGroup(w http.ResponseWriter, r *http.Request) {
+	user, err := h.currentUser(r)
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		io.WriteString(w, "Error: User not authorized: " + err.Error())
+		return
+	}
+
+	var body groupRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Name == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		io.WriteString(w, "Error: Invalid group, a name is required")
+		return
+	}
+
+	group := drive.Group{
+		Id:          uuid.New().String(),
+		Name:        body.Name,
+		OwnerId:     user.Id,
+		Members:     []string{user.Id},
+		Permissions: []string{},
+		CreatedAt:   time.Now().UTC(),
+	}
+
+	if err := h.db.CreateGroup(group); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(w, "Error: Failed group creation")
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(group); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(w, "Error: bad json coding")
+		return
+	}
+}
+
+func (h Handler) handleGroups(w http.ResponseWriter, r *http.Request) {
+	user, err := h.currentUser(r)
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		io.WriteString(w, "Error: User not authorized: " + err.Error())
+		return
+	}
+
+	groups, err := h.db.ListGroups(user.Id)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(w, "Error: Failed listing groups")
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(groups); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(w, "Error: bad json coding")
+		return
+	}
+}
+
+func (h Handler) handleGroup(w http.ResponseWriter, r *http.Request) {
+	user, err := h.currentUser(r)
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		io.WriteString(w, "Error: User not authorized: " + err.Error())
+		return
+	}
+
+	group, ok := h.memberGroup(w, r, user)
+	if !ok {
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(group); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(w, "Error: bad json coding")
+		return
+	}
+}
+
+func (h Handler) handleDeleteGroup(w http.ResponseWriter, r *http.Request) {
+	user, err := h.currentUser(r)
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		io.WriteString(w, "Error: User not authorized: " + err.Error())
+		return
+	}
+
+	group, ok := h.memberGroup(w, r, user)
+	if !ok {
+		return
+	}
+
+	if group.OwnerId != user.Id {
+		w.WriteHeader(http.StatusForbidden)
+		io.WriteString(w, "Error: Only the owner can delete the group")
+		return
+	}
+
+	if err := h.db.DeleteGroup(group.Id); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(w, "Error: Failed group deletion")
+		return
+	}
+
+	auth.InvalidateUser(group.Members...)
+
+	io.WriteString(w, "Group deleted")
+}
+
+func (h Handler) handleAddMember(w http.ResponseWriter, r *http.Request) {
+	user, err := h.currentUser(r)
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		io.WriteString(w, "Error: User not authorized: " + err.Error())
+		return
+	}
+
+	group, ok := h.memberGroup(w, r, user)
+	if !ok {
+		return
+	}
+
+	if group.OwnerId != user.Id {
+		w.WriteHeader(http.StatusForbidden)
+		io.WriteString(w, "Error: Only the owner can add members")
+		return
+	}
+
+	var body memberRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.User == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		io.WriteString(w, "Error: Invalid member")
+		return
+	}
+
+	if _, err := h.db.GetUserById(body.User); err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		io.WriteString(w, "Error: User not found: " + body.User)
+		return
+	}
+
+	if err := h.db.AddGroupMember(group.Id, body.User); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(w, "Error: Failed group update")
+		return
+	}
+
+	auth.InvalidateUser(body.User)
+
+	io.WriteString(w, "Member added")
+}
+
+// handleRemoveMember drops a member from the group. Besides the owner,
+// members may leave on their own. The owner stays a member.
+func (h Handler) handleRemoveMember(w http.ResponseWriter, r *http.Request) {
+	user, err := h.currentUser(r)
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		io.WriteString(w, "Error: User not authorized: " + err.Error())
+		return
+	}
+
+	group, ok := h.memberGroup(w, r, user)
+	if !ok {
+		return
+	}
+
+	member := mux.Vars(r)["user"]
+	if group.OwnerId != user.Id && member != user.Id {
+		w.WriteHeader(http.StatusForbidden)
+		io.WriteString(w, "Error: Only the owner can remove members")
+		return
+	}
+
+	if member == group.OwnerId {
+		w.WriteHeader(http.StatusConflict)
+		io.WriteString(w, "Error: The owner cannot leave the group")
+		return
+	}
+
+	if err := h.db.RemoveGroupMember(group.Id, member); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(w, "Error: Failed group update")
+		return
+	}
+
+	auth.InvalidateUser(member)
+
+	io.WriteString(w, "Member removed")
+}
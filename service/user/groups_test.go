@@ -0,0 +1,103 @@
+package user
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"testing"
+
+	"github.com/c4me-caro/drive"
+)
+
+func (ts *testServer) newGroup(token string, name string) drive.Group {
+	ts.t.Helper()
+
+	w := ts.do("POST", "/groups", token, `{"name":"`+name+`"}`)
+	expectStatus(ts.t, w, http.StatusOK)
+
+	var group drive.Group
+	if err := json.NewDecoder(w.Body).Decode(&group); err != nil {
+		ts.t.Fatal(err)
+	}
+
+	return group
+}
+
+func TestNewGroup(t *testing.T) {
+	ts := newTestServer(t)
+	alice := ts.login("alice").AccessToken
+
+	group := ts.newGroup(alice, "editors")
+	if group.OwnerId != "u1" || !slices.Equal(group.Members, []string{"u1"}) {
+		t.Fatalf("unexpected group: %+v", group)
+	}
+
+	expectStatus(t, ts.do("POST", "/groups", alice, `{}`), http.StatusBadRequest)
+	expectStatus(t, ts.do("POST", "/groups", alice, `not json`), http.StatusBadRequest)
+	expectStatus(t, ts.do("POST", "/groups", "not a token", `{"name":"x"}`), http.StatusUnauthorized)
+}
+
+func TestGroups(t *testing.T) {
+	ts := newTestServer(t)
+	alice := ts.login("alice").AccessToken
+	bob := ts.login("bob").AccessToken
+	group := ts.newGroup(alice, "editors")
+
+	w := ts.do("GET", "/groups", alice, "")
+	expectStatus(t, w, http.StatusOK)
+
+	var groups []drive.Group
+	if err := json.NewDecoder(w.Body).Decode(&groups); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(groups) != 1 || groups[0].Id != group.Id {
+		t.Fatalf("groups = %+v", groups)
+	}
+
+	expectStatus(t, ts.do("GET", "/groups/"+group.Id, alice, ""), http.StatusOK)
+
+	// Groups are hidden from those outside them.
+	expectStatus(t, ts.do("GET", "/groups/"+group.Id, bob, ""), http.StatusNotFound)
+	expectStatus(t, ts.do("GET", "/groups/missing", alice, ""), http.StatusNotFound)
+}
+
+func TestGroupMembers(t *testing.T) {
+	ts := newTestServer(t)
+	alice := ts.login("alice").AccessToken
+	bob := ts.login("bob").AccessToken
+	carol := ts.login("carol").AccessToken
+	group := ts.newGroup(alice, "editors")
+	members := "/groups/" + group.Id + "/members"
+
+	expectStatus(t, ts.do("POST", members, bob, `{"user":"u3"}`), http.StatusNotFound)
+	expectStatus(t, ts.do("POST", members, alice, `{}`), http.StatusBadRequest)
+	expectStatus(t, ts.do("POST", members, alice, `{"user":"ghost"}`), http.StatusNotFound)
+	expectStatus(t, ts.do("POST", members, alice, `{"user":"u2"}`), http.StatusOK)
+	expectStatus(t, ts.do("POST", members, alice, `{"user":"u3"}`), http.StatusOK)
+
+	expectStatus(t, ts.do("GET", "/groups/"+group.Id, bob, ""), http.StatusOK)
+	expectStatus(t, ts.do("POST", members, bob, `{"user":"u3"}`), http.StatusForbidden)
+
+	// Members may leave, but only the owner removes others and the owner
+	// cannot leave.
+	expectStatus(t, ts.do("DELETE", members+"/u3", bob, ""), http.StatusForbidden)
+	expectStatus(t, ts.do("DELETE", members+"/u1", alice, ""), http.StatusConflict)
+	expectStatus(t, ts.do("DELETE", members+"/u3", carol, ""), http.StatusOK)
+	expectStatus(t, ts.do("GET", "/groups/"+group.Id, carol, ""), http.StatusNotFound)
+	expectStatus(t, ts.do("DELETE", members+"/u2", alice, ""), http.StatusOK)
+	expectStatus(t, ts.do("GET", "/groups/"+group.Id, bob, ""), http.StatusNotFound)
+}
+
+func TestDeleteGroup(t *testing.T) {
+	ts := newTestServer(t)
+	alice := ts.login("alice").AccessToken
+	bob := ts.login("bob").AccessToken
+	group := ts.newGroup(alice, "editors")
+	expectStatus(t, ts.do("POST", "/groups/"+group.Id+"/members", alice, `{"user":"u2"}`), http.StatusOK)
+
+	expectStatus(t, ts.do("DELETE", "/groups/"+group.Id, bob, ""), http.StatusForbidden)
+	expectStatus(t, ts.do("DELETE", "/groups/"+group.Id, alice, ""), http.StatusOK)
+	expectStatus(t, ts.do("GET", "/groups/"+group.Id, alice, ""), http.StatusNotFound)
+	expectStatus(t, ts.do("DELETE", "/groups/"+group.Id, alice, ""), http.StatusNotFound)
+}
@@ -10,11 +10,17 @@ import (
 	"github.com/gorilla/mux"
 )
 
+// Store is what the user handlers need: accounts and their groups.
+type Store interface {
+	database.UserStore
+	database.GroupStore
+}
+
 type Handler struct {
-	db *database.DriveWorker
+	db Store
 }
 
-func NewHandler(db *database.DriveWorker) *Handler {
+func NewHandler(db Store) *Handler {
 	return &Handler{
 		db: db,
 	}
@@ -25,6 +31,9 @@ func (h Handler) RegisterRoutes(router *mux.Router) {
 	router.HandleFunc("/newApiKey", h.handleNewApiKey).Methods("GET")
 	router.HandleFunc("/validateUser", h.handleValidUser).Methods("GET")
 	router.HandleFunc("/logout", h.handleLogout).Methods("GET")
+	router.HandleFunc("/logoutAll", h.handleLogoutAll).Methods("POST")
+	router.HandleFunc("/token/refresh", h.handleRefresh).Methods("POST")
+	h.registerGroupRoutes(router)
 }
 
 func (h Handler) handleNewApiKey(w http.ResponseWriter, r *http.Request) {
@@ -94,17 +103,88 @@ func (h Handler) handleLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	token, err := auth.CreateJWT(user.Id)
+	tokens, err := auth.CreateJWT(user.Id)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		io.WriteString(w, "Error: token generation failed")
 		return
 	}
 
-	io.WriteString(w, token)
+	if err := json.NewEncoder(w).Encode(tokens); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(w, "Error: bad json coding")
+		return
+	}
+}
+
+// handleRefresh rotates a refresh token. It is reached without an access
+// token, which may have expired already.
+func (h Handler) handleRefresh(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		RefreshToken string `json:"refreshToken"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.RefreshToken == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		io.WriteString(w, "Error: Invalid request, a refreshToken is required")
+		return
+	}
+
+	tokens, err := auth.RefreshJWT(body.RefreshToken, func(userId string) error {
+		_, err := h.db.GetUserById(userId)
+		return err
+	})
+
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		io.WriteString(w, "Error: Refresh token is not valid: " + err.Error())
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(tokens); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(w, "Error: bad json coding")
+		return
+	}
 }
 
 func (h Handler) handleLogout(w http.ResponseWriter, r *http.Request) {
 	auth.InvalidateToken(r.Header.Get("Authorization"))
 	io.WriteString(w, "Logout successfully")
 }
+
+// handleLogoutAll revokes every token of the caller, or of the user in the
+// query when the caller is an admin.
+func (h Handler) handleLogoutAll(w http.ResponseWriter, r *http.Request) {
+	user, err := h.currentUser(r)
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		io.WriteString(w, "Error: User not authorized: " + err.Error())
+		return
+	}
+
+	userId := user.Id
+	if target := r.URL.Query().Get("user"); target != "" && target != user.Id {
+		if user.Role != "admin" {
+			w.WriteHeader(http.StatusForbidden)
+			io.WriteString(w, "Error: Only admins can end the sessions of other users")
+			return
+		}
+
+		if _, err := h.db.GetUserById(target); err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, "Error: User not found: " + target)
+			return
+		}
+
+		userId = target
+	}
+
+	if err := auth.RevokeUserSessions(userId); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(w, "Error: Failed session revocation")
+		return
+	}
+
+	io.WriteString(w, "Sessions revoked")
+}
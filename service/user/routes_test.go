@@ -0,0 +1,192 @@
+package user
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/c4me-caro/drive"
+	"github.com/c4me-caro/drive/cmd/auth"
+	"github.com/c4me-caro/drive/database"
+	"github.com/gorilla/mux"
+)
+
+type testServer struct {
+	t      *testing.T
+	db     *database.MemoryStore
+	router *mux.Router
+}
+
+// newTestServer serves the user routes behind the authorization middleware
+// from a memory store holding alice, an admin, bob and carol.
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+
+	db := database.NewMemoryStore()
+	for _, user := range []drive.User{
+		{Id: "u1", Name: "alice", Role: "admin", Password: "pw", Permissions: []string{"read:sys-all", "all:all"}},
+		{Id: "u2", Name: "bob", Password: "pw", Permissions: []string{"read:sys-all"}},
+		{Id: "u3", Name: "carol", Password: "pw", Permissions: []string{"read:sys-all"}},
+	} {
+		if err := db.CreateUser(user); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	auth.UseGrants(db)
+	if err := auth.UseRevocations(db); err != nil {
+		t.Fatal(err)
+	}
+
+	router := mux.NewRouter().StrictSlash(true)
+	NewHandler(db).RegisterRoutes(router)
+	router.Use(auth.HandleAuthorization)
+
+	return &testServer{t: t, db: db, router: router}
+}
+
+func (ts *testServer) do(method string, path string, token string, body string) *httptest.ResponseRecorder {
+	ts.t.Helper()
+
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		r.Header.Set("Authorization", token)
+	}
+
+	w := httptest.NewRecorder()
+	ts.router.ServeHTTP(w, r)
+	return w
+}
+
+func (ts *testServer) login(name string) auth.Tokens {
+	ts.t.Helper()
+
+	w := ts.do("POST", "/login", "", `{"username":"`+name+`","password":"pw"}`)
+	if w.Code != http.StatusOK {
+		ts.t.Fatalf("login %s: %d %s", name, w.Code, w.Body)
+	}
+
+	var tokens auth.Tokens
+	if err := json.NewDecoder(w.Body).Decode(&tokens); err != nil {
+		ts.t.Fatal(err)
+	}
+
+	return tokens
+}
+
+func expectStatus(t *testing.T, w *httptest.ResponseRecorder, code int) {
+	t.Helper()
+
+	if w.Code != code {
+		t.Fatalf("status = %d, want %d: %s", w.Code, code, w.Body)
+	}
+}
+
+func TestLogin(t *testing.T) {
+	ts := newTestServer(t)
+
+	tokens := ts.login("alice")
+	if tokens.AccessToken == "" || tokens.RefreshToken == "" || tokens.ExpiresIn <= 0 {
+		t.Fatalf("incomplete tokens: %+v", tokens)
+	}
+
+	expectStatus(t, ts.do("POST", "/login", "", `{"username":"alice","password":"wrong"}`), http.StatusUnauthorized)
+	expectStatus(t, ts.do("POST", "/login", "", `{"username":"nobody","password":"pw"}`), http.StatusUnauthorized)
+}
+
+func TestAuthorizationRequired(t *testing.T) {
+	ts := newTestServer(t)
+
+	for _, token := range []string{"", "not a token"} {
+		expectStatus(t, ts.do("GET", "/validateUser", token, ""), http.StatusUnauthorized)
+	}
+
+	// A refresh token is not accepted where an access token is expected.
+	expectStatus(t, ts.do("GET", "/validateUser", ts.login("bob").RefreshToken, ""), http.StatusUnauthorized)
+}
+
+func TestNewApiKey(t *testing.T) {
+	ts := newTestServer(t)
+	token := ts.login("bob").AccessToken
+
+	w := ts.do("GET", "/newApiKey", token, "")
+	expectStatus(t, w, http.StatusOK)
+	if random, signature, ok := strings.Cut(w.Body.String(), ":"); !ok || random == "" || signature == "" {
+		t.Fatalf("malformed api key: %q", w.Body)
+	}
+
+	expectStatus(t, ts.do("GET", "/newApiKey", "not a token", ""), http.StatusUnauthorized)
+}
+
+func TestValidateUser(t *testing.T) {
+	ts := newTestServer(t)
+	token := ts.login("bob").AccessToken
+
+	w := ts.do("GET", "/validateUser", token, "")
+	expectStatus(t, w, http.StatusOK)
+	if w.Body.String() != token {
+		t.Fatalf("body = %q, want the token", w.Body)
+	}
+}
+
+func TestLogout(t *testing.T) {
+	ts := newTestServer(t)
+	tokens := ts.login("bob")
+
+	expectStatus(t, ts.do("GET", "/logout", tokens.AccessToken, ""), http.StatusOK)
+	expectStatus(t, ts.do("GET", "/validateUser", tokens.AccessToken, ""), http.StatusUnauthorized)
+
+	// The refresh token belongs to the same session.
+	expectStatus(t, ts.do("POST", "/token/refresh", "", `{"refreshToken":"`+tokens.RefreshToken+`"}`), http.StatusUnauthorized)
+}
+
+func TestLogoutAll(t *testing.T) {
+	ts := newTestServer(t)
+	alice := ts.login("alice").AccessToken
+	bob := ts.login("bob").AccessToken
+	other := ts.login("bob").AccessToken
+
+	expectStatus(t, ts.do("POST", "/logoutAll?user=u1", bob, ""), http.StatusForbidden)
+	expectStatus(t, ts.do("POST", "/logoutAll?user=ghost", alice, ""), http.StatusNotFound)
+
+	expectStatus(t, ts.do("POST", "/logoutAll", bob, ""), http.StatusOK)
+	expectStatus(t, ts.do("GET", "/validateUser", other, ""), http.StatusUnauthorized)
+	expectStatus(t, ts.do("POST", "/logoutAll", bob, ""), http.StatusUnauthorized)
+
+	carol := ts.login("carol").AccessToken
+	expectStatus(t, ts.do("POST", "/logoutAll?user=u3", alice, ""), http.StatusOK)
+	expectStatus(t, ts.do("GET", "/validateUser", carol, ""), http.StatusUnauthorized)
+	expectStatus(t, ts.do("GET", "/validateUser", alice, ""), http.StatusOK)
+
+	// Sessions started afterwards are not affected.
+	expectStatus(t, ts.do("GET", "/validateUser", ts.login("bob").AccessToken, ""), http.StatusOK)
+}
+
+func TestRefresh(t *testing.T) {
+	ts := newTestServer(t)
+	tokens := ts.login("bob")
+
+	w := ts.do("POST", "/token/refresh", "", `{"refreshToken":"`+tokens.RefreshToken+`"}`)
+	expectStatus(t, w, http.StatusOK)
+
+	var refreshed auth.Tokens
+	if err := json.NewDecoder(w.Body).Decode(&refreshed); err != nil {
+		t.Fatal(err)
+	}
+
+	if refreshed.RefreshToken == tokens.RefreshToken {
+		t.Fatal("refresh token was not rotated")
+	}
+
+	expectStatus(t, ts.do("GET", "/validateUser", refreshed.AccessToken, ""), http.StatusOK)
+
+	// Reusing the old refresh token revokes the whole session.
+	expectStatus(t, ts.do("POST", "/token/refresh", "", `{"refreshToken":"`+tokens.RefreshToken+`"}`), http.StatusUnauthorized)
+	expectStatus(t, ts.do("GET", "/validateUser", refreshed.AccessToken, ""), http.StatusUnauthorized)
+
+	expectStatus(t, ts.do("POST", "/token/refresh", "", `{"refreshToken":"`+tokens.AccessToken+`"}`), http.StatusUnauthorized)
+	expectStatus(t, ts.do("POST", "/token/refresh", "", `{}`), http.StatusBadRequest)
+	expectStatus(t, ts.do("POST", "/token/refresh", "", `not json`), http.StatusBadRequest)
+}
@@ -0,0 +1,34 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+type Info struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+type Backend interface {
+	Put(key string, r io.Reader) (int64, error)
+	Append(key string, r io.Reader) (int64, error)
+	Get(key string) (io.ReadSeekCloser, error)
+	Stat(key string) (Info, error)
+	Delete(key string) error
+	Rename(from string, to string) error
+	List() ([]string, error)
+}
+
+func NewBackend(kind string, root string) (Backend, error) {
+	switch kind {
+	case "", "local":
+		return NewLocalBackend(root)
+	case "memory":
+		return NewMemoryBackend(), nil
+	}
+
+	return nil, fmt.Errorf("unknown storage backend: %s", kind)
+}
@@ -0,0 +1,168 @@
+package storage
+
+import (
+	"io"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// backends returns one of each backend, empty.
+func backends(t *testing.T) map[string]Backend {
+	local, err := NewLocalBackend(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return map[string]Backend{
+		"local":  local,
+		"memory": NewMemoryBackend(),
+	}
+}
+
+func readAll(t *testing.T, backend Backend, key string) string {
+	t.Helper()
+
+	r, err := backend.Get(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer r.Close()
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(data)
+}
+
+func TestBackends(t *testing.T) {
+	for name, backend := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			written, err := backend.Put("a/b.txt", strings.NewReader("hello"))
+			if err != nil || written != 5 {
+				t.Fatalf("put = %d, %v", written, err)
+			}
+
+			if got := readAll(t, backend, "a/b.txt"); got != "hello" {
+				t.Fatalf("content = %q", got)
+			}
+
+			if _, err := backend.Put("a/b.txt", strings.NewReader("bye")); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := readAll(t, backend, "a/b.txt"); got != "bye" {
+				t.Fatalf("content after overwrite = %q", got)
+			}
+
+			if _, err := backend.Append("a/b.txt", strings.NewReader("!")); err != nil {
+				t.Fatal(err)
+			}
+
+			info, err := backend.Stat("a/b.txt")
+			if err != nil || info.Size != 4 || info.Key != "a/b.txt" || info.ModTime.IsZero() {
+				t.Fatalf("stat = %+v, %v", info, err)
+			}
+
+			r, err := backend.Get("a/b.txt")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := r.Seek(1, io.SeekStart); err != nil {
+				t.Fatal(err)
+			}
+
+			rest, _ := io.ReadAll(r)
+			r.Close()
+			if string(rest) != "ye!" {
+				t.Fatalf("content after seek = %q", rest)
+			}
+
+			if err := backend.Rename("a/b.txt", "c/d.txt"); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := backend.Stat("a/b.txt"); err == nil {
+				t.Fatal("renamed object is still there")
+			}
+
+			if _, err := backend.Append("e.txt", strings.NewReader("new")); err != nil {
+				t.Fatal(err)
+			}
+
+			keys, err := backend.List()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			slices.Sort(keys)
+			if !slices.Equal(keys, []string{"c/d.txt", "e.txt"}) {
+				t.Fatalf("keys = %v", keys)
+			}
+
+			if err := backend.Delete("c/d.txt"); err != nil {
+				t.Fatal(err)
+			}
+
+			for _, err := range []error{backend.Delete("c/d.txt"), backend.Rename("c/d.txt", "f.txt")} {
+				if err == nil {
+					t.Fatal("missing object was changed")
+				}
+			}
+
+			if _, err := backend.Get("c/d.txt"); err == nil {
+				t.Fatal("deleted object is still readable")
+			}
+
+			if _, err := backend.Put("", strings.NewReader("x")); err == nil {
+				t.Fatal("empty key was accepted")
+			}
+		})
+	}
+}
+
+func TestLocalBackendKeys(t *testing.T) {
+	root := t.TempDir()
+	backend, err := NewLocalBackend(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{"..", "../outside", "a/../../outside", filepath.Join(filepath.Dir(root), "outside")} {
+		if _, err := backend.Put(key, strings.NewReader("x")); err == nil {
+			t.Errorf("key %s escaped the root", key)
+		}
+	}
+
+	// Older resources stored absolute paths inside the root.
+	if _, err := backend.Put("a.txt", strings.NewReader("hello")); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := readAll(t, backend, filepath.Join(root, "a.txt")); got != "hello" {
+		t.Fatalf("content = %q", got)
+	}
+
+	if _, err := NewLocalBackend(""); err == nil {
+		t.Fatal("a backend without root was created")
+	}
+}
+
+func TestNewBackend(t *testing.T) {
+	if backend, err := NewBackend("memory", ""); err != nil || backend == nil {
+		t.Fatalf("memory backend = %v, %v", backend, err)
+	}
+
+	if _, err := NewBackend("local", t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewBackend("s3", ""); err == nil {
+		t.Fatal("unknown backend was created")
+	}
+}
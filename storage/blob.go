@@ -0,0 +1,30 @@
+package storage
+
+import "strings"
+
+const blobPrefix = "blobs/"
+
+// BlobKey returns the content addressed key of the bytes hashing to hash.
+// Keys are spread over subdirectories by the first byte of the hash.
+func BlobKey(hash string) string {
+	if len(hash) < 2 {
+		return blobPrefix + hash
+	}
+
+	return blobPrefix + hash[:2] + "/" + hash
+}
+
+// BlobHash returns the hash a content addressed key was built from. Keys
+// written before deduplication, named <uuid>_<filename>, report false.
+func BlobHash(key string) (string, bool) {
+	if !strings.HasPrefix(key, blobPrefix) {
+		return "", false
+	}
+
+	hash := key[strings.LastIndex(key, "/")+1:]
+	if BlobKey(hash) != key {
+		return "", false
+	}
+
+	return hash, true
+}
@@ -0,0 +1,22 @@
+package storage
+
+import "testing"
+
+func TestBlobKey(t *testing.T) {
+	hash := "ab12cd"
+	key := BlobKey(hash)
+	if key != "blobs/ab/ab12cd" {
+		t.Fatalf("key = %s", key)
+	}
+
+	if got, ok := BlobHash(key); !ok || got != hash {
+		t.Fatalf("hash of %s = %s, %v", key, got, ok)
+	}
+
+	// Locations from before deduplication are not blobs.
+	for _, key := range []string{"0c7e8e2a-1d3f_report.pdf", "blobs/cd/ab12cd", "blobs/ab/x/ab12cd", "uploads/ab12cd"} {
+		if _, ok := BlobHash(key); ok {
+			t.Errorf("%s was taken for a blob", key)
+		}
+	}
+}
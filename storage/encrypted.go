@@ -0,0 +1,184 @@
+package storage
+
+import (
+	"bufio"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Encrypted content is split into chunks sealed one by one with AES-GCM,
+// so a reader can seek to any chunk without decrypting what comes before.
+// The nonce is the chunk index, which is safe because every blob has its
+// own data key, and the last chunk is marked to detect truncation.
+const chunkSize = 64 << 10
+
+func chunkNonce(gcm cipher.AEAD, index int64) []byte {
+	nonce := make([]byte, gcm.NonceSize())
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], uint64(index))
+	return nonce
+}
+
+func chunkData(last bool) []byte {
+	if last {
+		return []byte{1}
+	}
+
+	return []byte{0}
+}
+
+type encryptingReader struct {
+	gcm   cipher.AEAD
+	src   *bufio.Reader
+	buf   []byte
+	out   []byte
+	index int64
+	done  bool
+}
+
+// Encrypt returns a reader producing the encrypted form of r.
+func Encrypt(r io.Reader, dataKey []byte) (io.Reader, error) {
+	gcm, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &encryptingReader{
+		gcm: gcm,
+		src: bufio.NewReader(r),
+		buf: make([]byte, chunkSize),
+	}, nil
+}
+
+func (er *encryptingReader) Read(p []byte) (int, error) {
+	for len(er.out) == 0 {
+		if er.done {
+			return 0, io.EOF
+		}
+
+		n, err := io.ReadFull(er.src, er.buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return 0, err
+		}
+
+		last := err != nil
+		if !last {
+			if _, perr := er.src.Peek(1); perr == io.EOF {
+				last = true
+			} else if perr != nil {
+				return 0, perr
+			}
+		}
+
+		er.out = er.gcm.Seal(er.out[:0], chunkNonce(er.gcm, er.index), er.buf[:n], chunkData(last))
+		er.index++
+		er.done = last
+	}
+
+	n := copy(p, er.out)
+	er.out = er.out[n:]
+	return n, nil
+}
+
+type decryptingReader struct {
+	gcm       cipher.AEAD
+	src       io.ReadSeekCloser
+	encrypted int64
+	chunks    int64
+	size      int64
+	offset    int64
+	loaded    int64
+	buf       []byte
+	plain     []byte
+}
+
+// NewDecryptingReader serves the plaintext of src, which holds encrypted
+// bytes of the given size, with support for seeking.
+func NewDecryptingReader(src io.ReadSeekCloser, dataKey []byte, encrypted int64) (io.ReadSeekCloser, error) {
+	gcm, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	sealed := int64(chunkSize + gcm.Overhead())
+	chunks := (encrypted + sealed - 1) / sealed
+	size := encrypted - chunks*int64(gcm.Overhead())
+	if chunks == 0 || size < 0 {
+		return nil, fmt.Errorf("invalid encrypted object size: %d", encrypted)
+	}
+
+	return &decryptingReader{
+		gcm:       gcm,
+		src:       src,
+		encrypted: encrypted,
+		chunks:    chunks,
+		size:      size,
+		loaded:    -1,
+		buf:       make([]byte, sealed),
+	}, nil
+}
+
+func (dr *decryptingReader) load(index int64) error {
+	sealed := int64(chunkSize + dr.gcm.Overhead())
+	start := index * sealed
+	end := min(start+sealed, dr.encrypted)
+
+	if _, err := dr.src.Seek(start, io.SeekStart); err != nil {
+		return err
+	}
+
+	if _, err := io.ReadFull(dr.src, dr.buf[:end-start]); err != nil {
+		return err
+	}
+
+	plain, err := dr.gcm.Open(dr.plain[:0], chunkNonce(dr.gcm, index), dr.buf[:end-start], chunkData(index == dr.chunks-1))
+	if err != nil {
+		return fmt.Errorf("corrupted chunk %d: %w", index, err)
+	}
+
+	dr.plain = plain
+	dr.loaded = index
+	return nil
+}
+
+func (dr *decryptingReader) Read(p []byte) (int, error) {
+	if dr.offset >= dr.size {
+		return 0, io.EOF
+	}
+
+	index := dr.offset / chunkSize
+	if index != dr.loaded {
+		if err := dr.load(index); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, dr.plain[dr.offset-index*chunkSize:])
+	dr.offset += int64(n)
+	return n, nil
+}
+
+func (dr *decryptingReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += dr.offset
+	case io.SeekEnd:
+		offset += dr.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+
+	dr.offset = offset
+	return offset, nil
+}
+
+func (dr *decryptingReader) Close() error {
+	return dr.src.Close()
+}
@@ -0,0 +1,115 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"testing"
+)
+
+// sealed encrypts plain and returns the encrypted bytes.
+func sealed(t *testing.T, plain []byte, dataKey []byte) []byte {
+	t.Helper()
+
+	r, err := Encrypt(bytes.NewReader(plain), dataKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encrypted, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return encrypted
+}
+
+func opened(t *testing.T, encrypted []byte, dataKey []byte) io.ReadSeekCloser {
+	t.Helper()
+
+	r, err := NewDecryptingReader(memoryReader{bytes.NewReader(encrypted)}, dataKey, int64(len(encrypted)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return r
+}
+
+func TestEncryptRoundTrip(t *testing.T) {
+	dataKey, _ := NewDataKey()
+	for _, size := range []int{0, 1, chunkSize - 1, chunkSize, chunkSize + 1, 3*chunkSize + 17} {
+		plain := make([]byte, size)
+		rand.Read(plain)
+
+		encrypted := sealed(t, plain, dataKey)
+		if bytes.Contains(encrypted, plain) && size > 0 {
+			t.Fatalf("%d bytes: plaintext left in the output", size)
+		}
+
+		got, err := io.ReadAll(opened(t, encrypted, dataKey))
+		if err != nil || !bytes.Equal(got, plain) {
+			t.Fatalf("%d bytes: decrypted %d bytes, %v", size, len(got), err)
+		}
+	}
+}
+
+func TestDecryptSeek(t *testing.T) {
+	dataKey, _ := NewDataKey()
+	plain := make([]byte, 2*chunkSize+100)
+	rand.Read(plain)
+	r := opened(t, sealed(t, plain, dataKey), dataKey)
+
+	// Ranges crossing chunk boundaries, as Range requests read them.
+	for _, span := range [][2]int64{{0, 10}, {chunkSize - 5, 10}, {2 * chunkSize, 100}, {chunkSize + 3, chunkSize}} {
+		if _, err := r.Seek(span[0], io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+
+		got := make([]byte, span[1])
+		if _, err := io.ReadFull(r, got); err != nil || !bytes.Equal(got, plain[span[0]:span[0]+span[1]]) {
+			t.Fatalf("range %v: %v", span, err)
+		}
+	}
+
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil || size != int64(len(plain)) {
+		t.Fatalf("size = %d, %v", size, err)
+	}
+
+	if n, err := r.Read(make([]byte, 1)); n != 0 || err != io.EOF {
+		t.Fatalf("read at the end = %d, %v", n, err)
+	}
+
+	if _, err := r.Seek(-1, io.SeekStart); err == nil {
+		t.Fatal("seeked before the start")
+	}
+}
+
+func TestDecryptRejectsTampering(t *testing.T) {
+	dataKey, _ := NewDataKey()
+	plain := make([]byte, 2*chunkSize+100)
+	rand.Read(plain)
+	encrypted := sealed(t, plain, dataKey)
+
+	flipped := bytes.Clone(encrypted)
+	flipped[chunkSize+50] ^= 1
+	if _, err := io.ReadAll(opened(t, flipped, dataKey)); err == nil {
+		t.Fatal("a changed chunk was decrypted")
+	}
+
+	// Dropping the last chunk leaves a file that ends on a chunk not
+	// marked as the last.
+	truncated := encrypted[:len(encrypted)-(100+16)]
+	if _, err := io.ReadAll(opened(t, truncated, dataKey)); err == nil {
+		t.Fatal("a truncated file was decrypted")
+	}
+
+	otherKey, _ := NewDataKey()
+	if _, err := io.ReadAll(opened(t, encrypted, otherKey)); err == nil {
+		t.Fatal("a file was decrypted with another key")
+	}
+
+	if _, err := NewDecryptingReader(memoryReader{bytes.NewReader(nil)}, dataKey, 0); err == nil {
+		t.Fatal("an empty object was taken for encrypted content")
+	}
+}
@@ -0,0 +1,163 @@
+package storage
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const keySize = 32
+
+// Keyring holds the master keys wrapping the data key of every encrypted
+// blob. New data keys are wrapped with the current key; the others stay
+// loaded so older blobs remain readable until they are rewrapped.
+type Keyring struct {
+	current string
+	keys    map[string][]byte
+}
+
+// LoadKeyring reads the master keys from file, one "<id> <base64 key>" per
+// line, or takes key as the only master key when no file is given. current
+// names the key used for new data keys and defaults to the last one read.
+// It returns nil when neither a file nor a key is configured.
+func LoadKeyring(file string, key string, current string) (*Keyring, error) {
+	keys := make(map[string][]byte)
+	last := ""
+
+	switch {
+	case file != "":
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+
+		defer f.Close()
+
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+
+			fields := strings.Fields(line)
+			if len(fields) != 2 {
+				return nil, fmt.Errorf("invalid master key line: %s", line)
+			}
+
+			decoded, err := base64.StdEncoding.DecodeString(fields[1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid master key %s: %w", fields[0], err)
+			}
+
+			keys[fields[0]] = decoded
+			last = fields[0]
+		}
+
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	case key != "":
+		decoded, err := base64.StdEncoding.DecodeString(key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid master key: %w", err)
+		}
+
+		last = current
+		if last == "" {
+			last = "default"
+		}
+
+		keys[last] = decoded
+	default:
+		return nil, nil
+	}
+
+	if current == "" {
+		current = last
+	}
+
+	return NewKeyring(current, keys)
+}
+
+func NewKeyring(current string, keys map[string][]byte) (*Keyring, error) {
+	for id, key := range keys {
+		if len(key) != keySize {
+			return nil, fmt.Errorf("master key %s must be %d bytes", id, keySize)
+		}
+	}
+
+	if _, ok := keys[current]; !ok {
+		return nil, fmt.Errorf("master key not found: %s", current)
+	}
+
+	return &Keyring{
+		current: current,
+		keys:    keys,
+	}, nil
+}
+
+// Current returns the id of the key wrapping new data keys.
+func (k *Keyring) Current() string {
+	return k.current
+}
+
+// NewDataKey returns a random key for encrypting a single blob.
+func NewDataKey() ([]byte, error) {
+	key := make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// Wrap encrypts dataKey with the current master key and returns the id of
+// that key along with the wrapped data key.
+func (k *Keyring) Wrap(dataKey []byte) (string, []byte, error) {
+	gcm, err := newGCM(k.keys[k.current])
+	if err != nil {
+		return "", nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", nil, err
+	}
+
+	return k.current, gcm.Seal(nonce, nonce, dataKey, []byte(k.current)), nil
+}
+
+// Unwrap decrypts a data key wrapped by the master key id.
+func (k *Keyring) Unwrap(id string, wrapped []byte) ([]byte, error) {
+	key, ok := k.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("master key not found: %s", id)
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(wrapped) < gcm.NonceSize() {
+		return nil, fmt.Errorf("invalid wrapped key")
+	}
+
+	nonce, sealed := wrapped[:gcm.NonceSize()], wrapped[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, sealed, []byte(id))
+}
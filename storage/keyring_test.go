@@ -0,0 +1,119 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func masterKey(fill byte) []byte {
+	return bytes.Repeat([]byte{fill}, keySize)
+}
+
+func writeKeyFile(t *testing.T, lines ...string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "keys")
+	content := ""
+	for _, line := range lines {
+		content += line + "\n"
+	}
+
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestLoadKeyring(t *testing.T) {
+	old := base64.StdEncoding.EncodeToString(masterKey(1))
+	current := base64.StdEncoding.EncodeToString(masterKey(2))
+	file := writeKeyFile(t, "# rotated in spring", "old "+old, "", "new "+current)
+
+	keys, err := LoadKeyring(file, "", "")
+	if err != nil || keys.Current() != "new" {
+		t.Fatalf("keyring = %v, %v, want the last key current", keys, err)
+	}
+
+	if keys, err := LoadKeyring(file, "", "old"); err != nil || keys.Current() != "old" {
+		t.Fatalf("keyring = %v, %v, want old current", keys, err)
+	}
+
+	if keys, err := LoadKeyring("", old, ""); err != nil || keys.Current() != "default" {
+		t.Fatalf("keyring = %v, %v, want a default key", keys, err)
+	}
+
+	if keys, err := LoadKeyring("", "", ""); err != nil || keys != nil {
+		t.Fatalf("keyring = %v, %v, want none", keys, err)
+	}
+
+	invalid := []string{
+		writeKeyFile(t, "only-an-id"),
+		writeKeyFile(t, "bad not-base64!"),
+		writeKeyFile(t, "short "+base64.StdEncoding.EncodeToString([]byte("short"))),
+	}
+
+	for _, file := range invalid {
+		if _, err := LoadKeyring(file, "", ""); err == nil {
+			t.Errorf("%s was loaded", file)
+		}
+	}
+
+	if _, err := LoadKeyring(file, "", "missing"); err == nil {
+		t.Fatal("a keyring without its current key was loaded")
+	}
+}
+
+func TestKeyringRotation(t *testing.T) {
+	before, err := NewKeyring("old", map[string][]byte{"old": masterKey(1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dataKey, err := NewDataKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id, wrapped, err := before.Wrap(dataKey)
+	if err != nil || id != "old" {
+		t.Fatalf("wrap = %s, %v", id, err)
+	}
+
+	// After the rotation new keys are wrapped with the new master key and
+	// those wrapped before still open.
+	after, err := NewKeyring("new", map[string][]byte{"old": masterKey(1), "new": masterKey(2)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unwrapped, err := after.Unwrap(id, wrapped)
+	if err != nil || !bytes.Equal(unwrapped, dataKey) {
+		t.Fatalf("unwrap = %x, %v", unwrapped, err)
+	}
+
+	id, rewrapped, err := after.Wrap(unwrapped)
+	if err != nil || id != "new" {
+		t.Fatalf("rewrap = %s, %v", id, err)
+	}
+
+	if unwrapped, err := after.Unwrap("new", rewrapped); err != nil || !bytes.Equal(unwrapped, dataKey) {
+		t.Fatalf("unwrap after rewrap = %x, %v", unwrapped, err)
+	}
+
+	// The key id is bound to the wrapped key.
+	if _, err := after.Unwrap("old", rewrapped); err == nil {
+		t.Fatal("a key opened under the wrong id")
+	}
+
+	if _, err := before.Unwrap("new", rewrapped); err == nil {
+		t.Fatal("a key opened without its master key")
+	}
+
+	if _, err := after.Unwrap("new", rewrapped[:4]); err == nil {
+		t.Fatal("a truncated key opened")
+	}
+}
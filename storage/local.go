@@ -0,0 +1,190 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type LocalBackend struct {
+	root string
+}
+
+func NewLocalBackend(root string) (*LocalBackend, error) {
+	if root == "" {
+		return nil, fmt.Errorf("local storage root not configured")
+	}
+
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, err
+	}
+
+	return &LocalBackend{
+		root: filepath.Clean(root),
+	}, nil
+}
+
+// path maps a key to a file below the root. Absolute keys are accepted as
+// long as they point inside the root, which is how older resources stored
+// their location.
+func (lb *LocalBackend) path(key string) (string, error) {
+	if filepath.IsAbs(key) {
+		rel, err := filepath.Rel(lb.root, filepath.Clean(key))
+		if err != nil {
+			return "", err
+		}
+
+		key = filepath.ToSlash(rel)
+	}
+
+	clean := filepath.Clean(filepath.FromSlash(key))
+	if key == "" || clean == "." || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid storage key: %s", key)
+	}
+
+	return filepath.Join(lb.root, clean), nil
+}
+
+func (lb *LocalBackend) Put(key string, r io.Reader) (int64, error) {
+	path, err := lb.path(key)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return 0, err
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return 0, err
+	}
+
+	written, err := io.Copy(file, r)
+	if err != nil {
+		file.Close()
+		os.Remove(path)
+		return written, err
+	}
+
+	if err := file.Close(); err != nil {
+		os.Remove(path)
+		return written, err
+	}
+
+	return written, nil
+}
+
+// Append keeps whatever was written before a failing read, so resumable
+// uploads can continue from the bytes that made it to disk.
+func (lb *LocalBackend) Append(key string, r io.Reader) (int64, error) {
+	path, err := lb.path(key)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return 0, err
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return 0, err
+	}
+
+	written, err := io.Copy(file, r)
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+
+	return written, err
+}
+
+func (lb *LocalBackend) Get(key string) (io.ReadSeekCloser, error) {
+	path, err := lb.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return file, nil
+}
+
+func (lb *LocalBackend) Stat(key string) (Info, error) {
+	path, err := lb.path(key)
+	if err != nil {
+		return Info{}, err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return Info{}, err
+	}
+
+	return Info{
+		Key:     key,
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+	}, nil
+}
+
+func (lb *LocalBackend) Delete(key string) error {
+	path, err := lb.path(key)
+	if err != nil {
+		return err
+	}
+
+	return os.Remove(path)
+}
+
+func (lb *LocalBackend) Rename(from string, to string) error {
+	source, err := lb.path(from)
+	if err != nil {
+		return err
+	}
+
+	target, err := lb.path(to)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(target), 0700); err != nil {
+		return err
+	}
+
+	return os.Rename(source, target)
+}
+
+func (lb *LocalBackend) List() ([]string, error) {
+	keys := []string{}
+	err := filepath.WalkDir(lb.root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(lb.root, path)
+		if err != nil {
+			return err
+		}
+
+		keys = append(keys, filepath.ToSlash(rel))
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
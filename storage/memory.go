@@ -0,0 +1,135 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+type memoryObject struct {
+	data    []byte
+	modTime time.Time
+}
+
+type memoryReader struct {
+	*bytes.Reader
+}
+
+func (memoryReader) Close() error {
+	return nil
+}
+
+type MemoryBackend struct {
+	mu      sync.RWMutex
+	objects map[string]memoryObject
+}
+
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{
+		objects: make(map[string]memoryObject),
+	}
+}
+
+func (mb *MemoryBackend) Put(key string, r io.Reader) (int64, error) {
+	if key == "" {
+		return 0, fmt.Errorf("invalid storage key: %s", key)
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return int64(len(data)), err
+	}
+
+	mb.mu.Lock()
+	mb.objects[key] = memoryObject{data: data, modTime: time.Now()}
+	mb.mu.Unlock()
+
+	return int64(len(data)), nil
+}
+
+func (mb *MemoryBackend) Append(key string, r io.Reader) (int64, error) {
+	if key == "" {
+		return 0, fmt.Errorf("invalid storage key: %s", key)
+	}
+
+	data, err := io.ReadAll(r)
+
+	mb.mu.Lock()
+	object := mb.objects[key]
+	object.data = append(object.data, data...)
+	object.modTime = time.Now()
+	mb.objects[key] = object
+	mb.mu.Unlock()
+
+	return int64(len(data)), err
+}
+
+func (mb *MemoryBackend) Get(key string) (io.ReadSeekCloser, error) {
+	mb.mu.RLock()
+	object, ok := mb.objects[key]
+	mb.mu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("object not found: %s", key)
+	}
+
+	return memoryReader{bytes.NewReader(object.data)}, nil
+}
+
+func (mb *MemoryBackend) Stat(key string) (Info, error) {
+	mb.mu.RLock()
+	object, ok := mb.objects[key]
+	mb.mu.RUnlock()
+
+	if !ok {
+		return Info{}, fmt.Errorf("object not found: %s", key)
+	}
+
+	return Info{
+		Key:     key,
+		Size:    int64(len(object.data)),
+		ModTime: object.modTime,
+	}, nil
+}
+
+func (mb *MemoryBackend) Delete(key string) error {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	if _, ok := mb.objects[key]; !ok {
+		return fmt.Errorf("object not found: %s", key)
+	}
+
+	delete(mb.objects, key)
+	return nil
+}
+
+func (mb *MemoryBackend) Rename(from string, to string) error {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	object, ok := mb.objects[from]
+	if !ok {
+		return fmt.Errorf("object not found: %s", from)
+	}
+
+	delete(mb.objects, from)
+	mb.objects[to] = object
+	return nil
+}
+
+func (mb *MemoryBackend) List() ([]string, error) {
+	mb.mu.RLock()
+	defer mb.mu.RUnlock()
+
+	keys := make([]string, 0, len(mb.objects))
+	for key := range mb.objects {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+	return keys, nil
+}